@@ -2,37 +2,114 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"slices"
 	"syscall"
+	"time"
 
+	"github.com/dpeterka/history-slackbot/internal/alert"
+	"github.com/dpeterka/history-slackbot/internal/approval"
+	"github.com/dpeterka/history-slackbot/internal/cache"
 	"github.com/dpeterka/history-slackbot/internal/config"
+	"github.com/dpeterka/history-slackbot/internal/dataset"
+	"github.com/dpeterka/history-slackbot/internal/dedup"
+	"github.com/dpeterka/history-slackbot/internal/health"
+	"github.com/dpeterka/history-slackbot/internal/images"
+	"github.com/dpeterka/history-slackbot/internal/ledger"
 	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
+	"github.com/dpeterka/history-slackbot/internal/moderation"
+	"github.com/dpeterka/history-slackbot/internal/pipeline"
+	"github.com/dpeterka/history-slackbot/internal/prefilter"
 	"github.com/dpeterka/history-slackbot/internal/rss"
 	"github.com/dpeterka/history-slackbot/internal/scheduler"
+	"github.com/dpeterka/history-slackbot/internal/sink"
 	"github.com/dpeterka/history-slackbot/internal/slack"
+	"github.com/dpeterka/history-slackbot/internal/usage"
+	"github.com/dpeterka/history-slackbot/internal/wikipedia"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Starting History Slackbot...")
-
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		slog.Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Set up structured logging; the standard logger is routed through it too
+	logger, err := logging.NewLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		slog.Error("failed to configure logging", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("starting History Slackbot",
+		"model", cfg.ClaudeModel,
+		"max_events", cfg.MaxEvents,
+		"schedule", cfg.ScheduleCron,
+		"run_once", cfg.RunOnce,
+		"destinations", len(cfg.Destinations))
+
+	// Load the local dataset up front so bad files stop the bot at startup
+	var ds *dataset.Dataset
+	if cfg.DatasetDir != "" {
+		ds, err = dataset.Load(cfg.DatasetDir)
+		if err != nil {
+			logger.Error("failed to load dataset", "dir", cfg.DatasetDir, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("loaded dataset", "dir", cfg.DatasetDir, "entries", ds.Len())
+	}
+
+	// Open the run ledger up front so an unwritable path stops the bot
+	var runLedger *ledger.Ledger
+	if cfg.LedgerFile != "" {
+		runLedger, err = ledger.Open(cfg.LedgerFile)
+		if err != nil {
+			logger.Error("failed to open ledger", "file", cfg.LedgerFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Drafts for destinations that need approval go to the review channel
+	var approvals *approval.Service
+	for _, dest := range cfg.Destinations {
+		if cfg.ApprovalFor(dest) {
+			approvals = approval.NewService(approval.Config{
+				WebhookURL:    cfg.ApprovalWebhookURL,
+				SigningSecret: cfg.SlackSigningSecret,
+				BotToken:      cfg.SlackBotToken,
+				Timeout:       cfg.ApprovalTimeout,
+				Default:       cfg.ApprovalDefault,
+			}, runLedger)
+			break
+		}
 	}
 
-	log.Printf("Configuration loaded successfully")
-	log.Printf("Model: %s", cfg.ClaudeModel)
-	log.Printf("Max events: %d", cfg.MaxEvents)
-	log.Printf("Schedule: %s", cfg.ScheduleCron)
-	log.Printf("Run once: %v", cfg.RunOnce)
+	// Pre-generated runs are posted from the cache when valid
+	var store *cache.Store
+	if cfg.CacheDir != "" {
+		store, err = cache.Open(cfg.CacheDir)
+		if err != nil {
+			logger.Error("failed to open cache", "dir", cfg.CacheDir, "error", err)
+			os.Exit(1)
+		}
+	}
 
 	// Create the job that fetches and posts events
-	job := createJob(cfg)
+	pipelines, err := buildPipelines(cfg, ds, runLedger, approvals, store)
+	if err != nil {
+		logger.Error("failed to build pipelines", "error", err)
+		os.Exit(1)
+	}
+	job := pipeline.Job(pipelines...)
 
 	// Create scheduler
 	var sched *scheduler.Scheduler
@@ -43,14 +120,51 @@ func main() {
 		// Parse cron expression and calculate next run time
 		nextRun, err := scheduler.NextRunTime(cfg.ScheduleCron)
 		if err != nil {
-			log.Fatalf("Failed to parse cron expression: %v", err)
+			logger.Error("failed to parse cron expression", "error", err)
+			os.Exit(1)
 		}
 
-		log.Printf("Next scheduled run: %v", nextRun)
+		logger.Info("next scheduled run", "next_run", nextRun)
 
 		sched = scheduler.NewScheduler(job, scheduler.DailyInterval(), false)
 	}
 
+	sched.SetRetryPolicy(scheduler.RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		Backoff:     cfg.RetryBackoff,
+		Cutoff:      cfg.RetryCutoff,
+	})
+
+	// Send failure and recovery reports to the ops webhook
+	if cfg.AlertWebhookURL != "" {
+		sched.OnResult(alert.NewNotifier(cfg.AlertWebhookURL).HandleResult)
+	}
+
+	// Serve metrics and health checks if an address is configured
+	if cfg.HTTPAddr != "" {
+		checker := health.NewChecker(sched, health.Components, health.Waits, cfg.HealthMaxFailures, cfg.HealthStuckAfter)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default.Handler())
+		mux.Handle("/healthz", checker.LivenessHandler())
+		mux.Handle("/readyz", checker.ReadinessHandler())
+		if approvals != nil {
+			mux.Handle("/slack/interactions", approvals.Handler())
+		}
+		server := &http.Server{
+			Addr:              cfg.HTTPAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			logger.Info("serving metrics and health checks", "addr", cfg.HTTPAddr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("HTTP server error", "error", err)
+			}
+		}()
+		defer server.Close()
+	}
+
 	// Setup signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -58,6 +172,14 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Pre-generate each day's posts ahead of the scheduled run
+	if cfg.PregenerateCron != "" && !cfg.RunOnce {
+		pregenerate := pipeline.PregenerateJob(func() (time.Time, error) {
+			return scheduler.NextRunTime(cfg.ScheduleCron)
+		}, pipelines...)
+		go scheduler.RunDaily(ctx, cfg.PregenerateCron, pregenerate)
+	}
+
 	// Start scheduler in a goroutine
 	errChan := make(chan error, 1)
 	go func() {
@@ -73,116 +195,166 @@ func main() {
 	// Wait for shutdown signal or error
 	select {
 	case sig := <-sigChan:
-		log.Printf("Received signal: %v", sig)
+		logger.Info("received signal", "signal", sig)
 		cancel()
 	case err := <-errChan:
 		if err != nil && err != context.Canceled {
-			log.Printf("Scheduler error: %v", err)
+			logger.Error("scheduler error", "error", err)
 		}
 	}
 
-	log.Println("History Slackbot stopped")
+	logger.Info("History Slackbot stopped")
 }
 
-// filterFunHolidays filters out serious/political holidays and keeps only fun ones
-func filterFunHolidays(holidays []rss.Holiday) []rss.Holiday {
-	// Keywords that indicate serious/political/religious holidays to skip
-	seriousKeywords := []string{
-		"International", "World", "National Awareness", "Day for",
-		"Memorial", "Remembrance", "Victims", "Prevention",
-		"Human Rights", "Peace", "Conflict", "War", "Violence",
-		"Exploitation", "Poverty", "Hunger", "Disease",
-		"Awareness Week", "Awareness Month", "Solidarity",
-		"Against", "United Nations", "Commemoration",
-	}
-
-	var funHolidays []rss.Holiday
-	for _, holiday := range holidays {
-		isFun := true
-		title := holiday.Title
-
-		// Check if title contains any serious keywords
-		for _, keyword := range seriousKeywords {
-			if contains(title, keyword) {
-				isFun = false
-				break
-			}
-		}
+// buildPipelines composes one pipeline per configured destination. Sources
+// and the selector are shared; each pipeline makes its own selection.
+// ds is nil when no local dataset is configured, runLedger is nil without
+// LEDGER_FILE, approvals is nil when no destination needs approval, and store
+// is nil without a cache.
+func buildPipelines(cfg *config.Config, ds *dataset.Dataset, runLedger *ledger.Ledger, approvals *approval.Service, store *cache.Store) ([]*pipeline.Pipeline, error) {
+	parser := rss.NewParser()
 
-		if isFun {
-			funHolidays = append(funHolidays, holiday)
-		}
+	eventPrompt, err := cfg.EventPrompt()
+	if err != nil {
+		return nil, err
+	}
+	holidayPrompt, err := cfg.HolidayPrompt()
+	if err != nil {
+		return nil, err
+	}
+	promptData, err := cfg.PromptData()
+	if err != nil {
+		return nil, err
 	}
 
-	return funHolidays
-}
+	selector := llm.NewSelector(cfg.ClaudeAPIKey, cfg.ClaudeModel, cfg.MaxEvents, eventPrompt)
+	selector.SetHolidayPrompt(holidayPrompt)
+	selector.SetPromptCaching(cfg.ClaudePromptCaching)
 
-// contains checks if a string contains a substring (case-insensitive)
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
-		strings.Contains(strings.ToLower(s), strings.ToLower(substr)))
-}
+	// Every call's cost is tracked, and checked against the budgets
+	tracker, err := usage.NewTracker(cfg.ClaudePrices, usage.Budget{
+		Run:   cfg.ClaudeRunBudget,
+		Month: cfg.ClaudeMonthlyBudget,
+		Limit: cfg.ClaudeMonthlyLimit,
+	}, runLedger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage from ledger: %w", err)
+	}
+	selector.SetBudget(tracker, cfg.ClaudeBudgetModel)
 
-// createJob creates the main job function
-func createJob(cfg *config.Config) scheduler.Job {
-	return func(ctx context.Context) error {
-		log.Println("=== Starting job execution ===")
+	sources := []pipeline.Source{
+		&pipeline.FeedSource{Parser: parser, URLs: cfg.RSSFeedURLs},
+	}
+	var wikipediaHolidays *wikipedia.Client
+	if cfg.WikipediaEnabled {
+		client := wikipedia.NewClient(wikipedia.DefaultBaseURL, cfg.WikipediaLanguage)
+		sources = append(sources, &pipeline.WikipediaSource{
+			Client: client,
+			Types:  cfg.WikipediaTypes,
+		})
+		// Holiday entries go to the holiday enricher, not the candidates
+		if slices.Contains(cfg.WikipediaTypes, wikipedia.TypeHolidays) {
+			wikipediaHolidays = client
+		}
+	}
+	if ds != nil {
+		sources = append(sources, &pipeline.DatasetSource{Dataset: ds})
+	}
 
-		// Create RSS parser
-		parser := rss.NewParser()
+	filters := []pipeline.Filter{
+		&pipeline.Dedup{Options: dedup.Options{
+			TitleSimilarity: cfg.DedupTitleSimilarity,
+			TextSimilarity:  cfg.DedupTextSimilarity,
+		}},
+		&pipeline.Prefilter{Options: prefilter.Options{
+			BlockedCategories: cfg.PrefilterBlockedCategories,
+			MaxDescription:    cfg.PrefilterMaxDescription,
+			TokenBudget:       cfg.PrefilterTokenBudget,
+		}},
+	}
 
-		// Fetch events from RSS feeds
-		log.Printf("Fetching events from %d feed(s)...", len(cfg.RSSFeedURLs))
-		events, err := parser.FetchMultipleFeeds(cfg.RSSFeedURLs)
-		if err != nil {
-			return err
+	budgets := map[rss.EventKind]int{
+		rss.KindEvent: cfg.MaxEvents,
+		rss.KindBirth: cfg.MaxBirths,
+		rss.KindDeath: cfg.MaxDeaths,
+	}
+
+	var pipelines []*pipeline.Pipeline
+	for _, dest := range cfg.Destinations {
+		p := &pipeline.Pipeline{
+			Name:    dest.Name,
+			Sources: sources,
+			Filters: filters,
+			Ledger:  runLedger,
+			Selector: &pipeline.ClaudeSelector{
+				Selector: selector,
+				Budgets:  budgets,
+				Prompt:   promptData,
+				History:  runLedger,
+				Fallback: &pipeline.FallbackSelector{Budgets: budgets},
+			},
+			Enrichers: []pipeline.Enricher{
+				&pipeline.ImageChecker{Checker: images.NewChecker()},
+				&pipeline.HolidayEnricher{
+					Parser:      parser,
+					URL:         cfg.HolidayFeedURL,
+					Wikipedia:   wikipediaHolidays,
+					Selector:    selector,
+					MaxHolidays: cfg.MaxHolidays,
+					Prompt:      promptData,
+				},
+			},
 		}
-		log.Printf("Fetched %d events", len(events))
 
-		// Select interesting events using LLM
-		log.Println("Selecting interesting events using Claude...")
-		selector := llm.NewSelector(cfg.ClaudeAPIKey, cfg.ClaudeModel, cfg.MaxEvents, cfg.EventSelectionPrompt)
-		selectedEvents, err := selector.SelectEvents(events)
+		policy, err := cfg.ModerationPolicyFor(dest)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if len(policy) > 0 {
+			p.Reviewers = []pipeline.Reviewer{&pipeline.Moderator{
+				Classifier: moderation.NewClassifier(),
+				Policy:     policy,
+				Softener:   selector,
+			}}
 		}
-		log.Printf("Selected %d events", len(selectedEvents))
 
-		// Fetch holidays
-		var holidays []string
-		if cfg.HolidayFeedURL != "" {
-			log.Println("Fetching fun holidays...")
-			holidayData, err := parser.FetchHolidays(cfg.HolidayFeedURL)
-			if err != nil {
-				log.Printf("Warning: failed to fetch holidays: %v", err)
-			} else {
-				log.Printf("Fetched %d holidays", len(holidayData))
-				// Filter for fun holidays (skip serious/political ones)
-				funHolidays := filterFunHolidays(holidayData)
-				log.Printf("Filtered to %d fun holidays", len(funHolidays))
-
-				// Limit to MaxHolidays
-				maxCount := cfg.MaxHolidays
-				if maxCount > len(funHolidays) {
-					maxCount = len(funHolidays)
-				}
-				for i := 0; i < maxCount; i++ {
-					holidays = append(holidays, funHolidays[i].Title)
-				}
-				log.Printf("Selected %d holidays to display", len(holidays))
-			}
+		if store != nil {
+			p.Cache = store
+			p.CacheMaxAge = cfg.CacheMaxAge
 		}
 
-		// Post to Slack
-		log.Println("Posting to Slack...")
-		poster := slack.NewPoster(cfg.SlackWebhookURL)
-		if err := poster.PostEventsWithHolidays(selectedEvents, holidays); err != nil {
-			return err
+		if cfg.ApprovalFor(dest) {
+			p.Approver = &pipeline.ApprovalGate{Service: approvals, MaxRegenerations: cfg.ApprovalMaxRegenerations}
 		}
 
-		log.Println("Successfully posted to Slack!")
-		log.Println("=== Job execution completed ===")
+		switch dest.Type {
+		case config.DestinationSlack:
+			renderer, err := cfg.RendererFor(dest)
+			if err != nil {
+				return nil, err
+			}
+			p.Renderer = &pipeline.SlackRenderer{Renderer: renderer}
+			p.Sinks = []pipeline.Sink{&pipeline.SlackSink{Poster: slack.NewPoster(dest.WebhookURL)}}
+		case config.DestinationTeams:
+			p.Sinks = []pipeline.Sink{&pipeline.DigestSink{Sink: sink.NewTeams(dest.WebhookURL)}}
+		case config.DestinationDiscord:
+			p.Sinks = []pipeline.Sink{&pipeline.DigestSink{Sink: sink.NewDiscord(dest.WebhookURL)}}
+		case config.DestinationMattermost:
+			p.Sinks = []pipeline.Sink{&pipeline.DigestSink{Sink: sink.NewMattermost(dest.WebhookURL)}}
+		case config.DestinationEmail:
+			p.Sinks = []pipeline.Sink{&pipeline.DigestSink{Sink: sink.NewEmail(sink.EmailConfig{
+				Addr:     dest.Email.SMTPAddr,
+				Username: dest.Email.Username,
+				Password: dest.Email.Password,
+				From:     dest.Email.From,
+				To:       dest.Email.To,
+			})}}
+		default:
+			return nil, fmt.Errorf("destination %q: unknown type %q", dest.Name, dest.Type)
+		}
 
-		return nil
+		pipelines = append(pipelines, p)
 	}
+
+	return pipelines, nil
 }
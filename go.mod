@@ -1,3 +1,7 @@
 module github.com/dpeterka/history-slackbot
 
 go 1.22.2
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/net v0.35.0
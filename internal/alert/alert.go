@@ -0,0 +1,134 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/scheduler"
+)
+
+// StageUnknown is reported when a failure can't be attributed to a stage
+const StageUnknown = "unknown"
+
+// StageOf returns the pipeline stage an error came from. Errors carry their
+// stage by implementing Stage() string anywhere in their wrap chain.
+func StageOf(err error) string {
+	var staged interface{ Stage() string }
+	if errors.As(err, &staged) {
+		return staged.Stage()
+	}
+	return StageUnknown
+}
+
+// Notifier sends alert reports to an ops webhook. The payload is a plain
+// {"text": ...} message, which Slack and Mattermost incoming webhooks accept.
+type Notifier struct {
+	webhookURL string
+	client     *http.Client
+
+	mu       sync.Mutex
+	failures int // Consecutive failures seen so far
+}
+
+// NewNotifier creates a notifier posting to the given webhook URL
+func NewNotifier(webhookURL string) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// HandleResult is a scheduler.ResultHandler. It alerts on every failed run
+// and once more when a run succeeds after one or more failures.
+func (n *Notifier) HandleResult(ctx context.Context, result scheduler.RunResult) {
+	n.mu.Lock()
+	previousFailures := n.failures
+	n.failures = result.ConsecutiveFailures
+	n.mu.Unlock()
+
+	var text string
+	switch {
+	case result.Err != nil:
+		text = FormatFailure(result)
+	case previousFailures > 0:
+		text = FormatRecovery(result, previousFailures)
+	default:
+		return
+	}
+
+	logger := logging.Stage(ctx, "alert")
+	if err := n.send(ctx, text); err != nil {
+		logger.Error("failed to send alert", "error", err)
+		return
+	}
+	logger.Info("sent alert", "recovered", result.Err == nil)
+}
+
+// FormatFailure renders a compact report for a failed run
+func FormatFailure(result scheduler.RunResult) string {
+	var buf strings.Builder
+
+	buf.WriteString(":rotating_light: *History bot run failed*\n")
+	buf.WriteString(fmt.Sprintf("*Stage:* %s\n", StageOf(result.Err)))
+	buf.WriteString(fmt.Sprintf("*Error:* `%s`\n", result.Err))
+	if result.MaxAttempts > 1 {
+		buf.WriteString(fmt.Sprintf("*Attempt:* %d of %d\n", result.Attempt, result.MaxAttempts))
+	}
+	buf.WriteString(fmt.Sprintf("*Consecutive failures:* %d\n", result.ConsecutiveFailures))
+	switch {
+	case result.NextRun.IsZero():
+		buf.WriteString("*Next attempt:* none scheduled\n")
+	case result.Retrying:
+		buf.WriteString(fmt.Sprintf("*Next attempt:* retry at %s\n", result.NextRun.Format("Mon Jan 2 15:04 MST")))
+	default:
+		buf.WriteString(fmt.Sprintf("*Next attempt:* next scheduled run at %s\n", result.NextRun.Format("Mon Jan 2 15:04 MST")))
+	}
+	buf.WriteString(fmt.Sprintf("*Run ID:* %s", result.RunID))
+
+	return buf.String()
+}
+
+// FormatRecovery renders a report for the first successful run after failures
+func FormatRecovery(result scheduler.RunResult, failedRuns int) string {
+	return fmt.Sprintf(":white_check_mark: *History bot recovered* after %d failed run(s)\n*Run ID:* %s (finished %s)",
+		failedRuns, result.RunID, result.End.Format("Mon Jan 2 15:04 MST"))
+}
+
+// send posts a text message to the webhook
+func (n *Notifier) send(ctx context.Context, text string) error {
+	reqBody, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhookURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("alert webhook failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
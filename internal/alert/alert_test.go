@@ -0,0 +1,96 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/scheduler"
+)
+
+type testStageError struct {
+	stage string
+	err   error
+}
+
+func (e *testStageError) Error() string { return e.err.Error() }
+func (e *testStageError) Unwrap() error { return e.err }
+func (e *testStageError) Stage() string { return e.stage }
+
+func TestStageOf(t *testing.T) {
+	staged := &testStageError{stage: "llm", err: errors.New("status 529")}
+
+	if got := StageOf(staged); got != "llm" {
+		t.Errorf("StageOf() = %q, want %q", got, "llm")
+	}
+	if got := StageOf(fmt.Errorf("job failed: %w", staged)); got != "llm" {
+		t.Errorf("StageOf(wrapped) = %q, want %q", got, "llm")
+	}
+	if got := StageOf(errors.New("plain")); got != StageUnknown {
+		t.Errorf("StageOf(plain) = %q, want %q", got, StageUnknown)
+	}
+}
+
+func TestFormatFailure(t *testing.T) {
+	next := time.Date(2024, 7, 21, 9, 0, 0, 0, time.UTC)
+	text := FormatFailure(scheduler.RunResult{
+		RunID:               "abc123",
+		Err:                 &testStageError{stage: "feeds", err: errors.New("no events fetched from any feed")},
+		Attempt:             2,
+		MaxAttempts:         3,
+		ConsecutiveFailures: 2,
+		NextRun:             next,
+		Retrying:            true,
+	})
+
+	for _, want := range []string{
+		"*Stage:* feeds",
+		"no events fetched from any feed",
+		"*Consecutive failures:* 2",
+		"*Attempt:* 2 of 3",
+		"*Next attempt:* retry at Sun Jul 21 09:00 UTC",
+		"*Run ID:* abc123",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("FormatFailure() missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestNotifierAlertsOnFailureAndRecovery(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		received = append(received, payload["text"])
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(server.URL)
+	ctx := context.Background()
+	failure := errors.New("boom")
+
+	notifier.HandleResult(ctx, scheduler.RunResult{Err: nil})
+	notifier.HandleResult(ctx, scheduler.RunResult{Err: failure, ConsecutiveFailures: 1})
+	notifier.HandleResult(ctx, scheduler.RunResult{Err: failure, ConsecutiveFailures: 2})
+	notifier.HandleResult(ctx, scheduler.RunResult{Err: nil})
+	notifier.HandleResult(ctx, scheduler.RunResult{Err: nil})
+
+	if len(received) != 3 {
+		t.Fatalf("received %d alerts, want 3: %q", len(received), received)
+	}
+	if !strings.Contains(received[0], "run failed") || !strings.Contains(received[1], "run failed") {
+		t.Errorf("first two alerts should report failures: %q", received[:2])
+	}
+	if !strings.Contains(received[2], "after 2 failed run(s)") {
+		t.Errorf("third alert should report recovery: %q", received[2])
+	}
+}
@@ -0,0 +1,555 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dpeterka/history-slackbot/internal/health"
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// Decision actions. Edit isn't an action of its own: a submitted edit
+// approves the edited message.
+const (
+	Approve    = "approve"
+	Regenerate = "regenerate"
+	Skip       = "skip"
+)
+
+// Button action IDs
+const (
+	actionApprove    = "approve"
+	actionRegenerate = "regenerate"
+	actionEdit       = "edit"
+	actionSkip       = "skip"
+)
+
+// editCallbackID identifies the edit modal's submissions
+const editCallbackID = "history_edit"
+
+// maxRequestAge is how old a signed Slack request may be before it's
+// rejected as a possible replay
+const maxRequestAge = 5 * time.Minute
+
+// replyTimeout bounds the Slack calls made in reply to an interaction. They
+// run after the interaction is acknowledged, as Slack gives up on requests
+// that take longer than 3 seconds.
+const replyTimeout = 10 * time.Second
+
+// Config configures the approval workflow
+type Config struct {
+	WebhookURL    string        // Incoming webhook of the private review channel
+	SigningSecret string        // Slack app signing secret, to verify button clicks
+	BotToken      string        // Slack bot token for the edit dialog; empty hides the Edit button
+	Timeout       time.Duration // How long to wait for a decision
+	Default       string        // Decision taken at the timeout: Approve or Skip
+}
+
+// Draft is a rendered run waiting for approval
+type Draft struct {
+	RunID         string
+	Pipeline      string
+	Date          time.Time
+	Revision      int
+	Message       slack.SlackMessage
+	Editable      bool // The message can be edited before posting
+	CanRegenerate bool // A regeneration may still be requested
+}
+
+// Decision is a reviewer's answer to a draft, or the default at the timeout
+type Decision struct {
+	Action   string
+	Message  *slack.SlackMessage // Edited message, if any
+	User     string              // Reviewer; empty when the timeout decided
+	TimedOut bool
+}
+
+// draftRecord and decisionRecord are what the ledger stores
+type draftRecord struct {
+	Revision int                `json:"revision"`
+	Date     string             `json:"date"`
+	Deadline time.Time          `json:"deadline"`
+	Message  slack.SlackMessage `json:"message"`
+}
+
+type decisionRecord struct {
+	Revision int    `json:"revision"`
+	Action   string `json:"action"`
+	User     string `json:"user,omitempty"`
+	Edited   bool   `json:"edited,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Text     string `json:"text,omitempty"` // The edited text
+}
+
+// pending is a draft waiting for a decision
+type pending struct {
+	draft       Draft
+	deadline    time.Time
+	decisions   chan Decision
+	responseURL string // Where to update the review controls once decided
+}
+
+// Service posts drafts to the review channel and turns Slack button clicks
+// into decisions. Its Handler must be reachable as the Slack app's
+// interactivity request URL.
+type Service struct {
+	cfg    Config
+	ledger *ledger.Ledger
+	client *http.Client
+	apiURL string
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pending
+	replies sync.WaitGroup // Slack calls still running after an acknowledgement
+}
+
+// NewService creates an approval service recording drafts and decisions in
+// the ledger
+func NewService(cfg Config, l *ledger.Ledger) *Service {
+	return &Service{
+		cfg:    cfg,
+		ledger: l,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		apiURL:  "https://slack.com/api",
+		now:     time.Now,
+		pending: make(map[string]*pending),
+	}
+}
+
+// Request posts the draft to the review channel and waits for a decision.
+// Without one before the timeout, the configured default is returned.
+func (s *Service) Request(ctx context.Context, draft Draft) (Decision, error) {
+	logger := logging.Stage(ctx, "approval")
+
+	id := fmt.Sprintf("%s/%s/%d", draft.RunID, draft.Pipeline, draft.Revision)
+	p := &pending{
+		draft:     draft,
+		deadline:  s.now().Add(s.cfg.Timeout),
+		decisions: make(chan Decision, 1),
+	}
+
+	s.mu.Lock()
+	s.pending[id] = p
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.ledger.Append(draft.RunID, draft.Pipeline, ledger.TypeDraft, draftRecord{
+		Revision: draft.Revision,
+		Date:     draft.Date.Format("2006-01-02"),
+		Deadline: p.deadline,
+		Message:  draft.Message,
+	}); err != nil {
+		return Decision{}, err
+	}
+
+	if err := s.postDraft(ctx, id, p); err != nil {
+		return Decision{}, fmt.Errorf("failed to post draft: %w", err)
+	}
+	logger.Info("posted draft for approval", "revision", draft.Revision, "deadline", p.deadline)
+
+	// Waiting on reviewers doesn't make the run look stuck
+	endWait := health.Waits.Begin()
+	defer endWait()
+
+	timer := time.NewTimer(s.cfg.Timeout)
+	defer timer.Stop()
+
+	var decision Decision
+	select {
+	case decision = <-p.decisions:
+	case <-timer.C:
+		decision = Decision{Action: s.cfg.Default, TimedOut: true}
+		note := fmt.Sprintf("No decision on the draft for *%s* by %s, so it was %s.", draft.Pipeline, p.deadline.Format("15:04 MST"), pastTense(s.cfg.Default))
+		if err := s.postJSON(ctx, s.cfg.WebhookURL, slack.SlackMessage{Text: note}); err != nil {
+			logger.Warn("failed to post timeout note", "error", err)
+		}
+	case <-ctx.Done():
+		return Decision{}, ctx.Err()
+	}
+
+	record := decisionRecord{
+		Revision: draft.Revision,
+		Action:   decision.Action,
+		User:     decision.User,
+		Edited:   decision.Message != nil,
+		TimedOut: decision.TimedOut,
+	}
+	if decision.Message != nil {
+		record.Text = decision.Message.Text
+	}
+	if err := s.ledger.Append(draft.RunID, draft.Pipeline, ledger.TypeDecision, record); err != nil {
+		return Decision{}, err
+	}
+
+	logger.Info("approval decided", "action", decision.Action, "user", decision.User, "timed_out", decision.TimedOut)
+	return decision, nil
+}
+
+// postDraft posts the draft, split to Slack's limits, followed by a message
+// with the review buttons
+func (s *Service) postDraft(ctx context.Context, id string, p *pending) error {
+	for _, part := range slack.Fit(p.draft.Message) {
+		if err := s.postJSON(ctx, s.cfg.WebhookURL, part); err != nil {
+			return err
+		}
+	}
+	return s.postJSON(ctx, s.cfg.WebhookURL, s.controls(id, p))
+}
+
+// controls is the message carrying the review buttons
+func (s *Service) controls(id string, p *pending) map[string]any {
+	intro := fmt.Sprintf("Draft for *%s* (%s", p.draft.Pipeline, p.draft.Date.Format("Monday, January 2"))
+	if p.draft.Revision > 0 {
+		intro += fmt.Sprintf(", revision %d", p.draft.Revision)
+	}
+	intro += fmt.Sprintf("). Without a decision by %s it will be %s.", p.deadline.Format("15:04 MST"), pastTense(s.cfg.Default))
+
+	buttons := []map[string]any{button("Approve", actionApprove, id, "primary")}
+	if p.draft.CanRegenerate {
+		buttons = append(buttons, button("Regenerate", actionRegenerate, id, ""))
+	}
+	if s.editable(p.draft) {
+		buttons = append(buttons, button("Edit", actionEdit, id, ""))
+	}
+	buttons = append(buttons, button("Skip", actionSkip, id, "danger"))
+
+	return map[string]any{
+		"text": intro,
+		"blocks": []map[string]any{
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": intro}},
+			{"type": "actions", "block_id": "approval", "elements": buttons},
+		},
+	}
+}
+
+func button(label, actionID, value, style string) map[string]any {
+	b := map[string]any{
+		"type":      "button",
+		"text":      map[string]string{"type": "plain_text", "text": label},
+		"action_id": actionID,
+		"value":     value,
+	}
+	if style != "" {
+		b["style"] = style
+	}
+	return b
+}
+
+// pastTense describes what happens to a draft for a decision action
+func pastTense(action string) string {
+	switch action {
+	case Approve:
+		return "posted"
+	case Regenerate:
+		return "regenerated"
+	default:
+		return "skipped"
+	}
+}
+
+// interaction is the part of a Slack interaction payload the service uses
+type interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	TriggerID   string `json:"trigger_id"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	View struct {
+		CallbackID      string `json:"callback_id"`
+		PrivateMetadata string `json:"private_metadata"`
+		State           struct {
+			Values map[string]map[string]struct {
+				Value string `json:"value"`
+			} `json:"values"`
+		} `json:"state"`
+	} `json:"view"`
+}
+
+// Handler serves Slack's interactivity requests: button clicks on drafts and
+// edit dialog submissions. Requests must carry a valid Slack signature.
+func (s *Service) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.Stage(r.Context(), "approval")
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read request", http.StatusBadRequest)
+			return
+		}
+		if err := s.verify(r.Header, body); err != nil {
+			logger.Warn("rejected interaction", "error", err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var payload interaction
+		if err := r.ParseForm(); err != nil || json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload) != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		user := payload.User.Username
+		if user == "" {
+			user = payload.User.ID
+		}
+
+		switch payload.Type {
+		case "block_actions":
+			if len(payload.Actions) == 0 {
+				break
+			}
+			s.handleAction(r.Context(), payload.Actions[0].ActionID, payload.Actions[0].Value, user, payload.TriggerID, payload.ResponseURL)
+		case "view_submission":
+			if payload.View.CallbackID != editCallbackID {
+				break
+			}
+			text := payload.View.State.Values["text"]["text"].Value
+			s.decide(r.Context(), payload.View.PrivateMetadata, Decision{
+				Action:  Approve,
+				Message: &slack.SlackMessage{Text: text},
+				User:    user,
+			}, "")
+		}
+
+		// An empty 200 acknowledges clicks and closes the edit dialog
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// reply makes a Slack call in reply to an interaction without holding up its
+// acknowledgement
+func (s *Service) reply(ctx context.Context, call func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replyTimeout)
+	s.replies.Add(1)
+	go func() {
+		defer s.replies.Done()
+		defer cancel()
+		call(ctx)
+	}()
+}
+
+// handleAction turns a button click into a decision, or opens the edit dialog
+func (s *Service) handleAction(ctx context.Context, actionID, id, user, triggerID, responseURL string) {
+	switch actionID {
+	case actionApprove:
+		s.decide(ctx, id, Decision{Action: Approve, User: user}, responseURL)
+	case actionRegenerate:
+		s.decide(ctx, id, Decision{Action: Regenerate, User: user}, responseURL)
+	case actionSkip:
+		s.decide(ctx, id, Decision{Action: Skip, User: user}, responseURL)
+	case actionEdit:
+		s.mu.Lock()
+		p, ok := s.pending[id]
+		if ok {
+			p.responseURL = responseURL
+		}
+		s.mu.Unlock()
+		if !ok {
+			s.respond(ctx, responseURL, "This draft is no longer waiting for a decision.")
+			return
+		}
+		if !s.editable(p.draft) {
+			s.respond(ctx, responseURL, "This draft is too long to edit in Slack.")
+			return
+		}
+		text := editableText(p.draft.Message)
+		s.reply(ctx, func(ctx context.Context) {
+			if err := s.openEditor(ctx, id, triggerID, text); err != nil {
+				logging.Stage(ctx, "approval").Error("failed to open edit dialog", "error", err)
+			}
+		})
+	}
+}
+
+// decide hands a decision to the waiting draft and replaces the review
+// buttons with the outcome
+func (s *Service) decide(ctx context.Context, id string, decision Decision, responseURL string) {
+	s.mu.Lock()
+	p, ok := s.pending[id]
+	if ok && responseURL == "" {
+		responseURL = p.responseURL
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.respond(ctx, responseURL, "This draft is no longer waiting for a decision.")
+		return
+	}
+	if decision.Action == Regenerate && !p.draft.CanRegenerate {
+		s.respond(ctx, responseURL, "This draft can't be regenerated again.")
+		return
+	}
+
+	select {
+	case p.decisions <- decision:
+	default:
+		// Someone else decided first
+		return
+	}
+
+	outcome := map[string]string{
+		Approve:    "approved",
+		Regenerate: "sent back for regeneration",
+		Skip:       "skipped",
+	}[decision.Action]
+	if decision.Message != nil {
+		outcome = "edited and approved"
+	}
+	s.respond(ctx, responseURL, fmt.Sprintf("Draft for *%s* %s by %s.", p.draft.Pipeline, outcome, decision.User))
+}
+
+// respond replaces the message the reviewer interacted with
+func (s *Service) respond(ctx context.Context, responseURL, text string) {
+	if responseURL == "" {
+		return
+	}
+	payload := map[string]any{"replace_original": true, "text": text}
+	s.reply(ctx, func(ctx context.Context) {
+		if err := s.postJSON(ctx, responseURL, payload); err != nil {
+			logging.Stage(ctx, "approval").Warn("failed to update review message", "error", err)
+		}
+	})
+}
+
+// editable reports whether the draft can be edited in the dialog. Its whole
+// text must fit the dialog's text box, so drafts Slack has to split can only
+// be approved, regenerated or skipped.
+func (s *Service) editable(draft Draft) bool {
+	return draft.Editable && s.cfg.BotToken != "" &&
+		utf8.RuneCountInString(editableText(draft.Message)) <= slack.MaxInputText
+}
+
+// editableText is the text a reviewer starts editing from
+func editableText(message slack.SlackMessage) string {
+	if len(message.Blocks) == 0 {
+		return message.Text
+	}
+	return slack.PlainText(message.Blocks)
+}
+
+// openEditor opens a dialog for editing the draft's text
+func (s *Service) openEditor(ctx context.Context, id, triggerID, text string) error {
+	view := map[string]any{
+		"type":             "modal",
+		"callback_id":      editCallbackID,
+		"private_metadata": id,
+		"title":            map[string]string{"type": "plain_text", "text": "Edit draft"},
+		"submit":           map[string]string{"type": "plain_text", "text": "Approve"},
+		"close":            map[string]string{"type": "plain_text", "text": "Cancel"},
+		"blocks": []map[string]any{{
+			"type":     "input",
+			"block_id": "text",
+			"label":    map[string]string{"type": "plain_text", "text": "Message (mrkdwn)"},
+			"element": map[string]any{
+				"type":          "plain_text_input",
+				"action_id":     "text",
+				"multiline":     true,
+				"initial_value": text,
+				"max_length":    slack.MaxInputText,
+			},
+		}},
+	}
+
+	reqBody, err := json.Marshal(map[string]any{"trigger_id": triggerID, "view": view})
+	if err != nil {
+		return fmt.Errorf("failed to marshal view: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.apiURL+"/views.open", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.BotToken)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("views.open failed: %s", result.Error)
+	}
+	return nil
+}
+
+// verify checks Slack's request signature: an HMAC-SHA256 of
+// "v0:timestamp:body" keyed with the signing secret
+func (s *Service) verify(header http.Header, body []byte) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid timestamp")
+	}
+	if age := s.now().Sub(time.Unix(seconds, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("timestamp too far from now")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.cfg.SigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// postJSON posts a payload to a webhook or response URL
+func (s *Service) postJSON(ctx context.Context, url string, payload any) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package approval
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// slackStandIn records webhook posts, response URL updates and views.open calls
+type slackStandIn struct {
+	mu        sync.Mutex
+	posts     []string
+	responses []string
+	views     []string
+	posted    chan struct{}
+}
+
+func newSlackStandIn(t *testing.T) (*slackStandIn, *httptest.Server) {
+	s := &slackStandIn{posted: make(chan struct{}, 10)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/webhook":
+			s.posts = append(s.posts, string(body))
+			if strings.Contains(string(body), `"actions"`) {
+				s.posted <- struct{}{}
+			}
+		case "/response":
+			s.responses = append(s.responses, string(body))
+		case "/api/views.open":
+			s.views = append(s.views, string(body))
+			fmt.Fprint(w, `{"ok": true}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func newTestService(t *testing.T, server *httptest.Server, cfg Config) (*Service, *ledger.Ledger) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.WebhookURL = server.URL + "/webhook"
+	cfg.SigningSecret = "secret"
+	s := NewService(cfg, l)
+	s.apiURL = server.URL + "/api"
+	return s, l
+}
+
+func testDraft() Draft {
+	return Draft{
+		RunID:         "run1",
+		Pipeline:      "eng",
+		Date:          time.Date(2024, time.July, 20, 9, 0, 0, 0, time.UTC),
+		Message:       slack.SlackMessage{Text: "*1969* Apollo 11 lands on the Moon"},
+		Editable:      true,
+		CanRegenerate: true,
+	}
+}
+
+// interact sends a signed interaction payload to the handler and waits for
+// the Slack calls it replies with
+func interact(t *testing.T, s *Service, payload map[string]any, secret string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, signedRequest(payload, secret))
+	s.replies.Wait()
+	return rec.Code
+}
+
+// signedRequest builds an interaction request signed with secret
+func signedRequest(payload map[string]any, secret string) *http.Request {
+	encoded, _ := json.Marshal(payload)
+	body := url.Values{"payload": {string(encoded)}}.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func click(actionID, value, responseURL string) map[string]any {
+	return map[string]any{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U1", "username": "ada"},
+		"trigger_id":   "trigger1",
+		"response_url": responseURL,
+		"actions":      []map[string]string{{"action_id": actionID, "value": value}},
+	}
+}
+
+// request runs Request in the background and waits for the draft to be posted
+func request(t *testing.T, s *Service, standIn *slackStandIn, draft Draft) <-chan Decision {
+	t.Helper()
+	decisions := make(chan Decision, 1)
+	go func() {
+		decision, err := s.Request(context.Background(), draft)
+		if err != nil {
+			t.Errorf("Request() returned error: %v", err)
+		}
+		decisions <- decision
+	}()
+
+	select {
+	case <-standIn.posted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("draft was never posted")
+	}
+	return decisions
+}
+
+func TestApprove(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, l := newTestService(t, server, Config{Timeout: time.Minute, Default: Skip})
+
+	decisions := request(t, s, standIn, testDraft())
+	if code := interact(t, s, click(actionApprove, "run1/eng/0", server.URL+"/response"), "secret"); code != http.StatusOK {
+		t.Fatalf("handler status = %d, want 200", code)
+	}
+
+	decision := <-decisions
+	if decision.Action != Approve || decision.User != "ada" || decision.TimedOut {
+		t.Errorf("decision = %+v, want approved by ada", decision)
+	}
+
+	if len(standIn.posts) != 2 || !strings.Contains(standIn.posts[0], "Apollo 11") {
+		t.Errorf("posts = %v, want the draft then the controls", standIn.posts)
+	}
+	for _, label := range []string{"Approve", "Regenerate", "Skip"} {
+		if !strings.Contains(standIn.posts[1], label) {
+			t.Errorf("controls missing %s button: %s", label, standIn.posts[1])
+		}
+	}
+	if strings.Contains(standIn.posts[1], `"Edit"`) {
+		t.Error("Edit button shown without a bot token")
+	}
+	if len(standIn.responses) != 1 || !strings.Contains(standIn.responses[0], "approved by ada") {
+		t.Errorf("responses = %v, want the controls replaced with the outcome", standIn.responses)
+	}
+
+	records, _ := l.Records("")
+	if len(records) != 2 || records[0].Type != ledger.TypeDraft || records[1].Type != ledger.TypeDecision {
+		t.Fatalf("ledger = %+v, want a draft and a decision", records)
+	}
+	if !strings.Contains(string(records[1].Data), `"action":"approve"`) || !strings.Contains(string(records[1].Data), `"user":"ada"`) {
+		t.Errorf("decision record = %s", records[1].Data)
+	}
+}
+
+func TestTimeoutDefault(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, l := newTestService(t, server, Config{Timeout: 50 * time.Millisecond, Default: Approve})
+
+	decision := <-request(t, s, standIn, testDraft())
+	if decision.Action != Approve || !decision.TimedOut {
+		t.Errorf("decision = %+v, want the default approve at the timeout", decision)
+	}
+	if last := standIn.posts[len(standIn.posts)-1]; !strings.Contains(last, "was posted") {
+		t.Errorf("last post = %s, want a timeout note", last)
+	}
+
+	// A click after the timeout is told the draft has gone
+	interact(t, s, click(actionSkip, "run1/eng/0", server.URL+"/response"), "secret")
+	if len(standIn.responses) != 1 || !strings.Contains(standIn.responses[0], "no longer waiting") {
+		t.Errorf("responses = %v", standIn.responses)
+	}
+
+	decisions, _ := l.Records(ledger.TypeDecision)
+	if len(decisions) != 1 || !strings.Contains(string(decisions[0].Data), `"timed_out":true`) {
+		t.Errorf("decisions = %+v", decisions)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, _ := newTestService(t, server, Config{BotToken: "xoxb-test", Timeout: time.Minute, Default: Skip})
+
+	decisions := request(t, s, standIn, testDraft())
+	if !strings.Contains(standIn.posts[1], `"Edit"`) {
+		t.Fatalf("controls missing Edit button: %s", standIn.posts[1])
+	}
+
+	interact(t, s, click(actionEdit, "run1/eng/0", server.URL+"/response"), "secret")
+	if len(standIn.views) != 1 || !strings.Contains(standIn.views[0], "trigger1") || !strings.Contains(standIn.views[0], "Apollo 11") {
+		t.Fatalf("views.open calls = %v, want the dialog prefilled with the draft", standIn.views)
+	}
+
+	interact(t, s, map[string]any{
+		"type": "view_submission",
+		"user": map[string]string{"id": "U2", "username": "grace"},
+		"view": map[string]any{
+			"callback_id":      editCallbackID,
+			"private_metadata": "run1/eng/0",
+			"state": map[string]any{"values": map[string]any{
+				"text": map[string]any{"text": map[string]string{"value": "Edited text"}},
+			}},
+		},
+	}, "secret")
+
+	decision := <-decisions
+	if decision.Action != Approve || decision.Message == nil || decision.Message.Text != "Edited text" || decision.User != "grace" {
+		t.Errorf("decision = %+v, want the edited text approved by grace", decision)
+	}
+	if len(standIn.responses) != 1 || !strings.Contains(standIn.responses[0], "edited and approved") {
+		t.Errorf("responses = %v", standIn.responses)
+	}
+}
+
+func TestEditLongDraft(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, _ := newTestService(t, server, Config{BotToken: "xoxb-test", Timeout: 100 * time.Millisecond, Default: Skip})
+
+	// Slack splits the draft when posting, but the dialog's text box holds
+	// at most MaxInputText characters, so the Edit button is left out
+	draft := testDraft()
+	draft.Message = slack.SlackMessage{Text: strings.Repeat("*1969* Apollo 11 lands on the Moon\n\n", 100)}
+	decisions := request(t, s, standIn, draft)
+	if strings.Contains(standIn.posts[len(standIn.posts)-1], `"Edit"`) {
+		t.Error("Edit button shown for a draft longer than the dialog allows")
+	}
+
+	// A stale Edit click is refused rather than opening a dialog Slack rejects
+	interact(t, s, click(actionEdit, "run1/eng/0", server.URL+"/response"), "secret")
+	if len(standIn.views) != 0 {
+		t.Errorf("views.open calls = %v, want none", standIn.views)
+	}
+	if len(standIn.responses) != 1 || !strings.Contains(standIn.responses[0], "too long to edit") {
+		t.Errorf("responses = %v", standIn.responses)
+	}
+	if decision := <-decisions; decision.Action != Skip || !decision.TimedOut {
+		t.Errorf("decision = %+v, want the default skip", decision)
+	}
+}
+
+func TestHandlerAcksBeforeReplying(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, _ := newTestService(t, server, Config{Timeout: time.Minute, Default: Skip})
+	decisions := request(t, s, standIn, testDraft())
+
+	// The response URL hangs; the click is still acknowledged straight away
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	acked := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, signedRequest(click(actionApprove, "run1/eng/0", slow.URL), "secret"))
+		acked <- rec.Code
+	}()
+	select {
+	case code := <-acked:
+		if code != http.StatusOK {
+			t.Errorf("handler status = %d, want 200", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler waited on the response URL before acknowledging")
+	}
+	if decision := <-decisions; decision.Action != Approve {
+		t.Errorf("decision = %+v, want approve", decision)
+	}
+}
+
+func TestRegenerateLimit(t *testing.T) {
+	standIn, server := newSlackStandIn(t)
+	s, _ := newTestService(t, server, Config{Timeout: 100 * time.Millisecond, Default: Skip})
+
+	draft := testDraft()
+	draft.CanRegenerate = false
+	decisions := request(t, s, standIn, draft)
+	if strings.Contains(standIn.posts[1], "Regenerate") {
+		t.Error("Regenerate button shown after the limit")
+	}
+
+	// A stale Regenerate click is refused; the draft still times out
+	interact(t, s, click(actionRegenerate, "run1/eng/0", server.URL+"/response"), "secret")
+	if decision := <-decisions; decision.Action != Skip || !decision.TimedOut {
+		t.Errorf("decision = %+v, want the default skip", decision)
+	}
+}
+
+func TestHandlerRejectsBadSignature(t *testing.T) {
+	_, server := newSlackStandIn(t)
+	s, _ := newTestService(t, server, Config{Timeout: time.Minute, Default: Skip})
+
+	if code := interact(t, s, click(actionApprove, "x", ""), "wrong-secret"); code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", code)
+	}
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// dateLayout is how entry dates are written, in file names and entries
+const dateLayout = "2006-01-02"
+
+// Entry is a pre-generated run: the content and rendered message for one
+// pipeline and date
+type Entry struct {
+	Pipeline    string                `json:"pipeline"`
+	Date        string                `json:"date"` // YYYY-MM-DD
+	RunID       string                `json:"run_id"`
+	GeneratedAt time.Time             `json:"generated_at"`
+	Selected    []llm.SelectedEvent   `json:"selected"`
+	Holidays    []llm.SelectedHoliday `json:"holidays,omitempty"`
+	Message     *slack.SlackMessage   `json:"message,omitempty"` // Nil for pipelines without a Slack renderer
+}
+
+// Store keeps entries as JSON files in a directory, one per pipeline and
+// date. Files are written to a temporary name and renamed, so a crash never
+// leaves a half-written entry.
+type Store struct {
+	dir string
+}
+
+// Open opens a store in dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put writes an entry, replacing any earlier one for the same pipeline and date
+func (s *Store) Put(entry Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+
+	path := s.path(entry.Pipeline, entry.Date)
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Get returns the entry for a pipeline and date. The boolean is false when
+// there is none.
+func (s *Store) Get(pipeline string, date time.Time) (Entry, bool, error) {
+	data, err := os.ReadFile(s.path(pipeline, date.Format(dateLayout)))
+	if errors.Is(err, os.ErrNotExist) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, false, fmt.Errorf("invalid cache entry: %w", err)
+	}
+	return entry, true, nil
+}
+
+// Prune removes entries for dates before the given day
+func (s *Store) Prune(before time.Time) error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	cutoff := before.Format(dateLayout)
+	var errs []error
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") || len(name) < len(dateLayout) {
+			continue
+		}
+		// Dates sort as text, so older entries compare lower
+		if date := name[:len(dateLayout)]; date < cutoff {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unsafeName matches characters not allowed in entry file names
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// path is the entry file for a pipeline and date, e.g. 2024-07-20_eng.json
+func (s *Store) path(pipeline, date string) string {
+	return filepath.Join(s.dir, date+"_"+unsafeName.ReplaceAllString(pipeline, "-")+".json")
+}
+
+// DateKey formats a date the way entries record it
+func DateKey(date time.Time) string {
+	return date.Format(dateLayout)
+}
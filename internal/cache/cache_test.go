@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+func TestPutGet(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	date := time.Date(2024, time.July, 20, 6, 0, 0, 0, time.UTC)
+
+	if _, ok, err := store.Get("eng", date); ok || err != nil {
+		t.Fatalf("Get() on an empty store = %v, %v; want a miss", ok, err)
+	}
+
+	entry := Entry{
+		Pipeline:    "eng/#general",
+		Date:        DateKey(date),
+		RunID:       "run1",
+		GeneratedAt: date,
+		Selected:    []llm.SelectedEvent{{Year: "1969", Title: "Apollo 11 lands"}},
+		Message:     &slack.SlackMessage{Text: "Apollo 11 lands"},
+	}
+	if err := store.Put(entry); err != nil {
+		t.Fatalf("Put() returned error: %v", err)
+	}
+
+	got, ok, err := store.Get("eng/#general", date.Add(3*time.Hour))
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %v; want a hit", ok, err)
+	}
+	if got.RunID != "run1" || len(got.Selected) != 1 || got.Message.Text != "Apollo 11 lands" {
+		t.Errorf("Get() = %+v", got)
+	}
+
+	// Pipeline names are made safe for file names
+	files, _ := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if len(files) != 1 || filepath.Base(files[0]) != "2024-07-20_eng-general.json" {
+		t.Errorf("files = %v", files)
+	}
+}
+
+func TestGetInvalid(t *testing.T) {
+	store, _ := Open(t.TempDir())
+	date := time.Date(2024, time.July, 20, 0, 0, 0, 0, time.UTC)
+	os.WriteFile(store.path("eng", DateKey(date)), []byte("{not json"), 0o644)
+
+	if _, _, err := store.Get("eng", date); err == nil {
+		t.Error("Get() should fail on a corrupt entry")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store, _ := Open(t.TempDir())
+	for _, day := range []int{10, 18, 20} {
+		store.Put(Entry{Pipeline: "eng", Date: DateKey(time.Date(2024, time.July, day, 0, 0, 0, 0, time.UTC))})
+	}
+
+	if err := store.Prune(time.Date(2024, time.July, 13, 6, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Prune() returned error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if len(files) != 2 {
+		t.Errorf("files after Prune() = %v, want the 18th and 20th", files)
+	}
+}
@@ -4,7 +4,17 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/approval"
+	"github.com/dpeterka/history-slackbot/internal/dedup"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/moderation"
+	"github.com/dpeterka/history-slackbot/internal/scheduler"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+	"github.com/dpeterka/history-slackbot/internal/usage"
+	"github.com/dpeterka/history-slackbot/internal/wikipedia"
 )
 
 // Config holds the application configuration
@@ -12,9 +22,19 @@ type Config struct {
 	// Slack configuration
 	SlackWebhookURL string
 
+	// Destinations to post to; without DESTINATIONS_FILE this is a single
+	// Slack destination using SlackWebhookURL
+	Destinations []Destination
+
 	// Anthropic Claude API configuration
-	ClaudeAPIKey string
-	ClaudeModel  string
+	ClaudeAPIKey        string
+	ClaudeModel         string
+	ClaudePromptCaching bool             // Cache the system prompt across calls
+	ClaudePrices        usage.PriceTable // Per-model prices for cost estimates
+	ClaudeRunBudget     float64          // US dollars per run; 0 is unlimited
+	ClaudeMonthlyBudget float64          // US dollars per calendar month; 0 is unlimited
+	ClaudeMonthlyLimit  float64          // US dollars per calendar month for every model; 0 is the monthly budget
+	ClaudeBudgetModel   string           // Model used once a budget is spent; empty uses the fallback selector
 
 	// RSS feed URLs
 	RSSFeedURLs []string
@@ -22,26 +42,106 @@ type Config struct {
 	// Holiday feed URL
 	HolidayFeedURL string
 
+	// Wikipedia "onthisday" source
+	WikipediaEnabled  bool
+	WikipediaLanguage string   // Language edition, e.g. "en"
+	WikipediaTypes    []string // Entry types to fetch: selected, events, births, deaths, holidays
+
+	// Local curated dataset (company anniversaries etc.); empty disables it
+	DatasetDir string
+
 	// Scheduler configuration
 	ScheduleCron string // Cron expression for scheduling
 	RunOnce      bool   // Run once and exit (for testing)
 
+	// Retry policy for failed runs
+	RetryMaxAttempts int           // Attempts per run, including the first
+	RetryBackoff     time.Duration // Delay between attempts
+	RetryCutoff      time.Duration // Latest time of day (since midnight) to retry; zero means no cutoff
+
+	// Logging configuration
+	LogFormat string // "text" or "json"
+	LogLevel  string // "debug", "info", "warn" or "error"
+
+	// HTTP server configuration
+	HTTPAddr string // Listen address for /metrics, /healthz and /readyz (empty disables the server)
+
+	// Alerting configuration
+	AlertWebhookURL string // Ops webhook for failure/recovery reports (empty disables alerting)
+
+	// Health check configuration
+	HealthMaxFailures int           // Consecutive failed runs before the bot reports unhealthy
+	HealthStuckAfter  time.Duration // How long a run may take before it's considered stuck
+
+	// Message rendering
+	RenderStyle         string // Block Kit layout: "verbose" or "compact"
+	RenderEmoji         bool   // Emoji in the header and section titles
+	RenderHeader        string // Header text; the date is appended
+	RenderFooter        string // Footer text; empty leaves it out
+	MessageTemplateFile string // text/template file; replaces the Block Kit layout when set
+
+	// Content moderation of selected events; empty allows everything
+	ModerationPolicy moderation.Policy
+
+	// Pre-generation: compose posts ahead of time and post from the cache
+	PregenerateCron string        // When to pre-generate, e.g. "0 6 * * *"; empty disables it
+	CacheDir        string        // Directory of pre-generated runs
+	CacheMaxAge     time.Duration // Oldest pre-generated run that may be posted
+
+	// Run ledger (JSON Lines); empty disables it
+	LedgerFile string
+
+	// Approval workflow: drafts go to a review channel before posting
+	ApprovalEnabled          bool          // Require approval for every destination unless it says otherwise
+	ApprovalWebhookURL       string        // Incoming webhook of the private review channel
+	ApprovalTimeout          time.Duration // How long to wait for a decision
+	ApprovalDefault          string        // Decision at the timeout: "approve" or "skip"
+	ApprovalMaxRegenerations int           // Regenerations allowed per run
+	SlackSigningSecret       string        // Verifies button clicks sent to /slack/interactions
+	SlackBotToken            string        // Opens the edit dialog; empty hides the Edit button
+
 	// LLM prompt configuration
-	MaxEvents         int // Maximum number of events to select
-	MaxHolidays       int // Maximum number of holidays to display
-	EventSelectionPrompt string
+	MaxEvents                  int      // Maximum number of events to select
+	MaxHolidays                int      // Maximum number of holidays to display
+	MaxBirths                  int      // Maximum number of births to select
+	MaxDeaths                  int      // Maximum number of deaths to select
+	DedupTitleSimilarity       float64  // Share of title words that makes two candidates the same event
+	DedupTextSimilarity        float64  // Share of title and description words that does the same
+	PrefilterBlockedCategories []string // Candidate categories never sent to the LLM
+	PrefilterMaxDescription    int      // Runes kept of each candidate description; 0 keeps them whole
+	PrefilterTokenBudget       int      // Estimated tokens of candidates per kind sent to the LLM; 0 is unlimited
+	EventSelectionPrompt       string   // Inline event prompt template; empty uses the default
+	EventSelectionPromptFile   string   // Event prompt template file, instead of the inline prompt
+	HolidaySelectionPrompt     string   // Inline holiday prompt template; empty uses the default
+	HolidaySelectionPromptFile string   // Holiday prompt template file, instead of the inline prompt
+	PromptAudience             string   // {{.Audience}} in prompts
+	PromptTone                 string   // {{.Tone}} in prompts
+	PromptExcludedYears        []string // {{.ExcludedYears}} in prompts
+	PromptExcludedCategories   []string // {{.ExcludedCategories}} in prompts
+	PromptExamplesFile         string   // Few-shot examples for {{.Examples}}, as YAML or JSON
 }
 
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	cfg := &Config{
-		SlackWebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
-		ClaudeAPIKey:    os.Getenv("CLAUDE_API_KEY"),
-		ClaudeModel:     getEnvOrDefault("CLAUDE_MODEL", "claude-sonnet-4-5"),
-		ScheduleCron:    getEnvOrDefault("SCHEDULE_CRON", "0 9 * * *"), // Default: 9 AM daily
-		RunOnce:         getEnvBool("RUN_ONCE", false),
-		MaxEvents:       getEnvInt("MAX_EVENTS", 1),
-		MaxHolidays:     getEnvInt("MAX_HOLIDAYS", 2),
+		SlackWebhookURL:     os.Getenv("SLACK_WEBHOOK_URL"),
+		ClaudeAPIKey:        os.Getenv("CLAUDE_API_KEY"),
+		ClaudeModel:         getEnvOrDefault("CLAUDE_MODEL", "claude-sonnet-4-5"),
+		ClaudePromptCaching: getEnvBool("CLAUDE_PROMPT_CACHING", true),
+		ScheduleCron:        getEnvOrDefault("SCHEDULE_CRON", "0 9 * * *"), // Default: 9 AM daily
+		RunOnce:             getEnvBool("RUN_ONCE", false),
+		RetryMaxAttempts:    getEnvInt("RETRY_MAX_ATTEMPTS", 1),
+		RetryBackoff:        getEnvDuration("RETRY_BACKOFF", 10*time.Minute),
+		MaxEvents:           getEnvInt("MAX_EVENTS", 1),
+		MaxHolidays:         getEnvInt("MAX_HOLIDAYS", 2),
+		MaxBirths:           getEnvInt("MAX_BIRTHS", 1),
+		MaxDeaths:           getEnvInt("MAX_DEATHS", 0),
+		LogFormat:           getEnvOrDefault("LOG_FORMAT", "text"),
+		LogLevel:            getEnvOrDefault("LOG_LEVEL", "info"),
+		HTTPAddr:            os.Getenv("HTTP_ADDR"),
+		AlertWebhookURL:     os.Getenv("ALERT_WEBHOOK_URL"),
+		HealthMaxFailures:   getEnvInt("HEALTH_MAX_FAILURES", 3),
+		HealthStuckAfter:    getEnvDuration("HEALTH_STUCK_AFTER", 30*time.Minute),
 	}
 
 	// RSS feed URLs - support multiple feeds
@@ -51,37 +151,174 @@ func Load() (*Config, error) {
 	// Holiday feed URL
 	cfg.HolidayFeedURL = getEnvOrDefault("HOLIDAY_FEED_URL", "https://api.checkiday.com/rss?tz=America/New_York")
 
-	// Default event selection prompt
-	cfg.EventSelectionPrompt = getEnvOrDefault("EVENT_SELECTION_PROMPT",
-		`You are analyzing historical events that happened on this day. Your task is to select the most interesting, rare, or significant events from the list provided.
-
-Criteria for selection:
-- Events that are historically significant or impactful
-- Unusual, rare, or surprising events
-- Events that would be interesting to a general audience
-- Avoid overly common or mundane events
-- Prefer events from different time periods and categories for variety
-
-Select exactly %d events from the list and format them for posting to Slack. For each event, provide:
-1. The year and a brief, engaging description (2-3 sentences max)
-2. Why this event is interesting or significant
-
-Format your response as JSON with the following structure:
-{
-  "events": [
-    {
-      "year": "YYYY",
-      "title": "Brief event title",
-      "description": "Engaging 2-3 sentence description with context and significance",
-      "category": "Category of event (e.g., Politics, Science, Arts, etc.)"
-    }
-  ]
-}`)
+	// Wikipedia "onthisday" source
+	cfg.WikipediaEnabled = getEnvBool("WIKIPEDIA_ENABLED", false)
+	cfg.WikipediaLanguage = getEnvOrDefault("WIKIPEDIA_LANG", "en")
+	cfg.WikipediaTypes = splitList(getEnvOrDefault("WIKIPEDIA_TYPES", "selected,events"))
 
-	// Validate required configuration
-	if cfg.SlackWebhookURL == "" {
-		return nil, fmt.Errorf("SLACK_WEBHOOK_URL is required")
+	// Local curated dataset
+	cfg.DatasetDir = os.Getenv("DATASET_DIR")
+
+	// Message rendering; an explicitly empty RENDER_FOOTER removes the footer
+	cfg.RenderStyle = getEnvOrDefault("RENDER_STYLE", slack.StyleVerbose)
+	cfg.RenderEmoji = getEnvBool("RENDER_EMOJI", true)
+	cfg.RenderHeader = getEnvOrDefault("RENDER_HEADER", slack.DefaultRenderOptions().Header)
+	cfg.RenderFooter = slack.DefaultFooter
+	if footer, ok := os.LookupEnv("RENDER_FOOTER"); ok {
+		cfg.RenderFooter = footer
+	}
+	cfg.MessageTemplateFile = os.Getenv("MESSAGE_TEMPLATE_FILE")
+
+	// Moderation policy, e.g. "violence=swap,tragedy=soften,politics=separate"
+	policy, err := moderation.ParsePolicy(os.Getenv("MODERATION_POLICY"))
+	if err != nil {
+		return nil, fmt.Errorf("MODERATION_POLICY: %w", err)
+	}
+	cfg.ModerationPolicy = policy
+
+	// Pre-generation
+	cfg.PregenerateCron = os.Getenv("PREGENERATE_CRON")
+	cfg.CacheDir = os.Getenv("CACHE_DIR")
+	cfg.CacheMaxAge = getEnvDuration("CACHE_MAX_AGE", 12*time.Hour)
+	if cfg.PregenerateCron != "" {
+		if _, _, err := scheduler.ParseCron(cfg.PregenerateCron); err != nil {
+			return nil, fmt.Errorf("PREGENERATE_CRON: %w", err)
+		}
+		if cfg.CacheDir == "" {
+			return nil, fmt.Errorf("CACHE_DIR is required for pre-generation")
+		}
+	}
+
+	// Run ledger and approval workflow
+	cfg.LedgerFile = os.Getenv("LEDGER_FILE")
+	cfg.ApprovalEnabled = getEnvBool("APPROVAL_ENABLED", false)
+	cfg.ApprovalWebhookURL = os.Getenv("APPROVAL_WEBHOOK_URL")
+	cfg.ApprovalTimeout = getEnvDuration("APPROVAL_TIMEOUT", 2*time.Hour)
+	cfg.ApprovalDefault = getEnvOrDefault("APPROVAL_DEFAULT", approval.Skip)
+	cfg.ApprovalMaxRegenerations = getEnvInt("APPROVAL_MAX_REGENERATIONS", 3)
+	cfg.SlackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	cfg.SlackBotToken = os.Getenv("SLACK_BOT_TOKEN")
+
+	// Merging of duplicate candidates and trimming of those sent to the LLM
+	if cfg.DedupTitleSimilarity, err = getEnvRatio("DEDUP_TITLE_SIMILARITY", dedup.DefaultOptions.TitleSimilarity); err != nil {
+		return nil, err
+	}
+	if cfg.DedupTextSimilarity, err = getEnvRatio("DEDUP_TEXT_SIMILARITY", dedup.DefaultOptions.TextSimilarity); err != nil {
+		return nil, err
+	}
+	cfg.PrefilterBlockedCategories = splitList(os.Getenv("PREFILTER_BLOCKED_CATEGORIES"))
+	cfg.PrefilterMaxDescription = getEnvInt("PREFILTER_MAX_DESCRIPTION", 300)
+	cfg.PrefilterTokenBudget = getEnvInt("PREFILTER_TOKEN_BUDGET", 4000)
+	if cfg.PrefilterMaxDescription < 0 || cfg.PrefilterTokenBudget < 0 {
+		return nil, fmt.Errorf("PREFILTER_MAX_DESCRIPTION and PREFILTER_TOKEN_BUDGET must not be negative")
+	}
+
+	// LLM prompt templates and their variables (empty prompts use the
+	// selector's built-in defaults)
+	cfg.EventSelectionPrompt = os.Getenv("EVENT_SELECTION_PROMPT")
+	cfg.EventSelectionPromptFile = os.Getenv("EVENT_SELECTION_PROMPT_FILE")
+	cfg.HolidaySelectionPrompt = os.Getenv("HOLIDAY_SELECTION_PROMPT")
+	cfg.HolidaySelectionPromptFile = os.Getenv("HOLIDAY_SELECTION_PROMPT_FILE")
+	cfg.PromptAudience = os.Getenv("PROMPT_AUDIENCE")
+	cfg.PromptTone = os.Getenv("PROMPT_TONE")
+	cfg.PromptExcludedYears = splitList(os.Getenv("PROMPT_EXCLUDED_YEARS"))
+	cfg.PromptExcludedCategories = splitList(os.Getenv("PROMPT_EXCLUDED_CATEGORIES"))
+	cfg.PromptExamplesFile = os.Getenv("PROMPT_EXAMPLES_FILE")
+
+	// Claude prices and budgets
+	prices, err := usage.ParsePrices(os.Getenv("CLAUDE_PRICES"))
+	if err != nil {
+		return nil, fmt.Errorf("CLAUDE_PRICES: %w", err)
+	}
+	cfg.ClaudePrices = usage.DefaultPrices.Merge(prices)
+	if cfg.ClaudeRunBudget, err = getEnvDollars("CLAUDE_RUN_BUDGET"); err != nil {
+		return nil, err
+	}
+	if cfg.ClaudeMonthlyBudget, err = getEnvDollars("CLAUDE_MONTHLY_BUDGET"); err != nil {
+		return nil, err
+	}
+	if cfg.ClaudeMonthlyLimit, err = getEnvDollars("CLAUDE_MONTHLY_LIMIT"); err != nil {
+		return nil, err
+	}
+	if cfg.ClaudeMonthlyLimit == 0 {
+		cfg.ClaudeMonthlyLimit = cfg.ClaudeMonthlyBudget
+	}
+	cfg.ClaudeBudgetModel = os.Getenv("CLAUDE_BUDGET_MODEL")
+	if err := cfg.validateBudget(); err != nil {
+		return nil, err
+	}
+
+	// Latest time of day a failed run may be retried
+	if cutoff := os.Getenv("RETRY_CUTOFF"); cutoff != "" {
+		d, err := scheduler.ParseTimeOfDay(cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("RETRY_CUTOFF: %w", err)
+		}
+		cfg.RetryCutoff = d
+	}
+
+	// Wikipedia holidays are added to HOLIDAY_FEED_URL's, not the events
+	for _, t := range cfg.WikipediaTypes {
+		switch t {
+		case wikipedia.TypeSelected, wikipedia.TypeEvents, wikipedia.TypeBirths, wikipedia.TypeDeaths, wikipedia.TypeHolidays:
+		default:
+			return nil, fmt.Errorf("WIKIPEDIA_TYPES: unknown entry type %q", t)
+		}
+	}
+
+	// Destinations: a file listing several, or the single Slack webhook
+	if path := os.Getenv("DESTINATIONS_FILE"); path != "" {
+		destinations, err := loadDestinations(path)
+		if err != nil {
+			return nil, fmt.Errorf("DESTINATIONS_FILE: %w", err)
+		}
+		cfg.Destinations = destinations
+	} else {
+		if cfg.SlackWebhookURL == "" {
+			return nil, fmt.Errorf("SLACK_WEBHOOK_URL is required")
+		}
+		cfg.Destinations = []Destination{{Name: "slack", Type: DestinationSlack, WebhookURL: cfg.SlackWebhookURL}}
+	}
+
+	// Validate destinations and their rendering, so template mistakes stop
+	// the bot at startup
+	names := make(map[string]bool)
+	for _, d := range cfg.Destinations {
+		if err := d.validate(); err != nil {
+			return nil, fmt.Errorf("DESTINATIONS_FILE: %w", err)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("DESTINATIONS_FILE: duplicate destination name %q", d.Name)
+		}
+		names[d.Name] = true
+
+		if d.Type == DestinationSlack {
+			if _, err := cfg.RendererFor(d); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := cfg.ModerationPolicyFor(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validateApproval(); err != nil {
+		return nil, err
+	}
+
+	// Parse the prompts and examples so template mistakes stop the bot at
+	// startup
+	if _, err := cfg.EventPrompt(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.HolidayPrompt(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.PromptData(); err != nil {
+		return nil, err
 	}
+
+	// Validate required configuration
 	if cfg.ClaudeAPIKey == "" {
 		return nil, fmt.Errorf("CLAUDE_API_KEY is required")
 	}
@@ -89,6 +326,146 @@ Format your response as JSON with the following structure:
 	return cfg, nil
 }
 
+// validateBudget checks that costs can be estimated for every model a budget
+// applies to
+func (c *Config) validateBudget() error {
+	if c.ClaudeMonthlyBudget > 0 && c.ClaudeMonthlyLimit < c.ClaudeMonthlyBudget {
+		return fmt.Errorf("CLAUDE_MONTHLY_LIMIT must not be less than CLAUDE_MONTHLY_BUDGET")
+	}
+	if c.ClaudeRunBudget == 0 && c.ClaudeMonthlyBudget == 0 && c.ClaudeMonthlyLimit == 0 {
+		if c.ClaudeBudgetModel != "" {
+			return fmt.Errorf("CLAUDE_BUDGET_MODEL needs CLAUDE_RUN_BUDGET or CLAUDE_MONTHLY_BUDGET")
+		}
+		return nil
+	}
+	for _, model := range []string{c.ClaudeModel, c.ClaudeBudgetModel} {
+		if _, ok := c.ClaudePrices.Lookup(model); model != "" && !ok {
+			return fmt.Errorf("CLAUDE_PRICES: no price for %s, so the budget can't be enforced", model)
+		}
+	}
+	return nil
+}
+
+// validateApproval checks the approval settings when any destination needs
+// approval
+func (c *Config) validateApproval() error {
+	needed := false
+	for _, d := range c.Destinations {
+		needed = needed || c.ApprovalFor(d)
+	}
+	if !needed {
+		return nil
+	}
+
+	switch {
+	case c.ApprovalWebhookURL == "":
+		return fmt.Errorf("APPROVAL_WEBHOOK_URL is required for approval")
+	case c.SlackSigningSecret == "":
+		return fmt.Errorf("SLACK_SIGNING_SECRET is required for approval")
+	case c.HTTPAddr == "":
+		return fmt.Errorf("HTTP_ADDR is required for approval, to receive button clicks")
+	case c.LedgerFile == "":
+		return fmt.Errorf("LEDGER_FILE is required for approval, to record drafts and decisions")
+	case c.ApprovalTimeout <= 0:
+		return fmt.Errorf("APPROVAL_TIMEOUT must be positive")
+	case c.ApprovalDefault != approval.Approve && c.ApprovalDefault != approval.Skip:
+		return fmt.Errorf("APPROVAL_DEFAULT: unknown decision %q (want %s or %s)", c.ApprovalDefault, approval.Approve, approval.Skip)
+	}
+	return nil
+}
+
+// EventPrompt parses the event selection prompt from EVENT_SELECTION_PROMPT_FILE
+// or EVENT_SELECTION_PROMPT. It returns nil if neither is set, which selects
+// the default prompt.
+func (c *Config) EventPrompt() (*llm.Prompt, error) {
+	return loadPrompt(llm.EventPrompt, "EVENT_SELECTION_PROMPT", c.EventSelectionPrompt, c.EventSelectionPromptFile)
+}
+
+// HolidayPrompt parses the holiday selection prompt; see EventPrompt
+func (c *Config) HolidayPrompt() (*llm.Prompt, error) {
+	return loadPrompt(llm.HolidayPrompt, "HOLIDAY_SELECTION_PROMPT", c.HolidaySelectionPrompt, c.HolidaySelectionPromptFile)
+}
+
+// loadPrompt parses an inline prompt or a prompt file named by the
+// variable's _FILE counterpart. Setting both is an error.
+func loadPrompt(kind, variable, text, path string) (*llm.Prompt, error) {
+	switch {
+	case text != "" && path != "":
+		return nil, fmt.Errorf("%s and %s_FILE are mutually exclusive", variable, variable)
+	case path != "":
+		prompt, err := llm.LoadPrompt(kind, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE: %w", variable, err)
+		}
+		return prompt, nil
+	case text != "":
+		prompt, err := llm.ParsePrompt(kind, variable, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", variable, err)
+		}
+		return prompt, nil
+	}
+	return nil, nil
+}
+
+// PromptData returns the prompt variables that come from configuration,
+// loading the few-shot examples from PROMPT_EXAMPLES_FILE
+func (c *Config) PromptData() (llm.PromptData, error) {
+	data := llm.PromptData{
+		Audience:           c.PromptAudience,
+		Tone:               c.PromptTone,
+		ExcludedYears:      c.PromptExcludedYears,
+		ExcludedCategories: c.PromptExcludedCategories,
+	}
+	if c.PromptExamplesFile != "" {
+		examples, err := llm.LoadExamples(c.PromptExamplesFile)
+		if err != nil {
+			return llm.PromptData{}, fmt.Errorf("PROMPT_EXAMPLES_FILE: %w", err)
+		}
+		data.Examples = examples
+	}
+	return data, nil
+}
+
+// RenderOptions returns the Block Kit renderer options
+func (c *Config) RenderOptions() slack.RenderOptions {
+	return slack.RenderOptions{
+		Style:  c.RenderStyle,
+		Emoji:  c.RenderEmoji,
+		Header: c.RenderHeader,
+		Footer: c.RenderFooter,
+	}
+}
+
+// Renderer builds the configured message renderer: the template renderer if
+// MESSAGE_TEMPLATE_FILE is set, otherwise the Block Kit renderer
+func (c *Config) Renderer() (slack.Renderer, error) {
+	if c.MessageTemplateFile != "" {
+		renderer, err := slack.LoadTemplate(c.MessageTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("MESSAGE_TEMPLATE_FILE: %w", err)
+		}
+		return renderer, nil
+	}
+
+	renderer, err := slack.NewBlockKitRenderer(c.RenderOptions())
+	if err != nil {
+		return nil, fmt.Errorf("RENDER_STYLE: %w", err)
+	}
+	return renderer, nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -116,6 +493,43 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvDollars parses an amount in US dollars, which must not be negative;
+// unset is 0
+func getEnvDollars(key string) (float64, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(os.Getenv(key)), "$")
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("%s: invalid amount %q", key, os.Getenv(key))
+	}
+	return amount, nil
+}
+
+// getEnvRatio parses a number between 0 (exclusive) and 1
+func getEnvRatio(key string, defaultValue float64) (float64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio <= 0 || ratio > 1 {
+		return 0, fmt.Errorf("%s: invalid value %q (want a number above 0, up to 1)", key, value)
+	}
+	return ratio, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
 // GetSchedule returns the next scheduled run time
 func (c *Config) GetSchedule() (time.Duration, error) {
 	// For now, we'll implement a simple daily schedule
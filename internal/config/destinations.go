@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dpeterka/history-slackbot/internal/moderation"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// Destination types
+const (
+	DestinationSlack      = "slack"
+	DestinationTeams      = "teams"
+	DestinationDiscord    = "discord"
+	DestinationMattermost = "mattermost"
+	DestinationEmail      = "email"
+)
+
+// Destination is somewhere the bot posts to. Each destination runs its own
+// pipeline, so it gets its own selection and look.
+type Destination struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	WebhookURL string `json:"webhook_url,omitempty"` // Slack, Teams, Discord and Mattermost
+
+	Email *EmailDestination `json:"email,omitempty"`
+
+	// Render overrides the global RENDER_* settings (Slack only)
+	Render *RenderOverrides `json:"render,omitempty"`
+
+	// Moderation overrides MODERATION_POLICY per category, e.g.
+	// {"politics": "allow"}
+	Moderation map[string]string `json:"moderation,omitempty"`
+
+	// Approval overrides APPROVAL_ENABLED for this destination
+	Approval *bool `json:"approval,omitempty"`
+}
+
+// EmailDestination holds SMTP settings for an email destination
+type EmailDestination struct {
+	SMTPAddr string   `json:"smtp_addr"` // host:port
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// RenderOverrides replaces individual render settings for one destination
+type RenderOverrides struct {
+	Style        string  `json:"style,omitempty"`
+	Emoji        *bool   `json:"emoji,omitempty"`
+	Header       string  `json:"header,omitempty"`
+	Footer       *string `json:"footer,omitempty"`
+	TemplateFile string  `json:"template_file,omitempty"`
+}
+
+// loadDestinations reads a JSON list of destinations. ${VAR} references are
+// expanded from the environment first, so secrets can stay out of the file.
+func loadDestinations(path string) ([]Destination, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destinations file: %w", err)
+	}
+
+	var destinations []Destination
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &destinations); err != nil {
+		return nil, fmt.Errorf("invalid destinations file: %w", err)
+	}
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("destinations file lists no destinations")
+	}
+
+	return destinations, nil
+}
+
+// validate checks a destination's required settings
+func (d Destination) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("destination name is required")
+	}
+
+	switch d.Type {
+	case DestinationSlack, DestinationTeams, DestinationDiscord, DestinationMattermost:
+		if d.WebhookURL == "" {
+			return fmt.Errorf("destination %q: webhook_url is required", d.Name)
+		}
+	case DestinationEmail:
+		if d.Email == nil || d.Email.SMTPAddr == "" || d.Email.From == "" || len(d.Email.To) == 0 {
+			return fmt.Errorf("destination %q: email.smtp_addr, email.from and email.to are required", d.Name)
+		}
+	default:
+		return fmt.Errorf("destination %q: unknown type %q", d.Name, d.Type)
+	}
+
+	if d.Render != nil && d.Type != DestinationSlack {
+		return fmt.Errorf("destination %q: render settings only apply to slack destinations", d.Name)
+	}
+
+	return nil
+}
+
+// RendererFor builds the Slack renderer for a destination: the global
+// settings with the destination's overrides applied
+func (c *Config) RendererFor(d Destination) (slack.Renderer, error) {
+	if d.Render == nil {
+		return c.Renderer()
+	}
+
+	merged := *c
+	if d.Render.Style != "" {
+		merged.RenderStyle = d.Render.Style
+	}
+	if d.Render.Emoji != nil {
+		merged.RenderEmoji = *d.Render.Emoji
+	}
+	if d.Render.Header != "" {
+		merged.RenderHeader = d.Render.Header
+	}
+	if d.Render.Footer != nil {
+		merged.RenderFooter = *d.Render.Footer
+	}
+	if d.Render.TemplateFile != "" {
+		merged.MessageTemplateFile = d.Render.TemplateFile
+	}
+
+	renderer, err := merged.Renderer()
+	if err != nil {
+		return nil, fmt.Errorf("destination %q: %w", d.Name, err)
+	}
+	return renderer, nil
+}
+
+// ModerationPolicyFor returns the moderation policy for a destination: the
+// global MODERATION_POLICY with the destination's overrides applied
+func (c *Config) ModerationPolicyFor(d Destination) (moderation.Policy, error) {
+	overrides := make(moderation.Policy)
+	for category, action := range d.Moderation {
+		if err := overrides.Set(category, action); err != nil {
+			return nil, fmt.Errorf("destination %q: %w", d.Name, err)
+		}
+	}
+	return c.ModerationPolicy.Merge(overrides), nil
+}
+
+// ApprovalFor reports whether a destination's posts need approval
+func (c *Config) ApprovalFor(d Destination) bool {
+	if d.Approval != nil {
+		return *d.Approval
+	}
+	return c.ApprovalEnabled
+}
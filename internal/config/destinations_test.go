@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDestinations(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "destinations.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaultDestination(t *testing.T) {
+	t.Setenv("SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/T/B/X")
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(cfg.Destinations) != 1 || cfg.Destinations[0].Type != DestinationSlack || cfg.Destinations[0].WebhookURL != cfg.SlackWebhookURL {
+		t.Errorf("Destinations = %+v, want the single Slack webhook", cfg.Destinations)
+	}
+}
+
+func TestLoadDestinationsFile(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("SMTP_PASSWORD", "s3cret")
+	t.Setenv("DESTINATIONS_FILE", writeDestinations(t, `[
+		{"name": "eng", "type": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/X", "render": {"style": "compact", "emoji": false}},
+		{"name": "sales", "type": "teams", "webhook_url": "https://example.webhook.office.com/x"},
+		{"name": "digest", "type": "email", "email": {"smtp_addr": "smtp.example.com:587", "username": "bot", "password": "${SMTP_PASSWORD}", "from": "bot@example.com", "to": ["all@example.com"]}}
+	]`))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(cfg.Destinations) != 3 {
+		t.Fatalf("len(Destinations) = %d, want 3", len(cfg.Destinations))
+	}
+	if got := cfg.Destinations[2].Email.Password; got != "s3cret" {
+		t.Errorf("email password = %q, want it expanded from the environment", got)
+	}
+}
+
+func TestLoadDestinationsFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"empty", `[]`, "no destinations"},
+		{"unknown type", `[{"name": "x", "type": "pager", "webhook_url": "https://example.com"}]`, `unknown type "pager"`},
+		{"missing webhook", `[{"name": "x", "type": "discord"}]`, "webhook_url is required"},
+		{"incomplete email", `[{"name": "x", "type": "email", "email": {"smtp_addr": "smtp.example.com:25"}}]`, "email.to are required"},
+		{"duplicate names", `[{"name": "x", "type": "mattermost", "webhook_url": "https://a"}, {"name": "x", "type": "teams", "webhook_url": "https://b"}]`, "duplicate destination name"},
+		{"render on non-slack", `[{"name": "x", "type": "teams", "webhook_url": "https://a", "render": {"style": "compact"}}]`, "only apply to slack"},
+		{"bad render style", `[{"name": "x", "type": "slack", "webhook_url": "https://a", "render": {"style": "fancy"}}]`, "unknown render style"},
+		{"bad moderation category", `[{"name": "x", "type": "teams", "webhook_url": "https://a", "moderation": {"gore": "swap"}}]`, "unknown moderation category"},
+		{"bad moderation action", `[{"name": "x", "type": "teams", "webhook_url": "https://a", "moderation": {"violence": "hide"}}]`, "unknown moderation action"},
+		{"missing template", `[{"name": "x", "type": "slack", "webhook_url": "https://a", "render": {"template_file": "/nonexistent.tmpl"}}]`, "MESSAGE_TEMPLATE_FILE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDE_API_KEY", "test-key")
+			t.Setenv("DESTINATIONS_FILE", writeDestinations(t, tt.content))
+
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModerationPolicyFor(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("MODERATION_POLICY", "violence=swap,politics=separate")
+	t.Setenv("DESTINATIONS_FILE", writeDestinations(t, `[
+		{"name": "eng", "type": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/X"},
+		{"name": "news", "type": "mattermost", "webhook_url": "https://a", "moderation": {"politics": "allow", "tragedy": "soften"}}
+	]`))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	for i, want := range []string{
+		"violence=swap,politics=separate",
+		"violence=swap,tragedy=soften,politics=allow",
+	} {
+		policy, err := cfg.ModerationPolicyFor(cfg.Destinations[i])
+		if err != nil {
+			t.Fatalf("ModerationPolicyFor() returned error: %v", err)
+		}
+		if policy.String() != want {
+			t.Errorf("%s policy = %q, want %q", cfg.Destinations[i].Name, policy.String(), want)
+		}
+	}
+}
+
+func TestApprovalValidation(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("DESTINATIONS_FILE", writeDestinations(t, `[
+		{"name": "eng", "type": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/X", "approval": true},
+		{"name": "news", "type": "mattermost", "webhook_url": "https://a"}
+	]`))
+
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "APPROVAL_WEBHOOK_URL") {
+		t.Fatalf("Load() error = %v, want APPROVAL_WEBHOOK_URL required", err)
+	}
+
+	t.Setenv("APPROVAL_WEBHOOK_URL", "https://hooks.slack.com/services/T/B/review")
+	t.Setenv("SLACK_SIGNING_SECRET", "secret")
+	t.Setenv("HTTP_ADDR", ":8080")
+	t.Setenv("LEDGER_FILE", "/var/lib/history-bot/ledger.jsonl")
+	t.Setenv("APPROVAL_DEFAULT", "post")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "APPROVAL_DEFAULT") {
+		t.Fatalf("Load() error = %v, want an unknown APPROVAL_DEFAULT", err)
+	}
+
+	t.Setenv("APPROVAL_DEFAULT", "approve")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !cfg.ApprovalFor(cfg.Destinations[0]) || cfg.ApprovalFor(cfg.Destinations[1]) {
+		t.Error("ApprovalFor() should follow the per-destination setting")
+	}
+}
+
+func TestPromptValidation(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/T/B/X")
+
+	// A legacy fmt-style prompt still loads
+	t.Setenv("EVENT_SELECTION_PROMPT", "Pick %d events.")
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	t.Setenv("EVENT_SELECTION_PROMPT", "Pick {{.MaxEvent}} events.")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "EVENT_SELECTION_PROMPT") {
+		t.Fatalf("Load() error = %v, want a bad EVENT_SELECTION_PROMPT", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "prompt.tmpl")
+	if err := os.WriteFile(path, []byte("Pick {{.MaxEvents}} events for {{.Audience}}."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EVENT_SELECTION_PROMPT_FILE", path)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("Load() error = %v, want inline and file prompts rejected", err)
+	}
+
+	t.Setenv("EVENT_SELECTION_PROMPT", "")
+	t.Setenv("PROMPT_EXAMPLES_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "PROMPT_EXAMPLES_FILE") {
+		t.Fatalf("Load() error = %v, want a missing PROMPT_EXAMPLES_FILE", err)
+	}
+
+	t.Setenv("PROMPT_EXAMPLES_FILE", "")
+	t.Setenv("PROMPT_AUDIENCE", "the platform team")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	prompt, err := cfg.EventPrompt()
+	if err != nil || prompt == nil {
+		t.Fatalf("EventPrompt() = %v, %v, want the file prompt", prompt, err)
+	}
+	data, _ := cfg.PromptData()
+	data.MaxEvents = 2
+	text, _ := prompt.Render(data)
+	if text != "Pick 2 events for the platform team." {
+		t.Errorf("rendered prompt = %q", text)
+	}
+}
+
+func TestBudgetValidation(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/T/B/X")
+
+	t.Setenv("CLAUDE_BUDGET_MODEL", "claude-haiku-4-5")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CLAUDE_BUDGET_MODEL") {
+		t.Fatalf("Load() error = %v, want a budget model without a budget rejected", err)
+	}
+
+	t.Setenv("CLAUDE_MONTHLY_BUDGET", "-5")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CLAUDE_MONTHLY_BUDGET") {
+		t.Fatalf("Load() error = %v, want a negative budget rejected", err)
+	}
+
+	t.Setenv("CLAUDE_MONTHLY_BUDGET", "$20")
+	t.Setenv("CLAUDE_MODEL", "acme-llm")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "no price for acme-llm") {
+		t.Fatalf("Load() error = %v, want an unpriced model rejected", err)
+	}
+
+	t.Setenv("CLAUDE_PRICES", "acme-llm=0.5/2")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ClaudeMonthlyBudget != 20 {
+		t.Errorf("ClaudeMonthlyBudget = %v, want 20", cfg.ClaudeMonthlyBudget)
+	}
+	if cfg.ClaudeMonthlyLimit != 20 {
+		t.Errorf("ClaudeMonthlyLimit = %v, want the monthly budget", cfg.ClaudeMonthlyLimit)
+	}
+	if _, ok := cfg.ClaudePrices.Lookup("claude-haiku-4-5"); !ok {
+		t.Error("CLAUDE_PRICES should add to the default prices, not replace them")
+	}
+
+	t.Setenv("CLAUDE_MONTHLY_LIMIT", "10")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CLAUDE_MONTHLY_LIMIT") {
+		t.Fatalf("Load() error = %v, want a limit under the monthly budget rejected", err)
+	}
+}
@@ -0,0 +1,219 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// Entry is a curated anniversary, such as a founding day or first release
+type Entry struct {
+	Year        int    `json:"year" yaml:"year"` // Negative for BC
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description" yaml:"description"`
+	Category    string `json:"category" yaml:"category"`
+	Link        string `json:"link" yaml:"link"`
+}
+
+// csvHeader lists the columns of a CSV dataset file, in order
+var csvHeader = []string{"date", "year", "title", "description", "category", "link"}
+
+// Dataset holds curated entries keyed by month and day ("MM-DD")
+type Dataset struct {
+	entries map[string][]Entry
+}
+
+// Load reads every .yaml, .yml, .json and .csv file in dir. YAML and JSON
+// files map "MM-DD" keys to lists of entries; CSV files have a header row of
+// date,year,title,description,category,link. Every entry is validated, and
+// all problems are reported together.
+func Load(dir string) (*Dataset, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset directory: %w", err)
+	}
+
+	ds := &Dataset{entries: make(map[string][]Entry)}
+	var errs []error
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+
+		var parse func([]byte) (map[string][]Entry, error)
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".yaml", ".yml":
+			parse = parseYAML
+		case ".json":
+			parse = parseJSON
+		case ".csv":
+			parse = parseCSV
+		default:
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+
+		entries, err := parse(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+
+		for _, key := range sortedKeys(entries) {
+			for i, entry := range entries[key] {
+				if err := validate(key, entry); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s entry %d: %w", path, key, i+1, err))
+					continue
+				}
+				ds.entries[key] = append(ds.entries[key], entry)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return ds, nil
+}
+
+// Len returns the total number of entries
+func (d *Dataset) Len() int {
+	n := 0
+	for _, entries := range d.entries {
+		n += len(entries)
+	}
+	return n
+}
+
+// Events returns the entries for the given date's month and day as
+// historical events
+func (d *Dataset) Events(date time.Time) []rss.HistoricalEvent {
+	entries := d.entries[Key(date)]
+
+	events := make([]rss.HistoricalEvent, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, rss.HistoricalEvent{
+			Kind:        rss.KindEvent,
+			Year:        rss.FormatYear(entry.Year),
+			SortYear:    entry.Year,
+			Title:       entry.Title,
+			Description: entry.Description,
+			Category:    entry.Category,
+			Link:        entry.Link,
+		})
+	}
+	return events
+}
+
+// Key returns the "MM-DD" key for a date
+func Key(date time.Time) string {
+	return fmt.Sprintf("%02d-%02d", int(date.Month()), date.Day())
+}
+
+// validate checks a single entry and its key
+func validate(key string, entry Entry) error {
+	// 2000 is a leap year, so 02-29 is accepted
+	if _, err := time.Parse("2006-01-02", "2000-"+key); err != nil {
+		return fmt.Errorf("invalid date key %q (want MM-DD)", key)
+	}
+	if entry.Year == 0 {
+		return fmt.Errorf("year is required")
+	}
+	if strings.TrimSpace(entry.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if entry.Link != "" {
+		u, err := url.Parse(entry.Link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid link %q", entry.Link)
+		}
+	}
+	return nil
+}
+
+func parseYAML(data []byte) (map[string][]Entry, error) {
+	entries := make(map[string][]Entry)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&entries); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	return entries, nil
+}
+
+func parseJSON(data []byte) (map[string][]Entry, error) {
+	entries := make(map[string][]Entry)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return entries, nil
+}
+
+func parseCSV(data []byte) (map[string][]Entry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = len(csvHeader)
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	for i, column := range csvHeader {
+		if strings.ToLower(strings.TrimSpace(records[0][i])) != column {
+			return nil, fmt.Errorf("invalid CSV header %v (want %s)", records[0], strings.Join(csvHeader, ","))
+		}
+	}
+
+	entries := make(map[string][]Entry)
+	for i, record := range records[1:] {
+		year, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid year %q", i+2, record[1])
+		}
+		key := strings.TrimSpace(record[0])
+		entries[key] = append(entries[key], Entry{
+			Year:        year,
+			Title:       strings.TrimSpace(record[2]),
+			Description: strings.TrimSpace(record[3]),
+			Category:    strings.TrimSpace(record[4]),
+			Link:        strings.TrimSpace(record[5]),
+		})
+	}
+	return entries, nil
+}
+
+// sortedKeys returns map keys in order, so errors are reported deterministically
+func sortedKeys(entries map[string][]Entry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
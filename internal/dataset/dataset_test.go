@@ -0,0 +1,94 @@
+package dataset
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	ds, err := Load("testdata")
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	if ds.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", ds.Len())
+	}
+
+	events := ds.Events(time.Date(2024, 7, 20, 9, 0, 0, 0, time.UTC))
+	if len(events) != 3 {
+		t.Fatalf("len(Events(07-20)) = %d, want 3: %+v", len(events), events)
+	}
+
+	// Files are read in name order: company.yaml, milestones.json, offices.csv
+	if events[0].Year != "2014" || events[0].Title != "First public release" || events[0].Link != "" {
+		t.Errorf("events[0] = %+v, want the YAML entry", events[0])
+	}
+	if events[1].Title != "One millionth signup" || events[1].Category != "Milestones" {
+		t.Errorf("events[1] = %+v, want the JSON entry", events[1])
+	}
+	if events[2].Title != "Berlin office opens" || events[2].Link != "https://example.com/berlin" {
+		t.Errorf("events[2] = %+v, want the CSV entry", events[2])
+	}
+
+	if got := ds.Events(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); len(got) != 0 {
+		t.Errorf("Events(01-01) = %+v, want none", got)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"bad key", "a.yaml", "\"7-20\":\n  - year: 2000\n    title: X\n", `invalid date key "7-20"`},
+		{"impossible date", "a.yaml", "\"02-30\":\n  - year: 2000\n    title: X\n", `invalid date key "02-30"`},
+		{"missing title", "a.json", `{"07-20": [{"year": 2000}]}`, "title is required"},
+		{"missing year", "a.yaml", "\"07-20\":\n  - title: X\n", "year is required"},
+		{"bad link", "a.yaml", "\"07-20\":\n  - year: 2000\n    title: X\n    link: not a url\n", "invalid link"},
+		{"unknown field", "a.json", `{"07-20": [{"year": 2000, "title": "X", "when": "now"}]}`, "invalid JSON"},
+		{"bad csv header", "a.csv", "day,year,title,description,category,link\n", "invalid CSV header"},
+		{"bad csv year", "a.csv", "date,year,title,description,category,link\n07-20,soon,X,,,\n", "invalid year"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadLeapDay(t *testing.T) {
+	dir := t.TempDir()
+	content := "\"02-29\":\n  - year: 2016\n    title: Leap day launch\n"
+	if err := os.WriteFile(filepath.Join(dir, "leap.yml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if got := ds.Events(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)); len(got) != 1 {
+		t.Errorf("Events(02-29) = %+v, want 1 event", got)
+	}
+}
+
+func TestLoadMissingDirectory(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Load() should return error for a missing directory")
+	}
+}
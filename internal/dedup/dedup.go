@@ -0,0 +1,250 @@
+package dedup
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// Options sets how alike two events of the same year must be to count as
+// the same event. Similarities run from 0 (nothing shared) to 1.
+type Options struct {
+	// TitleSimilarity is the least share of title words two events must
+	// have in common (Dice coefficient)
+	TitleSimilarity float64
+	// TextSimilarity is the least share of the shorter event's title and
+	// description words found in the other's (overlap coefficient). It
+	// catches the same moment told in different words, such as "Apollo 11
+	// lands" and "First Moon landing".
+	TextSimilarity float64
+}
+
+// DefaultOptions are tuned to merge the same event from different feeds
+// without merging different events of the same year
+var DefaultOptions = Options{TitleSimilarity: 0.6, TextSimilarity: 0.5}
+
+// minTextWords is the fewest words an event's text needs for TextSimilarity
+// to apply, since a few shared words in short texts mean little
+const minTextWords = 5
+
+// Merge finds events that describe the same moment, as when several feeds
+// carry it under different titles, and merges each group into one canonical
+// event in place of the group's first. Two events are the same if they have
+// the same kind and year and their titles match once normalized, or their
+// titles or texts are similar enough. It returns the events, in order, and
+// how many were merged away.
+func Merge(events []rss.HistoricalEvent, opts Options) ([]rss.HistoricalEvent, int) {
+	prints := make([]fingerprint, len(events))
+	for i, event := range events {
+		prints[i] = newFingerprint(event)
+	}
+
+	// Group events into sets of duplicates, comparing only within a year
+	groups := newUnionFind(len(events))
+	byYear := make(map[string][]int)
+	for i, p := range prints {
+		for _, j := range byYear[p.key] {
+			if groups.find(i) != groups.find(j) && same(prints[j], p, opts) {
+				groups.union(j, i)
+			}
+		}
+		byYear[p.key] = append(byYear[p.key], i)
+	}
+
+	members := make(map[int][]int)
+	for i := range events {
+		root := groups.find(i)
+		members[root] = append(members[root], i)
+	}
+
+	out := make([]rss.HistoricalEvent, 0, len(members))
+	for i := range events {
+		group := members[groups.find(i)]
+		if group[0] != i {
+			continue
+		}
+		out = append(out, merge(events, group))
+	}
+	return out, len(events) - len(out)
+}
+
+// same reports whether two events of the same year are the same event
+func same(a, b fingerprint, opts Options) bool {
+	if a.title == b.title && a.title != "" {
+		return true
+	}
+	if dice(a.titleWords, b.titleWords) >= opts.TitleSimilarity {
+		return true
+	}
+	if len(a.textWords) < minTextWords || len(b.textWords) < minTextWords {
+		return false
+	}
+	return overlap(a.textWords, b.textWords) >= opts.TextSimilarity
+}
+
+// merge combines a group of duplicates, given as indexes in feed order. The
+// first event's title, category and image are kept, falling back to the
+// others' where it has none; the description is the richest of the group and
+// the links are every link in the group.
+func merge(events []rss.HistoricalEvent, group []int) rss.HistoricalEvent {
+	merged := events[group[0]]
+	merged.Links = nil
+
+	seen := make(map[string]bool)
+	addLink := func(link string) {
+		if link = strings.TrimSpace(link); link != "" && !seen[link] {
+			seen[link] = true
+			merged.Links = append(merged.Links, link)
+		}
+	}
+
+	for _, i := range group {
+		event := events[i]
+		if utf8.RuneCountInString(strings.TrimSpace(event.Description)) > utf8.RuneCountInString(strings.TrimSpace(merged.Description)) {
+			merged.Description = event.Description
+		}
+		if merged.Category == "" {
+			merged.Category = event.Category
+		}
+		if merged.ImageURL == "" {
+			merged.ImageURL = event.ImageURL
+		}
+		if merged.Link == "" {
+			merged.Link = event.Link
+		}
+		addLink(event.Link)
+		for _, link := range event.Links {
+			addLink(link)
+		}
+	}
+
+	if len(merged.Links) == 0 {
+		merged.Links = nil
+	}
+	return merged
+}
+
+// fingerprint is the normalized form of an event used for comparison
+type fingerprint struct {
+	key        string // Kind and year; only events with the same key are compared
+	title      string // Normalized title
+	titleWords map[string]bool
+	textWords  map[string]bool // Title and description words
+}
+
+func newFingerprint(event rss.HistoricalEvent) fingerprint {
+	// Years compare by number where they can be parsed, so "1969" matches
+	// "AD 1969"
+	year := strings.ToLower(strings.Join(strings.Fields(event.Year), " "))
+	if n, ok := rss.SortYear(event); ok {
+		year = strconv.Itoa(n)
+	}
+	title := words(event.Title)
+	return fingerprint{
+		key:        string(rss.KindOf(event)) + "|" + year,
+		title:      strings.Join(title, " "),
+		titleWords: contentWords(title),
+		textWords:  contentWords(append(title, words(htmltext.StripLinks(event.Description))...)),
+	}
+}
+
+// words lowercases text and splits it into words of letters and digits
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// stopWords carry no meaning of their own for matching
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "has": true, "he": true, "her": true, "his": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "of": true, "on": true,
+	"or": true, "s": true, "she": true, "that": true, "the": true, "their": true, "they": true,
+	"this": true, "to": true, "was": true, "were": true, "which": true, "who": true, "with": true,
+}
+
+// contentWords returns the set of stemmed words, leaving out stop words
+func contentWords(ws []string) map[string]bool {
+	set := make(map[string]bool, len(ws))
+	for _, w := range ws {
+		if !stopWords[w] {
+			set[stem(w)] = true
+		}
+	}
+	return set
+}
+
+// stem strips common English suffixes, so "lands", "landed" and "landing"
+// all become "land". Short words are left alone.
+func stem(w string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(w) > len(suffix)+3 && strings.HasSuffix(w, suffix) {
+			return strings.TrimSuffix(w, suffix)
+		}
+	}
+	return w
+}
+
+// dice is the Dice coefficient of two sets: shared words over average size
+func dice(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	return 2 * float64(shared(a, b)) / float64(len(a)+len(b))
+}
+
+// overlap is the overlap coefficient of two sets: shared words over the
+// smaller set's size
+func overlap(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	return float64(shared(a, b)) / float64(min(len(a), len(b)))
+}
+
+func shared(a, b map[string]bool) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	n := 0
+	for w := range a {
+		if b[w] {
+			n++
+		}
+	}
+	return n
+}
+
+// unionFind groups indexes into disjoint sets
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	parent := make(unionFind, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	return parent
+}
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+// union joins the sets of i and j, keeping the lower index as the root so
+// a group's root is its first event
+func (u unionFind) union(i, j int) {
+	ri, rj := u.find(i), u.find(j)
+	if ri > rj {
+		ri, rj = rj, ri
+	}
+	u[rj] = ri
+}
@@ -0,0 +1,102 @@
+package dedup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+func TestMerge(t *testing.T) {
+	events := []rss.HistoricalEvent{
+		{Year: "1969", Title: "Apollo 11 lands", Category: "Science",
+			Description: "Neil Armstrong and Buzz Aldrin land the lunar module Eagle on the Moon.",
+			Link:        "https://feed-a.example/apollo"},
+		{Year: "1815", Title: "Battle of Waterloo", Description: "Napoleon is defeated by Wellington and Blücher."},
+		{Year: "1969", Title: "First Moon landing",
+			Description: "Apollo 11's lunar module Eagle lands on the Moon; Neil Armstrong becomes the first person to walk on its surface.",
+			Link:        "https://feed-b.example/moon", ImageURL: "https://feed-b.example/moon.jpg"},
+		{Year: "1969", Title: "Apollo 11 Lands!", Links: []string{"https://en.wikipedia.org/wiki/Apollo_11", "https://feed-a.example/apollo"}},
+		{Year: "1969", Title: "Woodstock festival opens", Description: "The music festival opens on a dairy farm in Bethel, New York."},
+		{Year: "1970", Title: "Apollo 11 lands"},
+		{Kind: rss.KindBirth, Year: "1969", Title: "Apollo 11 lands"},
+	}
+
+	got, merged := Merge(events, DefaultOptions)
+	if merged != 2 || len(got) != 5 {
+		t.Fatalf("Merge() merged %d, left %d; want 2 and 5: %+v", merged, len(got), got)
+	}
+
+	apollo := got[0]
+	if apollo.Title != "Apollo 11 lands" || apollo.Category != "Science" {
+		t.Errorf("merged event = %+v, want the first event's title and category", apollo)
+	}
+	if apollo.Description != events[2].Description {
+		t.Errorf("Description = %q, want the richest one", apollo.Description)
+	}
+	if apollo.Link != "https://feed-a.example/apollo" || apollo.ImageURL != "https://feed-b.example/moon.jpg" {
+		t.Errorf("Link = %q, ImageURL = %q", apollo.Link, apollo.ImageURL)
+	}
+	wantLinks := []string{"https://feed-a.example/apollo", "https://feed-b.example/moon", "https://en.wikipedia.org/wiki/Apollo_11"}
+	if !reflect.DeepEqual(apollo.Links, wantLinks) {
+		t.Errorf("Links = %v, want %v", apollo.Links, wantLinks)
+	}
+
+	var titles []string
+	for _, event := range got {
+		titles = append(titles, event.Title)
+	}
+	wantTitles := []string{"Apollo 11 lands", "Battle of Waterloo", "Woodstock festival opens", "Apollo 11 lands", "Apollo 11 lands"}
+	if !reflect.DeepEqual(titles, wantTitles) {
+		t.Errorf("titles = %v, want %v", titles, wantTitles)
+	}
+
+	if events[0].Description == apollo.Description || events[0].Links != nil {
+		t.Error("Merge() modified its input")
+	}
+}
+
+func TestSame(t *testing.T) {
+	tests := []struct {
+		a, b rss.HistoricalEvent
+		want bool
+	}{
+		{
+			rss.HistoricalEvent{Title: "Berlin Wall falls"},
+			rss.HistoricalEvent{Title: "The fall of the Berlin Wall"},
+			true,
+		},
+		{
+			rss.HistoricalEvent{Title: "Titanic sinks", Description: "The RMS Titanic sinks in the North Atlantic after striking an iceberg."},
+			rss.HistoricalEvent{Title: "Maiden voyage disaster", Description: "RMS Titanic strikes an iceberg and sinks in the North Atlantic Ocean."},
+			true,
+		},
+		{
+			rss.HistoricalEvent{Title: "Allied troops land in Normandy", Description: "Operation Overlord begins with landings on five beaches."},
+			rss.HistoricalEvent{Title: "Battle of Saipan begins", Description: "United States Marines land on Saipan in the Mariana Islands."},
+			false,
+		},
+		{
+			// Too little text to judge by shared words
+			rss.HistoricalEvent{Title: "Treaty signed", Description: "Peace."},
+			rss.HistoricalEvent{Title: "Peace agreed", Description: "Treaty."},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got := same(newFingerprint(tt.a), newFingerprint(tt.b), DefaultOptions); got != tt.want {
+			t.Errorf("same(%q, %q) = %v, want %v", tt.a.Title, tt.b.Title, got, tt.want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	for _, w := range []string{"lands", "landed", "landing", "land"} {
+		if got := stem(w); got != "land" {
+			t.Errorf("stem(%q) = %q, want land", w, got)
+		}
+	}
+	if got := stem("bus"); got != "bus" {
+		t.Errorf("stem(%q) = %q, want it unchanged", "bus", got)
+	}
+}
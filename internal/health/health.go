@@ -0,0 +1,237 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/scheduler"
+)
+
+// Names of the external dependencies whose last call is tracked
+const (
+	ComponentFeeds    = "feeds"
+	ComponentHolidays = "holidays"
+	ComponentLLM      = "llm"
+)
+
+// ComponentStatus is the outcome of the most recent call to a dependency
+type ComponentStatus struct {
+	OK          bool      `json:"ok"`
+	LastChecked time.Time `json:"last_checked"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ComponentTracker records the outcome of calls to external dependencies
+type ComponentTracker struct {
+	mu         sync.Mutex
+	components map[string]ComponentStatus
+}
+
+// NewComponentTracker creates an empty component tracker
+func NewComponentTracker() *ComponentTracker {
+	return &ComponentTracker{
+		components: make(map[string]ComponentStatus),
+	}
+}
+
+// Components is the tracker the rss, llm and slack packages report to
+var Components = NewComponentTracker()
+
+// Record stores the outcome of a call to the named component
+func (t *ComponentTracker) Record(name string, err error) {
+	status := ComponentStatus{OK: err == nil, LastChecked: time.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.components[name] = status
+}
+
+// Snapshot returns a copy of all recorded component statuses
+func (t *ComponentTracker) Snapshot() map[string]ComponentStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := make(map[string]ComponentStatus, len(t.components))
+	for name, status := range t.components {
+		snapshot[name] = status
+	}
+	return snapshot
+}
+
+// WaitTracker records when runs are waiting on people, such as for an
+// approval decision. Time spent waiting doesn't count towards a run being
+// stuck.
+type WaitTracker struct {
+	mu      sync.Mutex
+	active  int       // Waits in progress
+	lastEnd time.Time // When the most recent wait ended
+	now     func() time.Time
+}
+
+// NewWaitTracker creates a wait tracker with no waits
+func NewWaitTracker() *WaitTracker {
+	return &WaitTracker{now: time.Now}
+}
+
+// Waits is the tracker the approval package reports to
+var Waits = NewWaitTracker()
+
+// Begin records the start of a wait and returns a function that records its
+// end. Waits may overlap, as when several destinations await approval.
+func (t *WaitTracker) Begin() (end func()) {
+	t.mu.Lock()
+	t.active++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.active--
+			t.lastEnd = t.now()
+		})
+	}
+}
+
+// State reports whether a wait is in progress and when the last one ended
+func (t *WaitTracker) State() (waiting bool, lastEnd time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active > 0, t.lastEnd
+}
+
+// StatusProvider reports the scheduler's current status
+type StatusProvider interface {
+	Status() scheduler.Status
+}
+
+// Checker evaluates liveness and readiness from scheduler and component state
+type Checker struct {
+	scheduler   StatusProvider
+	components  *ComponentTracker
+	waits       *WaitTracker
+	maxFailures int
+	stuckAfter  time.Duration
+	now         func() time.Time
+}
+
+// NewChecker creates a health checker. A job running longer than stuckAfter,
+// not counting time spent in waits, or maxFailures consecutive failed runs,
+// make the bot unhealthy. waits may be nil.
+func NewChecker(sched StatusProvider, components *ComponentTracker, waits *WaitTracker, maxFailures int, stuckAfter time.Duration) *Checker {
+	return &Checker{
+		scheduler:   sched,
+		components:  components,
+		waits:       waits,
+		maxFailures: maxFailures,
+		stuckAfter:  stuckAfter,
+		now:         time.Now,
+	}
+}
+
+// Report is the JSON body returned by the health endpoints
+type Report struct {
+	Status     string                     `json:"status"`
+	Problems   []string                   `json:"problems,omitempty"`
+	Scheduler  scheduler.Status           `json:"scheduler"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Liveness reports whether the process is working at all. It fails when the
+// scheduler has stopped, a run is stuck, or runs keep failing, so that the
+// orchestrator restarts the bot.
+func (c *Checker) Liveness() Report {
+	status := c.scheduler.Status()
+	var problems []string
+
+	if status.State == scheduler.StateStopped {
+		problems = append(problems, "scheduler has stopped")
+	}
+	if c.stuckAfter > 0 {
+		if working := c.working(status); working > c.stuckAfter {
+			problems = append(problems, fmt.Sprintf("job has been working for %v", working.Round(time.Second)))
+		}
+	}
+	if c.maxFailures > 0 && status.ConsecutiveFailures >= c.maxFailures {
+		problems = append(problems, fmt.Sprintf("last %d runs failed", status.ConsecutiveFailures))
+	}
+
+	return c.report(status, problems)
+}
+
+// working returns how long the current job has been running since it last
+// waited on people, or zero while it's waiting or idle
+func (c *Checker) working(status scheduler.Status) time.Duration {
+	now := c.now()
+	running := status.Running(now)
+	if running == 0 || c.waits == nil {
+		return running
+	}
+	waiting, lastEnd := c.waits.State()
+	switch {
+	case waiting:
+		return 0
+	case lastEnd.After(status.LastRunStart):
+		return now.Sub(lastEnd)
+	}
+	return running
+}
+
+// Readiness reports whether the bot can take traffic: it must be live and
+// its scheduler started. The approval clicks are served alongside it, so the
+// outcome of the last run or of any one call to a dependency doesn't count:
+// the bot must stay reachable to be retried or to have its drafts approved,
+// and a dependency that failed once may well be back by the next run.
+// Persistent failures show in liveness instead.
+func (c *Checker) Readiness() Report {
+	report := c.Liveness()
+	problems := report.Problems
+
+	if report.Scheduler.State == scheduler.StateIdle {
+		problems = append(problems, "scheduler hasn't started")
+	}
+
+	return c.report(report.Scheduler, problems)
+}
+
+func (c *Checker) report(status scheduler.Status, problems []string) Report {
+	report := Report{
+		Status:     "ok",
+		Problems:   problems,
+		Scheduler:  status,
+		Components: c.components.Snapshot(),
+	}
+	if len(problems) > 0 {
+		report.Status = "unhealthy"
+	}
+	return report
+}
+
+// LivenessHandler serves /healthz
+func (c *Checker) LivenessHandler() http.Handler {
+	return reportHandler(c.Liveness)
+}
+
+// ReadinessHandler serves /readyz
+func (c *Checker) ReadinessHandler() http.Handler {
+	return reportHandler(c.Readiness)
+}
+
+func reportHandler(check func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := check()
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
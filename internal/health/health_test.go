@@ -0,0 +1,158 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/scheduler"
+)
+
+type fakeScheduler struct {
+	status scheduler.Status
+}
+
+func (f *fakeScheduler) Status() scheduler.Status {
+	return f.status
+}
+
+func TestLiveness(t *testing.T) {
+	now := time.Date(2024, 7, 20, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		status  scheduler.Status
+		healthy bool
+	}{
+		{
+			name:    "Waiting for next run",
+			status:  scheduler.Status{State: scheduler.StateWaiting},
+			healthy: true,
+		},
+		{
+			name:    "Running briefly",
+			status:  scheduler.Status{State: scheduler.StateRunning, LastRunStart: now.Add(-time.Minute)},
+			healthy: true,
+		},
+		{
+			name:    "Stuck run",
+			status:  scheduler.Status{State: scheduler.StateRunning, LastRunStart: now.Add(-time.Hour)},
+			healthy: false,
+		},
+		{
+			name:    "Repeated failures",
+			status:  scheduler.Status{State: scheduler.StateWaiting, ConsecutiveFailures: 3, LastError: "boom"},
+			healthy: false,
+		},
+		{
+			name:    "Stopped",
+			status:  scheduler.Status{State: scheduler.StateStopped},
+			healthy: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(&fakeScheduler{status: tt.status}, NewComponentTracker(), nil, 3, 30*time.Minute)
+			checker.now = func() time.Time { return now }
+
+			report := checker.Liveness()
+			if healthy := report.Status == "ok"; healthy != tt.healthy {
+				t.Errorf("Liveness() status = %q (problems %v), want healthy=%v", report.Status, report.Problems, tt.healthy)
+			}
+		})
+	}
+}
+
+func TestLivenessIgnoresWaits(t *testing.T) {
+	now := time.Date(2024, 7, 20, 12, 0, 0, 0, time.UTC)
+	sched := &fakeScheduler{status: scheduler.Status{State: scheduler.StateRunning, LastRunStart: now.Add(-3 * time.Hour)}}
+	waits := NewWaitTracker()
+	waits.now = func() time.Time { return now.Add(-10 * time.Minute) }
+	checker := NewChecker(sched, NewComponentTracker(), waits, 3, 30*time.Minute)
+	checker.now = func() time.Time { return now }
+
+	// Waiting for approval for hours isn't being stuck
+	end := waits.Begin()
+	if report := checker.Liveness(); report.Status != "ok" {
+		t.Errorf("Liveness() = %q (problems %v) while waiting, want ok", report.Status, report.Problems)
+	}
+
+	// Once the wait ends, the run has only been working since
+	end()
+	if report := checker.Liveness(); report.Status != "ok" {
+		t.Errorf("Liveness() = %q (problems %v) 10 minutes after the wait, want ok", report.Status, report.Problems)
+	}
+	checker.now = func() time.Time { return now.Add(time.Hour) }
+	if report := checker.Liveness(); report.Status == "ok" {
+		t.Error("Liveness() should fail when the run keeps going long after the wait")
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	components := NewComponentTracker()
+	sched := &fakeScheduler{status: scheduler.Status{State: scheduler.StateIdle}}
+	checker := NewChecker(sched, components, nil, 3, 30*time.Minute)
+
+	if report := checker.Readiness(); report.Status == "ok" {
+		t.Error("Readiness() should fail before the scheduler starts")
+	}
+
+	// A Claude call that failed and fell back, such as softening an event,
+	// followed by a successful run
+	sched.status.State = scheduler.StateWaiting
+	components.Record(ComponentFeeds, nil)
+	components.Record(ComponentLLM, errors.New("status 529"))
+	components.Record("sink:slack", nil)
+	if report := checker.Readiness(); report.Status != "ok" {
+		t.Errorf("Readiness() = %q (problems %v), want ok after a non-fatal LLM failure", report.Status, report.Problems)
+	}
+
+	// Neither a failed run nor a failed dependency stops the bot being ready
+	// to be retried or to take approval clicks
+	components.Record(ComponentFeeds, errors.New("status 503"))
+	components.Record("sink:slack", errors.New("status 500"))
+	sched.status.LastError = "feeds: no events"
+	sched.status.ConsecutiveFailures = 1
+	if report := checker.Readiness(); report.Status != "ok" {
+		t.Errorf("Readiness() = %q (problems %v), want ok", report.Status, report.Problems)
+	}
+	if report := checker.Liveness(); report.Status != "ok" {
+		t.Errorf("Liveness() = %q, a single failure should not fail liveness", report.Status)
+	}
+
+	// Failures that fail liveness fail readiness too
+	sched.status.ConsecutiveFailures = 3
+	if report := checker.Readiness(); report.Status == "ok" {
+		t.Error("Readiness() should fail when liveness fails")
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	sched := &fakeScheduler{status: scheduler.Status{State: scheduler.StateWaiting}}
+	checker := NewChecker(sched, NewComponentTracker(), nil, 3, 30*time.Minute)
+
+	rec := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Scheduler.State != scheduler.StateWaiting {
+		t.Errorf("scheduler state = %q, want %q", report.Scheduler.State, scheduler.StateWaiting)
+	}
+
+	sched.status.State = scheduler.StateStopped
+	rec = httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz code = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
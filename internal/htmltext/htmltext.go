@@ -0,0 +1,449 @@
+package htmltext
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxListDepth caps the indentation of nested lists
+const maxListDepth = 4
+
+// ToText converts an HTML fragment, such as a feed item's description, to
+// plain text. Entities are decoded and whitespace collapsed as a browser
+// would. Paragraphs and other blocks are separated by a blank line, line
+// breaks and list items start new lines, and list items are marked with "•"
+// or their number. Links with http(s) and mailto targets become Markdown
+// links, [text](url), which every chat sink understands and SlackLinks and
+// PlainLinks convert for the rest; a link whose text is its URL becomes the
+// bare URL, and other links keep only their text. Scripts, styles and the
+// like are dropped. Malformed markup is tolerated: stray "<" characters are text
+// and unclosed elements end with the input.
+//
+// Text without any markup keeps its own line breaks, with its entities
+// decoded.
+func ToText(s string) string {
+	if !hasMarkup(s) {
+		return plainText(s)
+	}
+
+	c := &converter{}
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			if c.skip == 0 {
+				c.text(token.Data)
+			}
+		case html.StartTagToken:
+			c.start(token, false)
+		case html.SelfClosingTagToken:
+			c.start(token, true)
+		case html.EndTagToken:
+			c.end(token)
+		}
+	}
+	c.endLink()
+	return strings.TrimSpace(c.out.String())
+}
+
+// hasMarkup reports whether s holds any tags
+func hasMarkup(s string) bool {
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			return true
+		}
+	}
+}
+
+// plainText decodes the entities of text without markup and tidies its
+// whitespace, keeping single line breaks and at most one blank line
+func plainText(s string) string {
+	lines := strings.Split(html.UnescapeString(strings.ReplaceAll(s, "\r\n", "\n")), "\n")
+	var out []string
+	blank := false
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// skipped elements' contents aren't text
+var skipped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Head: true, atom.Title: true,
+	atom.Noscript: true, atom.Template: true, atom.Iframe: true, atom.Object: true,
+	atom.Svg: true, atom.Math: true, atom.Select: true, atom.Textarea: true,
+}
+
+// paragraphs are blocks separated from their neighbours by a blank line
+var paragraphs = map[atom.Atom]bool{
+	atom.P: true, atom.Blockquote: true, atom.Pre: true, atom.Table: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Figure: true, atom.Hr: true, atom.Dl: true,
+}
+
+// blocks start on a new line
+var blocks = map[atom.Atom]bool{
+	atom.Div: true, atom.Section: true, atom.Article: true, atom.Header: true,
+	atom.Footer: true, atom.Nav: true, atom.Aside: true, atom.Main: true,
+	atom.Tr: true, atom.Dt: true, atom.Dd: true, atom.Figcaption: true,
+	atom.Address: true, atom.Details: true, atom.Summary: true,
+}
+
+// list is an open <ul> or <ol>
+type list struct {
+	ordered bool
+	next    int // Number of the next item of an ordered list
+}
+
+// link is an open <a>
+type link struct {
+	href  string // Empty if the target isn't linkable
+	label strings.Builder
+}
+
+type converter struct {
+	out    strings.Builder
+	breaks int    // Newlines owed before the next text
+	space  bool   // A space is owed before the next text
+	marker string // List item marker owed before the next text
+	skip   int    // Depth inside skipped elements
+	pre    int    // Depth inside <pre>
+	lists  []list
+	link   *link
+}
+
+func (c *converter) start(token html.Token, selfClosing bool) {
+	a := token.DataAtom
+	if skipped[a] {
+		if !selfClosing {
+			c.skip++
+		}
+		return
+	}
+	if c.skip > 0 {
+		return
+	}
+
+	switch {
+	case a == atom.Br:
+		c.lineBreak()
+	case a == atom.A:
+		c.endLink() // <a> elements don't nest
+		c.link = &link{href: linkTarget(attr(token, "href"))}
+	case a == atom.Ul || a == atom.Ol:
+		c.block(c.paragraphBreak())
+		if !selfClosing {
+			c.lists = append(c.lists, list{ordered: a == atom.Ol, next: startNumber(token)})
+		}
+	case a == atom.Li:
+		c.block(1)
+		c.marker = c.listMarker()
+	case a == atom.Pre:
+		c.block(c.paragraphBreak())
+		if !selfClosing {
+			c.pre++
+		}
+	case paragraphs[a]:
+		c.block(c.paragraphBreak())
+	case blocks[a]:
+		c.block(1)
+	case a == atom.Td || a == atom.Th:
+		c.space = true
+	}
+}
+
+func (c *converter) end(token html.Token) {
+	a := token.DataAtom
+	if skipped[a] {
+		if c.skip > 0 {
+			c.skip--
+		}
+		return
+	}
+	if c.skip > 0 {
+		return
+	}
+
+	switch {
+	case a == atom.A:
+		c.endLink()
+	case a == atom.Ul || a == atom.Ol:
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		c.marker = ""
+		c.block(c.paragraphBreak())
+	case a == atom.Li:
+		c.marker = ""
+		c.block(1)
+	case a == atom.Pre:
+		if c.pre > 0 {
+			c.pre--
+		}
+		c.block(c.paragraphBreak())
+	case a == atom.Br:
+		// </br> is read as <br>, as browsers do
+		c.lineBreak()
+	case paragraphs[a]:
+		c.block(c.paragraphBreak())
+	case blocks[a]:
+		c.block(1)
+	}
+}
+
+// paragraphBreak is the break around a paragraph: a blank line, or a line
+// break inside a list, so items stay together
+func (c *converter) paragraphBreak() int {
+	if len(c.lists) > 0 {
+		return 1
+	}
+	return 2
+}
+
+// block owes at least n newlines before the next text
+func (c *converter) block(n int) {
+	c.endLink()
+	c.breaks = max(c.breaks, n)
+}
+
+// lineBreak adds a newline for a <br>. Unlike block, repeated breaks add up,
+// though never to more than a blank line.
+func (c *converter) lineBreak() {
+	c.endLink()
+	c.breaks = min(c.breaks+1, 2)
+}
+
+// listMarker returns the marker of a new list item, indented by its depth
+func (c *converter) listMarker() string {
+	if len(c.lists) == 0 {
+		return "• "
+	}
+	indent := strings.Repeat("  ", min(len(c.lists), maxListDepth)-1)
+	l := &c.lists[len(c.lists)-1]
+	if l.ordered {
+		l.next++
+		return indent + strconv.Itoa(l.next-1) + ". "
+	}
+	return indent + "• "
+}
+
+// text adds a text token, collapsing its whitespace outside <pre>
+func (c *converter) text(s string) {
+	if c.link != nil {
+		c.link.label.WriteString(s)
+		return
+	}
+	if c.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				c.breaks++
+			}
+			if line = strings.TrimRight(line, " \t\r"); line != "" {
+				c.write(line)
+			}
+		}
+		return
+	}
+
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			c.space = true
+		}
+		return
+	}
+	if startsWithSpace(s) {
+		c.space = true
+	}
+	c.write(strings.Join(words, " "))
+	if endsWithSpace(s) {
+		c.space = true
+	}
+}
+
+// write adds text after any breaks, list marker or space owed
+func (c *converter) write(s string) {
+	if c.out.Len() > 0 {
+		if c.breaks > 0 {
+			c.out.WriteString(strings.Repeat("\n", min(c.breaks, 2)))
+		} else if c.space && c.marker == "" {
+			c.out.WriteByte(' ')
+		}
+	}
+	c.out.WriteString(c.marker)
+	c.breaks, c.space, c.marker = 0, false, ""
+	c.out.WriteString(s)
+}
+
+// endLink writes the open link, if any: as a Markdown link if it has a
+// linkable target, else as its text
+func (c *converter) endLink() {
+	l := c.link
+	if l == nil {
+		return
+	}
+	c.link = nil
+
+	raw := l.label.String()
+	label := strings.Join(strings.Fields(raw), " ")
+	if startsWithSpace(raw) {
+		c.space = true
+	}
+
+	address := strings.TrimPrefix(l.href, "mailto:")
+	switch {
+	case l.href == "" && label == "":
+		return
+	case l.href == "":
+		c.write(label)
+	case strings.HasPrefix(l.href, "mailto:") && (label == "" || label == address):
+		c.write("[" + labelReplacer.Replace(address) + "](" + l.href + ")")
+	case label == "" || label == l.href:
+		c.write(l.href)
+	default:
+		c.write("[" + labelReplacer.Replace(label) + "](" + l.href + ")")
+	}
+
+	if endsWithSpace(raw) {
+		c.space = true
+	}
+}
+
+// labelReplacer keeps brackets in a label from ending the link early
+var labelReplacer = strings.NewReplacer("[", "(", "]", ")")
+
+// linkTarget returns href if it's an absolute http(s) or mailto URL that
+// can be linked, or "" if not. Parentheses are percent-encoded so they can't
+// end a Markdown link early.
+func linkTarget(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.ContainsAny(href, "<>|[] \t\n") {
+		return ""
+	}
+	href = strings.NewReplacer("(", "%28", ")", "%29").Replace(href)
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return ""
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return href
+}
+
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// startNumber returns the number of an ordered list's first item
+func startNumber(token html.Token) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(attr(token, "start"))); err == nil {
+		return n
+	}
+	return 1
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeftFunc(s[:1], isSpace) == ""
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRightFunc(s[len(s)-1:], isSpace) == ""
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// markdownLink matches a Markdown link written by ToText
+var markdownLink = regexp.MustCompile(`\[([^\[\]]*)\]\(((?:https?|mailto):[^()\s]+)\)`)
+
+// replaceLinks replaces each link in s with fn(label, target)
+func replaceLinks(s string, fn func(label, target string) string) string {
+	return markdownLink.ReplaceAllStringFunc(s, func(match string) string {
+		m := markdownLink.FindStringSubmatch(match)
+		return fn(strings.TrimSpace(m[1]), m[2])
+	})
+}
+
+// MarkdownLink returns a Markdown link to href labelled label, in the form
+// ToText writes, or "" if href isn't an absolute http(s) or mailto URL
+func MarkdownLink(label, href string) string {
+	if href = linkTarget(href); href == "" {
+		return ""
+	}
+	return "[" + labelReplacer.Replace(strings.Join(strings.Fields(label), " ")) + "](" + href + ")"
+}
+
+// StripLinks replaces the links in text with their labels, or with their
+// targets if they have none, for uses such as prompts where the URLs would
+// only take up room
+func StripLinks(s string) string {
+	return replaceLinks(s, func(label, target string) string {
+		if label != "" {
+			return label
+		}
+		return strings.TrimPrefix(target, "mailto:")
+	})
+}
+
+// SlackLinks converts the links in text to Slack's <url|label> form
+func SlackLinks(s string) string {
+	return replaceLinks(s, func(label, target string) string {
+		if label == "" {
+			return "<" + target + ">"
+		}
+		// Slack ends the label at the first ">"
+		return "<" + target + "|" + strings.NewReplacer("<", "‹", ">", "›").Replace(label) + ">"
+	})
+}
+
+// PlainLinks converts the links in text to "label (url)" for plain-text
+// output such as email, or to the address alone for a mailto link labelled
+// with it
+func PlainLinks(s string) string {
+	return replaceLinks(s, func(label, target string) string {
+		address := strings.TrimPrefix(target, "mailto:")
+		if label == "" || label == target || label == address {
+			return address
+		}
+		return label + " (" + address + ")"
+	})
+}
@@ -0,0 +1,126 @@
+package htmltext
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "rewrite the testdata .txt files with the current output")
+
+// TestCorpus converts each testdata/*.html fixture and compares the result to
+// the .txt file beside it. Run with -update after reviewing a change in
+// output.
+func TestCorpus(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.html")
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".html")
+		t.Run(name, func(t *testing.T) {
+			in, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ToText(string(in)) + "\n"
+
+			golden := strings.TrimSuffix(fixture, ".html") + ".txt"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("ToText(%s) =\n%s\nwant\n%s", fixture, got, want)
+			}
+		})
+	}
+}
+
+func TestToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Remove basic HTML tags", "<p>Hello <strong>World</strong></p>", "Hello World"},
+		{"Convert br tags to newlines", "Line 1<br>Line 2<br/>Line 3", "Line 1\nLine 2\nLine 3"},
+		{"Separate blocks", "<div><p>Test</p><span>Content</span></div>", "Test\n\nContent"},
+		{"Handle empty string", "", ""},
+		{"Handle plain text", "Plain text without tags", "Plain text without tags"},
+		{"Decode entities", "<p>Rock &amp; roll&#8217;s &quot;birth&quot;</p>", "Rock & roll’s \"birth\""},
+		{"Keep a lone less-than sign", "<b>1 < 2</b>", "1 < 2"},
+		{"Format links", `<a href="https://example.com">Example</a>!`, "[Example](https://example.com)!"},
+		{"Space around links", `See <a href="https://example.com"> this </a>page`, "See [this](https://example.com) page"},
+		{"Bare links", `<a href="https://example.com">https://example.com</a>`, "https://example.com"},
+		{"Parentheses in links", `<a href="https://en.wikipedia.org/wiki/Mercury_(planet)">Mercury [planet]</a>`, "[Mercury (planet)](https://en.wikipedia.org/wiki/Mercury_%28planet%29)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToText(tt.input); got != tt.expected {
+				t.Errorf("ToText(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLinks(t *testing.T) {
+	in := "See [the article](https://example.com/a), https://example.com/b and [x@example.com](mailto:x@example.com). [1] < 2 > 0"
+	tests := []struct {
+		name string
+		fn   func(string) string
+		want string
+	}{
+		{"StripLinks", StripLinks, "See the article, https://example.com/b and x@example.com. [1] < 2 > 0"},
+		{"SlackLinks", SlackLinks, "See <https://example.com/a|the article>, https://example.com/b and <mailto:x@example.com|x@example.com>. [1] < 2 > 0"},
+		{"PlainLinks", PlainLinks, "See the article (https://example.com/a), https://example.com/b and x@example.com. [1] < 2 > 0"},
+	}
+	for _, tt := range tests {
+		if got := tt.fn(in); got != tt.want {
+			t.Errorf("%s() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownLink(t *testing.T) {
+	if got := MarkdownLink("Mercury [planet]", "https://en.wikipedia.org/wiki/Mercury_(planet)"); got != "[Mercury (planet)](https://en.wikipedia.org/wiki/Mercury_%28planet%29)" {
+		t.Errorf("MarkdownLink() = %q", got)
+	}
+	if got := MarkdownLink("x", "javascript:alert(1)"); got != "" {
+		t.Errorf("MarkdownLink() = %q, want an unlinkable target rejected", got)
+	}
+}
+
+func FuzzToText(f *testing.F) {
+	for _, seed := range []string{
+		"", "plain", "<p>a</p>", "<a href=\"https://x.io\">x", "<ul><li>a<ol><li>b",
+		"<script>", "</p></p>", "1 < 2", "&amp;&#0;&#xD800;", "<a href=\"https://x.io|y\">z</a>", "<a href=\"https://x.io/(a)\">[b]</a>",
+		"<pre>\n\n\nx</pre>", "<a href=https://x.io>a<p>b</a>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		out := ToText(in)
+		if utf8.ValidString(in) && !utf8.ValidString(out) {
+			t.Fatalf("ToText(%q) = %q is not valid UTF-8", in, out)
+		}
+		if out != strings.TrimSpace(out) {
+			t.Fatalf("ToText(%q) = %q has surrounding whitespace", in, out)
+		}
+		if strings.Contains(out, "\n\n\n") {
+			t.Fatalf("ToText(%q) = %q has more than one blank line in a row", in, out)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// userAgent identifies the bot to image hosts, some of which reject Go's default
+const userAgent = "history-slackbot/1.0 (https://github.com/dpeterka/history-slackbot)"
+
+// Checker verifies that image URLs point at images Slack can fetch
+type Checker struct {
+	client *http.Client
+}
+
+// NewChecker creates an image URL checker
+func NewChecker() *Checker {
+	return &Checker{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Check returns an error unless the URL is an absolute http(s) URL that
+// responds successfully with an image content type. It tries a HEAD request
+// first and falls back to GET for hosts that don't support HEAD.
+func (c *Checker) Check(ctx context.Context, imageURL string) error {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid image URL %q", imageURL)
+	}
+
+	resp, err := c.do(ctx, http.MethodHead, imageURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = c.do(ctx, http.MethodGet, imageURL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch image: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("image request failed with status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return fmt.Errorf("not an image (content type %q)", contentType)
+	}
+
+	return nil
+}
+
+// do sends a request and closes the body; only the status and headers are used
+func (c *Checker) do(ctx context.Context, method, imageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,54 @@
+package images
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/photo.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	})
+	mux.HandleFunc("/get-only.png", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/missing.jpg", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	checker := NewChecker()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"image", server.URL + "/photo.jpg", false},
+		{"HEAD not allowed falls back to GET", server.URL + "/get-only.png", false},
+		{"not an image", server.URL + "/page.html", true},
+		{"not found", server.URL + "/missing.jpg", true},
+		{"relative URL", "/photo.jpg", true},
+		{"unsupported scheme", "ftp://example.com/photo.jpg", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checker.Check(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
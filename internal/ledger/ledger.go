@@ -0,0 +1,168 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record types
+const (
+	TypeDraft    = "draft"    // A message sent for approval
+	TypeDecision = "decision" // The outcome of an approval
+	TypePost     = "post"     // What a run delivered
+	TypeUsage    = "usage"    // Tokens and cost of a Claude API call
+)
+
+// Record is one line of the ledger
+type Record struct {
+	Time     time.Time       `json:"time"`
+	RunID    string          `json:"run_id"`
+	Pipeline string          `json:"pipeline"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Post is the data of a TypePost record
+type Post struct {
+	Date   string   `json:"date"` // Day posted for, as 2006-01-02
+	Titles []string `json:"titles"`
+}
+
+// Ledger is an append-only JSON Lines file recording what each run did.
+// It's safe for concurrent use by the pipelines of one process.
+type Ledger struct {
+	mu   sync.Mutex
+	path string
+	now  func() time.Time
+}
+
+// Open opens the ledger at path, creating the file and its directory if
+// needed, so an unwritable location fails at startup
+func Open(path string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create ledger directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	return &Ledger{path: path, now: time.Now}, nil
+}
+
+// Append writes a record of the given type with data encoded as JSON
+func (l *Ledger) Append(runID, pipeline, recordType string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %w", recordType, err)
+	}
+	line, err := json.Marshal(Record{
+		Time:     l.now().UTC(),
+		RunID:    runID,
+		Pipeline: pipeline,
+		Type:     recordType,
+		Data:     encoded,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %w", recordType, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	return f.Close()
+}
+
+// Records returns every record of the given type in the order written; an
+// empty type returns all records. Lines that can't be parsed, such as one cut
+// short by a crash, are skipped.
+func (l *Ledger) Records(recordType string) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if recordType == "" || record.Type == recordType {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return records, nil
+}
+
+// Posted reports whether pipeline has a post recorded for date
+func (l *Ledger) Posted(pipeline string, date string) (bool, error) {
+	records, err := l.Records(TypePost)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.Pipeline != pipeline {
+			continue
+		}
+		var post Post
+		if err := json.Unmarshal(record.Data, &post); err == nil && post.Date == date {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PostedTitles returns the titles pipeline has posted on date's month and
+// day in any year, most recent first
+func (l *Ledger) PostedTitles(pipeline string, date time.Time) ([]string, error) {
+	records, err := l.Records(TypePost)
+	if err != nil {
+		return nil, err
+	}
+
+	monthDay := date.Format("-01-02")
+	var titles []string
+	seen := make(map[string]bool)
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Pipeline != pipeline {
+			continue
+		}
+		var post Post
+		if err := json.Unmarshal(records[i].Data, &post); err != nil || !strings.HasSuffix(post.Date, monthDay) {
+			continue
+		}
+		for _, title := range post.Titles {
+			if !seen[title] {
+				seen[title] = true
+				titles = append(titles, title)
+			}
+		}
+	}
+	return titles, nil
+}
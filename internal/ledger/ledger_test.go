@@ -0,0 +1,93 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "ledger.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	l.now = func() time.Time { return time.Date(2024, time.July, 20, 9, 0, 0, 0, time.UTC) }
+
+	if err := l.Append("run1", "eng", TypeDraft, map[string]string{"text": "hello"}); err != nil {
+		t.Fatalf("Append() returned error: %v", err)
+	}
+	if err := l.Append("run1", "eng", TypeDecision, map[string]string{"action": "approve"}); err != nil {
+		t.Fatalf("Append() returned error: %v", err)
+	}
+
+	// A torn last line is skipped rather than failing the read
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"time": "2024-07`)
+	f.Close()
+
+	all, err := l.Records("")
+	if err != nil {
+		t.Fatalf("Records() returned error: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("len(Records()) = %d, want 2", len(all))
+	}
+
+	decisions, _ := l.Records(TypeDecision)
+	if len(decisions) != 1 || decisions[0].RunID != "run1" || decisions[0].Pipeline != "eng" {
+		t.Fatalf("Records(decision) = %+v", decisions)
+	}
+	var data map[string]string
+	if err := json.Unmarshal(decisions[0].Data, &data); err != nil || data["action"] != "approve" {
+		t.Errorf("decision data = %s, want action approve", decisions[0].Data)
+	}
+	if !decisions[0].Time.Equal(l.now()) {
+		t.Errorf("Time = %v, want %v", decisions[0].Time, l.now())
+	}
+}
+
+func TestOpenUnwritable(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	os.WriteFile(blocker, nil, 0o644)
+
+	if _, err := Open(filepath.Join(blocker, "ledger.jsonl")); err == nil {
+		t.Error("Open() should fail when the directory can't be created")
+	}
+}
+
+func TestPostedTitles(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+
+	posts := []struct {
+		pipeline string
+		post     Post
+	}{
+		{"eng", Post{Date: "2023-07-20", Titles: []string{"Apollo 11 lands on the Moon"}}},
+		{"eng", Post{Date: "2023-07-21", Titles: []string{"Ernest Hemingway born"}}},
+		{"sales", Post{Date: "2024-07-20", Titles: []string{"Plot against Hitler fails"}}},
+		{"eng", Post{Date: "2024-07-20", Titles: []string{"Natalie Wood born", "Apollo 11 lands on the Moon"}}},
+	}
+	for i, p := range posts {
+		if err := l.Append(fmt.Sprintf("run%d", i), p.pipeline, TypePost, p.post); err != nil {
+			t.Fatalf("Append() returned error: %v", err)
+		}
+	}
+
+	got, err := l.PostedTitles("eng", time.Date(2025, time.July, 20, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PostedTitles() returned error: %v", err)
+	}
+	want := []string{"Natalie Wood born", "Apollo 11 lands on the Moon"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PostedTitles() = %q, want %q", got, want)
+	}
+}
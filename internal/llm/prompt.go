@@ -0,0 +1,254 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// Prompt kinds. The kind decides which variable a legacy %d stands for.
+const (
+	EventPrompt   = "event"
+	HolidayPrompt = "holiday"
+)
+
+// PromptData holds the variables a prompt template can use
+type PromptData struct {
+	Date               time.Time     // Day the post is for
+	Kind               rss.EventKind // Kind of event being selected; empty in holiday prompts
+	MaxEvents          int           // Events to select; 0 in holiday prompts
+	MaxHolidays        int           // Holidays to select; 0 in event prompts
+	Audience           string        // Who reads the post, e.g. "a team of software engineers"
+	Tone               string        // How descriptions should read, e.g. "playful"
+	ExcludedYears      []string      // Years not to pick events from
+	ExcludedCategories []string      // Categories not to pick events from
+	PreviouslyPosted   []string      // Titles posted on this date before
+	Examples           []Example     // Few-shot examples of good picks
+}
+
+// Example is a few-shot example of a well-written pick, in the same shape
+// the model is asked to return
+type Example struct {
+	Year        string `json:"year" yaml:"year"`
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description" yaml:"description"`
+	Category    string `json:"category,omitempty" yaml:"category"`
+}
+
+// Prompt is a parsed prompt template
+type Prompt struct {
+	tmpl *template.Template
+}
+
+// promptFuncs are the functions available to prompt templates besides the
+// text/template builtins
+var promptFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// legacyVariables maps each prompt kind to the variable a legacy %d becomes
+var legacyVariables = map[string]string{
+	EventPrompt:   "{{.MaxEvents}}",
+	HolidayPrompt: "{{.MaxHolidays}}",
+}
+
+// LoadPrompt reads and parses a prompt template file; see ParsePrompt
+func LoadPrompt(kind, path string) (*Prompt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt: %w", err)
+	}
+	return ParsePrompt(kind, path, string(data))
+}
+
+// ParsePrompt parses a prompt template, then executes it against sample data
+// so mistakes like misspelled variables are caught at startup rather than at
+// posting time. A prompt written for the old fmt-style format, with a single
+// %d and no template actions, has the %d replaced by the count to select.
+func ParsePrompt(kind, name, text string) (*Prompt, error) {
+	legacy, ok := legacyVariables[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt kind %q", kind)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("prompt is empty")
+	}
+
+	if !strings.Contains(text, "{{") {
+		switch strings.Count(text, "%d") {
+		case 0:
+		case 1:
+			text = strings.Replace(text, "%d", legacy, 1)
+		default:
+			return nil, fmt.Errorf("prompt uses %%d more than once; use %s instead", legacy)
+		}
+	}
+
+	tmpl, err := template.New(name).Funcs(promptFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	p := &Prompt{tmpl: tmpl}
+	for _, data := range samplePromptData(kind) {
+		if _, err := p.Render(data); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// mustParsePrompt parses a built-in prompt, panicking on error
+func mustParsePrompt(kind, name, text string) *Prompt {
+	p, err := ParsePrompt(kind, name, text)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Render executes the template with data
+func (p *Prompt) Render(data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt: %w", err)
+	}
+
+	text := strings.TrimSpace(buf.String())
+	if text == "" {
+		return "", fmt.Errorf("prompt rendered empty")
+	}
+	return text, nil
+}
+
+// samplePromptData returns a bare and a fully populated set of variables, so
+// validation exercises both sides of every conditional
+func samplePromptData(kind string) []PromptData {
+	bare := PromptData{Date: time.Date(1969, time.July, 20, 9, 0, 0, 0, time.UTC)}
+	if kind == HolidayPrompt {
+		bare.MaxHolidays = 2
+	} else {
+		bare.Kind = rss.KindEvent
+		bare.MaxEvents = 3
+	}
+
+	full := bare
+	full.Audience = "a team of software engineers"
+	full.Tone = "playful"
+	full.ExcludedYears = []string{"1914", "1939"}
+	full.ExcludedCategories = []string{"Politics"}
+	full.PreviouslyPosted = []string{"Apollo 11 lands on the Moon"}
+	full.Examples = []Example{{Year: "1903", Title: "First powered flight", Description: "The Wright brothers fly 120 feet in 12 seconds.", Category: "Science"}}
+
+	return []PromptData{bare, full}
+}
+
+// LoadExamples reads few-shot examples from a YAML or JSON file holding a
+// list of {year, title, description, category} entries
+func LoadExamples(path string) ([]Example, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read examples: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var examples []Example
+	if err := decoder.Decode(&examples); err != nil {
+		return nil, fmt.Errorf("failed to parse examples: %w", err)
+	}
+	for i, example := range examples {
+		if strings.TrimSpace(example.Title) == "" || strings.TrimSpace(example.Description) == "" {
+			return nil, fmt.Errorf("example %d: title and description are required", i+1)
+		}
+	}
+	return examples, nil
+}
+
+// DefaultEventSelectionPrompt is used when no event prompt has been configured
+const DefaultEventSelectionPrompt = `You are analyzing historical events that happened on {{.Date.Format "January 2"}}. Your task is to select the most interesting, rare, or significant events from the list provided.
+
+Criteria for selection:
+- Events that are historically significant or impactful
+- Unusual, rare, or surprising events
+- Events that would be interesting to {{if .Audience}}{{.Audience}}{{else}}a general audience{{end}}
+- Avoid overly common or mundane events
+- Prefer events from different time periods and categories for variety
+{{- if .ExcludedYears}}
+- Don't select events from these years: {{join .ExcludedYears ", "}}
+{{- end}}
+{{- if .ExcludedCategories}}
+- Don't select events in these categories: {{join .ExcludedCategories ", "}}
+{{- end}}
+{{- if .PreviouslyPosted}}
+- Don't select events we've already posted on this date in the past:
+{{- range .PreviouslyPosted}}
+  - {{.}}
+{{- end}}
+{{- end}}
+
+Select exactly {{.MaxEvents}} events from the list and format them for posting to Slack. For each event, provide:
+1. The year and a brief, engaging description (2-3 sentences max)
+2. Why this event is interesting or significant
+{{- if .Tone}}
+
+Write the descriptions in a {{.Tone}} tone.
+{{- end}}
+
+Format your response as JSON with the following structure:
+{
+  "events": [
+    {
+      "year": "YYYY",
+      "title": "Brief event title",
+      "description": "Engaging 2-3 sentence description with context and significance",
+      "category": "Category of event (e.g., Politics, Science, Arts, etc.)"
+    }
+  ]
+}
+{{- if .Examples}}
+
+Here are examples of well-written picks in that format:
+{{- range .Examples}}
+{{json .}}
+{{- end}}
+{{- end}}`
+
+// DefaultHolidaySelectionPrompt is used when no holiday prompt has been configured
+const DefaultHolidaySelectionPrompt = `You are curating the fun and unusual holidays for {{.Date.Format "January 2"}} for a workplace Slack channel{{if .Audience}} read by {{.Audience}}{{end}}. Your task is to pick the funniest, most light-hearted holidays from the list provided.
+
+Criteria for selection:
+- Holidays that are playful, quirky, or food-related
+- Holidays that are appropriate for a work channel
+- Avoid anything political, religious, tragic, or divisive
+- Prefer variety over several holidays on the same theme
+
+Select at most {{.MaxHolidays}} holidays from the list. Use each holiday's title exactly as it appears in the list, and write a short, {{if .Tone}}{{.Tone}}{{else}}friendly{{end}} one-line quip for each (15 words max, no hashtags).
+
+Format your response as JSON with the following structure:
+{
+  "holidays": [
+    {
+      "title": "Exact holiday title from the list",
+      "quip": "One-line quip"
+    }
+  ]
+}`
+
+var (
+	defaultEventPrompt   = mustParsePrompt(EventPrompt, "default event prompt", DefaultEventSelectionPrompt)
+	defaultHolidayPrompt = mustParsePrompt(HolidayPrompt, "default holiday prompt", DefaultHolidaySelectionPrompt)
+)
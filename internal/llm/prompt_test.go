@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePromptLegacy(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"event count", EventPrompt, "Pick %d events.", "Pick 3 events.", false},
+		{"holiday count", HolidayPrompt, "Pick %d holidays.", "Pick 2 holidays.", false},
+		{"no count", EventPrompt, "Pick the best events.", "Pick the best events.", false},
+		{"two counts", EventPrompt, "Pick %d events, at most %d.", "", true},
+		{"template left alone", EventPrompt, "Pick {{.MaxEvents}} events, not 100%d.", "Pick 3 events, not 100%d.", false},
+		{"unknown variable", EventPrompt, "Pick {{.Count}} events.", "", true},
+		{"bad syntax", EventPrompt, "Pick {{.MaxEvents events.", "", true},
+		{"empty", EventPrompt, "  ", "", true},
+		{"unknown kind", "birthday", "Pick %d.", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, err := ParsePrompt(tt.kind, tt.name, tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ParsePrompt() expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePrompt() returned error: %v", err)
+			}
+
+			got, err := prompt.Render(PromptData{MaxEvents: 3, MaxHolidays: 2})
+			if err != nil {
+				t.Fatalf("Render() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultEventPrompt(t *testing.T) {
+	data := PromptData{
+		Date:               time.Date(2024, time.July, 20, 9, 0, 0, 0, time.UTC),
+		MaxEvents:          3,
+		Audience:           "a team of software engineers",
+		Tone:               "playful",
+		ExcludedYears:      []string{"1914", "1939"},
+		ExcludedCategories: []string{"Politics", "War"},
+		PreviouslyPosted:   []string{"Apollo 11 lands on the Moon"},
+		Examples:           []Example{{Year: "1903", Title: "First powered flight", Description: "The Wright brothers fly.", Category: "Science"}},
+	}
+
+	got, err := defaultEventPrompt.Render(data)
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+	for _, want := range []string{
+		"happened on July 20.",
+		"interesting to a team of software engineers",
+		"from these years: 1914, 1939",
+		"in these categories: Politics, War",
+		"  - Apollo 11 lands on the Moon",
+		"Select exactly 3 events",
+		"in a playful tone",
+		`{"year":"1903","title":"First powered flight","description":"The Wright brothers fly.","category":"Science"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, got)
+		}
+	}
+
+	// Unset variables leave no trace
+	bare, _ := defaultEventPrompt.Render(PromptData{Date: data.Date, MaxEvents: 1})
+	for _, unwanted := range []string{"these years", "these categories", "already posted", "tone", "examples"} {
+		if strings.Contains(bare, unwanted) {
+			t.Errorf("bare prompt mentions %q:\n%s", unwanted, bare)
+		}
+	}
+	if !strings.Contains(bare, "a general audience") {
+		t.Error("bare prompt should fall back to a general audience")
+	}
+}
+
+func TestLoadExamples(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	examples, err := LoadExamples(write("examples.yaml", `
+- year: "1903"
+  title: First powered flight
+  description: The Wright brothers fly 120 feet.
+  category: Science
+`))
+	if err != nil {
+		t.Fatalf("LoadExamples() returned error: %v", err)
+	}
+	if len(examples) != 1 || examples[0].Title != "First powered flight" || examples[0].Category != "Science" {
+		t.Errorf("LoadExamples() = %+v", examples)
+	}
+
+	// JSON is valid YAML
+	if _, err := LoadExamples(write("examples.json", `[{"year": "1969", "title": "Moon landing", "description": "People walk on the Moon."}]`)); err != nil {
+		t.Errorf("LoadExamples() returned error for JSON: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"untitled.yaml": `[{"year": "1969", "description": "People walk on the Moon."}]`,
+		"unknown.yaml":  `[{"year": "1969", "title": "Moon landing", "description": "People walk.", "summary": "x"}]`,
+	} {
+		if _, err := LoadExamples(write(name, content)); err == nil {
+			t.Errorf("LoadExamples(%s) expected an error", name)
+		}
+	}
+}
@@ -2,52 +2,140 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/dpeterka/history-slackbot/internal/health"
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
 	"github.com/dpeterka/history-slackbot/internal/rss"
+	"github.com/dpeterka/history-slackbot/internal/usage"
 )
 
 // Selector uses an LLM to select interesting events
 type Selector struct {
-	apiKey      string
-	model       string
-	client      *http.Client
-	maxEvents   int
-	promptTemplate string
+	apiKey        string
+	model         string
+	client        *http.Client
+	maxEvents     int
+	prompt        *Prompt
+	holidayPrompt *Prompt
+	promptCaching bool   // Mark system prompts for Anthropic prompt caching
+	apiURL        string // Messages API endpoint, replaced in tests
+	usage         *usage.Tracker
+	budgetModel   string // Model used once the budget is spent; empty refuses calls
 }
 
 // SelectedEvent represents an event selected by the LLM
 type SelectedEvent struct {
-	Year        string `json:"year"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Category    string `json:"category"`
+	Kind        rss.EventKind `json:"kind,omitempty"`
+	Year        string        `json:"year"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Category    string        `json:"category"`
+	Source      int           `json:"source,omitempty"`    // Number of the candidate in the prompt's list
+	ImageURL    string        `json:"image_url,omitempty"` // Copied from the source event, never from the model
+	Links       []string      `json:"links,omitempty"`     // Copied from the source event: every link of the feeds that carried it
+	Flags       []string      `json:"flags,omitempty"`     // Sensitivity categories set by moderation
+	Separate    bool          `json:"separate,omitempty"`  // Set by moderation to post apart from the holidays
 }
 
+// sourceInstruction asks the model to say which candidate each pick came from,
+// so details like images can be carried over from the source event
+const sourceInstruction = `For each selected event, also include "source": the event's number in the list you are given.`
+
 // SelectionResponse represents the LLM's response
 type SelectionResponse struct {
 	Events []SelectedEvent `json:"events"`
 }
 
-// NewSelector creates a new event selector
-func NewSelector(apiKey, model string, maxEvents int, promptTemplate string) *Selector {
+// SelectedHoliday represents a holiday selected by the LLM
+type SelectedHoliday struct {
+	Title string `json:"title"`
+	Quip  string `json:"quip"`
+	Link  string `json:"link,omitempty"`
+}
+
+// HolidaySelectionResponse represents the LLM's holiday selection response
+type HolidaySelectionResponse struct {
+	Holidays []SelectedHoliday `json:"holidays"`
+}
+
+// NewSelector creates a new event selector. A nil prompt uses
+// DefaultEventSelectionPrompt.
+func NewSelector(apiKey, model string, maxEvents int, prompt *Prompt) *Selector {
+	if prompt == nil {
+		prompt = defaultEventPrompt
+	}
 	return &Selector{
-		apiKey:      apiKey,
-		model:       model,
-		maxEvents:   maxEvents,
-		promptTemplate: promptTemplate,
+		apiKey:        apiKey,
+		model:         model,
+		maxEvents:     maxEvents,
+		prompt:        prompt,
+		holidayPrompt: defaultHolidayPrompt,
+		promptCaching: true,
+		apiURL:        "https://api.anthropic.com/v1/messages",
 		client: &http.Client{
 			Timeout: 60 * time.Second,
 		},
 	}
 }
 
-// SelectEvents uses Claude API to select the most interesting events
-func (s *Selector) SelectEvents(events []rss.HistoricalEvent) ([]SelectedEvent, error) {
+// kindHeadings introduce the candidate list in the prompt for each event kind
+var kindHeadings = map[rss.EventKind]string{
+	rss.KindEvent:      "Here are today's historical events:",
+	rss.KindBirth:      "Here are notable people born on this day:",
+	rss.KindDeath:      "Here are notable people who died on this day:",
+	rss.KindHoliday:    "Here are today's holidays:",
+	rss.KindObservance: "Here are today's observances:",
+}
+
+// SelectEvents uses Claude API to select the most interesting events. data
+// fills in the prompt template; its MaxEvents is set from the selector.
+func (s *Selector) SelectEvents(ctx context.Context, events []rss.HistoricalEvent, data PromptData) ([]SelectedEvent, error) {
+	data.MaxEvents = s.maxEvents
+	return s.selectEvents(ctx, kindHeadings[rss.KindEvent], events, data)
+}
+
+// SelectKind selects up to max events of a single kind, such as births. The
+// selected events are tagged with the kind.
+func (s *Selector) SelectKind(ctx context.Context, kind rss.EventKind, events []rss.HistoricalEvent, max int, data PromptData) ([]SelectedEvent, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+
+	heading, ok := kindHeadings[kind]
+	if !ok {
+		heading = kindHeadings[rss.KindEvent]
+	}
+
+	data.Kind = kind
+	data.MaxEvents = max
+	selected, err := s.selectEvents(ctx, heading, events, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(selected) > max {
+		selected = selected[:max]
+	}
+	for i := range selected {
+		selected[i].Kind = kind
+	}
+
+	return selected, nil
+}
+
+// selectEvents asks Claude to pick data.MaxEvents events from the list
+// introduced by heading
+func (s *Selector) selectEvents(ctx context.Context, heading string, events []rss.HistoricalEvent, data PromptData) ([]SelectedEvent, error) {
 	if len(events) == 0 {
 		return nil, fmt.Errorf("no events to select from")
 	}
@@ -55,12 +143,18 @@ func (s *Selector) SelectEvents(events []rss.HistoricalEvent) ([]SelectedEvent,
 	// Format events for the LLM prompt
 	eventsText := s.formatEventsForPrompt(events)
 
-	// Create the prompt
-	prompt := fmt.Sprintf(s.promptTemplate, s.maxEvents)
-	prompt += "\n\nHere are today's historical events:\n\n" + eventsText
+	// Instructions go in the system prompt, the candidates in the message
+	if data.Date.IsZero() {
+		data.Date = time.Now()
+	}
+	system, err := s.prompt.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	system += "\n\n" + sourceInstruction
 
 	// Call Claude API
-	response, err := s.callClaudeAPI(prompt)
+	response, err := s.callClaudeAPI(ctx, system, heading+"\n\n"+eventsText)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call Claude API: %w", err)
 	}
@@ -71,6 +165,177 @@ func (s *Selector) SelectEvents(events []rss.HistoricalEvent) ([]SelectedEvent,
 		return nil, fmt.Errorf("failed to parse selection: %w", err)
 	}
 
+	attachSources(selected, events)
+
+	return selected, nil
+}
+
+// attachSources copies details the model doesn't return, such as the image
+// URL and links, from each selected event's source. The source is found by
+// the number the model reported, or failing that by a unique year match.
+// Moderation fields are cleared, since only the moderation stage may set
+// them.
+func attachSources(selected []SelectedEvent, events []rss.HistoricalEvent) {
+	byYear := make(map[string][]int)
+	for i, event := range events {
+		byYear[strings.TrimSpace(event.Year)] = append(byYear[strings.TrimSpace(event.Year)], i)
+	}
+
+	for i := range selected {
+		selected[i].Flags, selected[i].Separate = nil, false
+
+		index := selected[i].Source - 1
+		if index < 0 || index >= len(events) {
+			matches := byYear[strings.TrimSpace(selected[i].Year)]
+			if len(matches) != 1 {
+				selected[i].Source, selected[i].ImageURL, selected[i].Links = 0, "", nil
+				continue
+			}
+			index = matches[0]
+		}
+
+		selected[i].Source = index + 1
+		selected[i].ImageURL = events[index].ImageURL
+		selected[i].Links = events[index].Links
+	}
+}
+
+// DefaultSoftenPrompt asks Claude to rewrite a sensitive event in a gentler
+// tone. The event follows the prompt.
+const DefaultSoftenPrompt = `You are editing a "today in history" post for a workplace Slack channel. The event below touches on a sensitive subject. Rewrite it in a calm, respectful and factual tone:
+- Keep the facts and the year
+- No graphic detail, jokes, emoji or opinions
+- A title of at most 10 words and a description of 1-2 sentences
+
+Format your response as JSON with the following structure:
+{
+  "title": "Rewritten title",
+  "description": "Rewritten description"
+}`
+
+// SoftenEvent asks Claude to rewrite an event in a gentler tone. Everything
+// but the title and description is kept.
+func (s *Selector) SoftenEvent(ctx context.Context, event SelectedEvent) (SelectedEvent, error) {
+	message := fmt.Sprintf("Event:\n[%s] %s\n%s", event.Year, event.Title, htmltext.StripLinks(event.Description))
+
+	response, err := s.callClaudeAPI(ctx, DefaultSoftenPrompt, message)
+	if err != nil {
+		return event, fmt.Errorf("failed to call Claude API: %w", err)
+	}
+
+	var rewrite struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(response)), &rewrite); err != nil {
+		return event, fmt.Errorf("failed to unmarshal rewrite: %w (response: %s)", err, response)
+	}
+	if strings.TrimSpace(rewrite.Title) == "" || strings.TrimSpace(rewrite.Description) == "" {
+		return event, fmt.Errorf("rewrite is missing a title or description")
+	}
+
+	event.Title = strings.TrimSpace(rewrite.Title)
+	event.Description = strings.TrimSpace(rewrite.Description)
+	return event, nil
+}
+
+// SetHolidayPrompt overrides the prompt used by SelectHolidays; nil keeps
+// DefaultHolidaySelectionPrompt
+func (s *Selector) SetHolidayPrompt(prompt *Prompt) {
+	if prompt != nil {
+		s.holidayPrompt = prompt
+	}
+}
+
+// SelectHolidays uses Claude API to pick the funniest, most Slack-appropriate
+// holidays. data fills in the prompt template.
+func (s *Selector) SelectHolidays(ctx context.Context, holidays []rss.Holiday, maxHolidays int, data PromptData) ([]SelectedHoliday, error) {
+	if len(holidays) == 0 {
+		return nil, fmt.Errorf("no holidays to select from")
+	}
+	if maxHolidays <= 0 {
+		return nil, nil
+	}
+
+	// Create the prompt
+	data.Kind = ""
+	data.MaxEvents = 0
+	data.MaxHolidays = maxHolidays
+	if data.Date.IsZero() {
+		data.Date = time.Now()
+	}
+	system, err := s.holidayPrompt.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call Claude API
+	response, err := s.callClaudeAPI(ctx, system, kindHeadings[rss.KindHoliday]+"\n\n"+s.formatHolidaysForPrompt(holidays))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Claude API: %w", err)
+	}
+
+	// Parse the response
+	selected, err := s.parseHolidaySelection(response, holidays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse holiday selection: %w", err)
+	}
+
+	if len(selected) > maxHolidays {
+		selected = selected[:maxHolidays]
+	}
+
+	return selected, nil
+}
+
+// formatHolidaysForPrompt formats holidays into a numbered list
+func (s *Selector) formatHolidaysForPrompt(holidays []rss.Holiday) string {
+	var buf bytes.Buffer
+
+	for i, holiday := range holidays {
+		buf.WriteString(fmt.Sprintf("%d. %s\n", i+1, holiday.Title))
+	}
+
+	return buf.String()
+}
+
+// parseHolidaySelection parses the LLM's response into selected holidays.
+// Titles that don't match a holiday from the source list are dropped so the
+// model can't invent holidays, and links are copied over from the source.
+func (s *Selector) parseHolidaySelection(response string, holidays []rss.Holiday) ([]SelectedHoliday, error) {
+	response = extractJSON(response)
+
+	var selection HolidaySelectionResponse
+	if err := json.Unmarshal([]byte(response), &selection); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal selection: %w (response: %s)", err, response)
+	}
+
+	byTitle := make(map[string]rss.Holiday, len(holidays))
+	for _, holiday := range holidays {
+		byTitle[strings.ToLower(strings.TrimSpace(holiday.Title))] = holiday
+	}
+
+	var selected []SelectedHoliday
+	seen := make(map[string]bool)
+	for _, pick := range selection.Holidays {
+		key := strings.ToLower(strings.TrimSpace(pick.Title))
+		source, ok := byTitle[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		selected = append(selected, SelectedHoliday{
+			Title: source.Title,
+			Quip:  strings.TrimSpace(pick.Quip),
+			Link:  source.Link,
+		})
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no known holidays in selection")
+	}
+
 	return selected, nil
 }
 
@@ -86,7 +351,7 @@ func (s *Selector) formatEventsForPrompt(events []rss.HistoricalEvent) string {
 		buf.WriteString(event.Title)
 		if event.Description != "" {
 			buf.WriteString("\n   ")
-			buf.WriteString(event.Description)
+			buf.WriteString(htmltext.StripLinks(event.Description))
 		}
 		if event.Category != "" {
 			buf.WriteString(fmt.Sprintf("\n   Category: %s", event.Category))
@@ -99,11 +364,27 @@ func (s *Selector) formatEventsForPrompt(events []rss.HistoricalEvent) string {
 
 // ClaudeRequest represents the request structure for Claude API
 type ClaudeRequest struct {
-	Model     string    `json:"model"`
-	MaxTokens int       `json:"max_tokens"`
-	Messages  []Message `json:"messages"`
+	Model     string        `json:"model"`
+	MaxTokens int           `json:"max_tokens"`
+	System    []SystemBlock `json:"system,omitempty"`
+	Messages  []Message     `json:"messages"`
 }
 
+// SystemBlock is a text block of the system prompt
+type SystemBlock struct {
+	Type         string        `json:"type"`
+	Text         string        `json:"text"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl marks the end of a prompt prefix for Anthropic to cache
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+// ephemeralCache is the only cache type the API offers
+var ephemeralCache = &CacheControl{Type: "ephemeral"}
+
 // Message represents a message in the Claude API
 type Message struct {
 	Role    string `json:"role"`
@@ -112,12 +393,12 @@ type Message struct {
 
 // ClaudeResponse represents the response from Claude API
 type ClaudeResponse struct {
-	ID      string          `json:"id"`
-	Type    string          `json:"type"`
-	Role    string          `json:"role"`
-	Content []ContentBlock  `json:"content"`
-	Model   string          `json:"model"`
-	Usage   UsageInfo       `json:"usage"`
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+	Model   string         `json:"model"`
+	Usage   UsageInfo      `json:"usage"`
 }
 
 // ContentBlock represents a content block in Claude's response
@@ -126,21 +407,76 @@ type ContentBlock struct {
 	Text string `json:"text"`
 }
 
-// UsageInfo represents token usage information
+// UsageInfo represents token usage information. InputTokens excludes tokens
+// written to or read from the prompt cache.
 type UsageInfo struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// Tokens converts the usage for accounting
+func (u UsageInfo) Tokens() usage.Tokens {
+	return usage.Tokens{
+		Input:      u.InputTokens,
+		Output:     u.OutputTokens,
+		CacheWrite: u.CacheCreationInputTokens,
+		CacheRead:  u.CacheReadInputTokens,
+	}
+}
+
+// maxClaudeAttempts is how many times a retryable Claude API failure is attempted
+const maxClaudeAttempts = 3
+
+// claudeRetryBackoff is the base delay between Claude API attempts
+var claudeRetryBackoff = 2 * time.Second
+
+// SetPromptCaching turns Anthropic prompt caching of the system prompt on or
+// off. It's on by default.
+func (s *Selector) SetPromptCaching(enabled bool) {
+	s.promptCaching = enabled
+}
+
+// SetBudget records the usage of every call with tracker. Once its budget is
+// spent, calls go to budgetModel, or fail with usage.ErrBudgetExceeded if
+// budgetModel is empty. Once its hard limit is spent, calls fail whatever
+// the budget model.
+func (s *Selector) SetBudget(tracker *usage.Tracker, budgetModel string) {
+	s.usage = tracker
+	s.budgetModel = budgetModel
 }
 
-// callClaudeAPI makes a request to the Claude API
-func (s *Selector) callClaudeAPI(prompt string) (string, error) {
+// callClaudeAPI makes a request to the Claude API, retrying transient
+// failures. system holds the instructions and output format, which are
+// cached when prompt caching is on; message holds the data for this call,
+// such as the candidate list.
+func (s *Selector) callClaudeAPI(ctx context.Context, system, message string) (string, error) {
+	logger := logging.Stage(ctx, "llm")
+
+	model := s.model
+	if s.usage != nil {
+		if err := s.usage.Check(ctx); err != nil {
+			if s.budgetModel == "" || errors.Is(err, usage.ErrLimitReached) {
+				return "", err
+			}
+			logger.Warn("using the budget model", "model", s.budgetModel, "reason", err)
+			model = s.budgetModel
+		}
+	}
+
+	block := SystemBlock{Type: "text", Text: system}
+	if s.promptCaching {
+		block.CacheControl = ephemeralCache
+	}
 	request := ClaudeRequest{
-		Model:     s.model,
+		Model:     model,
 		MaxTokens: 2048,
+		System:    []SystemBlock{block},
 		Messages: []Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: message,
 			},
 		},
 	}
@@ -150,9 +486,49 @@ func (s *Selector) callClaudeAPI(prompt string) (string, error) {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewReader(reqBody))
+	start := time.Now()
+	var text string
+	var info UsageInfo
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		text, info, retryable, err = s.doClaudeRequest(ctx, model, reqBody)
+		if err == nil || !retryable || attempt == maxClaudeAttempts {
+			break
+		}
+
+		backoff := time.Duration(attempt) * claudeRetryBackoff
+		logger.Warn("Claude API request failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		metrics.ClaudeRetries.Inc()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+	elapsed := time.Since(start)
+	metrics.ClaudeRequestDuration.Observe(elapsed.Seconds(), metrics.Result(err))
+	health.Components.Record(health.ComponentLLM, err)
+
+	if err == nil {
+		if s.usage != nil {
+			s.usage.Record(ctx, model, info.Tokens())
+		}
+		logger.Info("Claude API request completed", "model", model, "duration", elapsed,
+			"input_tokens", info.InputTokens, "output_tokens", info.OutputTokens,
+			"cache_write_tokens", info.CacheCreationInputTokens, "cache_read_tokens", info.CacheReadInputTokens)
+	}
+
+	return text, err
+}
+
+// doClaudeRequest performs a single Claude API request. The boolean result
+// reports whether a failure is worth retrying (network errors, rate limits,
+// overload and server errors).
+func (s *Selector) doClaudeRequest(ctx context.Context, model string, reqBody []byte) (string, UsageInfo, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.apiURL, bytes.NewReader(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", UsageInfo{}, false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -161,29 +537,35 @@ func (s *Selector) callClaudeAPI(prompt string) (string, error) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return "", UsageInfo{}, ctx.Err() == nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", UsageInfo{}, true, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return "", UsageInfo{}, retryable, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var claudeResp ClaudeResponse
 	if err := json.Unmarshal(body, &claudeResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return "", UsageInfo{}, false, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	metrics.ClaudeTokens.Add(float64(claudeResp.Usage.InputTokens), model, "input")
+	metrics.ClaudeTokens.Add(float64(claudeResp.Usage.OutputTokens), model, "output")
+	metrics.ClaudeTokens.Add(float64(claudeResp.Usage.CacheCreationInputTokens), model, "cache_write")
+	metrics.ClaudeTokens.Add(float64(claudeResp.Usage.CacheReadInputTokens), model, "cache_read")
+
 	if len(claudeResp.Content) == 0 {
-		return "", fmt.Errorf("no content in response")
+		return "", claudeResp.Usage, false, fmt.Errorf("no content in response")
 	}
 
-	return claudeResp.Content[0].Text, nil
+	return claudeResp.Content[0].Text, claudeResp.Usage, false, nil
 }
 
 // parseSelection parses the LLM's response into selected events
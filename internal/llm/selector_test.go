@@ -1,13 +1,19 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dpeterka/history-slackbot/internal/rss"
 )
 
 func TestNewSelector(t *testing.T) {
-	selector := NewSelector("test-key", "test-model", 2, "test-prompt")
+	selector := NewSelector("test-key", "test-model", 2, nil)
 
 	if selector == nil {
 		t.Error("NewSelector() returned nil")
@@ -24,7 +30,7 @@ func TestNewSelector(t *testing.T) {
 }
 
 func TestFormatEventsForPrompt(t *testing.T) {
-	selector := NewSelector("test-key", "test-model", 2, "test-prompt")
+	selector := NewSelector("test-key", "test-model", 2, nil)
 
 	events := []rss.HistoricalEvent{
 		{
@@ -78,13 +84,13 @@ func TestExtractJSON(t *testing.T) {
 			expected: `{"events": []}`,
 		},
 		{
-			name: "JSON in markdown code block with json tag",
-			input: "```json\n{\"events\": []}\n```",
+			name:     "JSON in markdown code block with json tag",
+			input:    "```json\n{\"events\": []}\n```",
 			expected: "\n{\"events\": []}\n",
 		},
 		{
-			name: "JSON in markdown code block without tag",
-			input: "```\n{\"events\": []}\n```",
+			name:     "JSON in markdown code block without tag",
+			input:    "```\n{\"events\": []}\n```",
 			expected: "\n{\"events\": []}\n",
 		},
 		{
@@ -105,7 +111,7 @@ func TestExtractJSON(t *testing.T) {
 }
 
 func TestParseSelection(t *testing.T) {
-	selector := NewSelector("test-key", "test-model", 2, "test-prompt")
+	selector := NewSelector("test-key", "test-model", 2, nil)
 
 	tests := []struct {
 		name        string
@@ -139,8 +145,8 @@ func TestParseSelection(t *testing.T) {
 			},
 		},
 		{
-			name: "Valid JSON in markdown",
-			response: "```json\n{\"events\": [{\"year\": \"1776\", \"title\": \"Independence\", \"description\": \"US declares independence\", \"category\": \"Politics\"}]}\n```",
+			name:        "Valid JSON in markdown",
+			response:    "```json\n{\"events\": [{\"year\": \"1776\", \"title\": \"Independence\", \"description\": \"US declares independence\", \"category\": \"Politics\"}]}\n```",
 			expectError: false,
 			validate: func(t *testing.T, events []SelectedEvent) {
 				if len(events) != 1 {
@@ -180,7 +186,177 @@ func TestParseSelection(t *testing.T) {
 	}
 }
 
+func TestAttachSources(t *testing.T) {
+	events := []rss.HistoricalEvent{
+		{Year: "1969", Title: "Apollo 11 lands on the Moon", ImageURL: "https://example.com/apollo.jpg", Links: []string{"https://a.example/apollo", "https://b.example/apollo"}},
+		{Year: "1776", Title: "Declaration of Independence", ImageURL: "https://example.com/declaration.png"},
+		{Year: "1776", Title: "Another 1776 event"},
+		{Year: "1903", Title: "First powered flight", ImageURL: "https://example.com/flyer.jpg"},
+	}
+
+	selected := []SelectedEvent{
+		{Year: "1776", Title: "Independence", Source: 2},
+		{Year: "1903", Title: "Wright brothers fly"},      // No source: unique year match
+		{Year: "1776", Title: "Ambiguous"},                // No source: two 1776 candidates
+		{Year: "1969", Title: "Moon landing", Source: 99}, // Out of range: falls back to year
+		{Year: "2001", Title: "Made up", Source: -1},      // No match at all
+	}
+
+	attachSources(selected, events)
+
+	want := []struct {
+		source int
+		image  string
+	}{
+		{2, "https://example.com/declaration.png"},
+		{4, "https://example.com/flyer.jpg"},
+		{0, ""},
+		{1, "https://example.com/apollo.jpg"},
+		{0, ""},
+	}
+	for i, w := range want {
+		if selected[i].Source != w.source || selected[i].ImageURL != w.image {
+			t.Errorf("selected[%d] source, image = %d, %q, want %d, %q", i, selected[i].Source, selected[i].ImageURL, w.source, w.image)
+		}
+	}
+	if len(selected[3].Links) != 2 || selected[0].Links != nil {
+		t.Errorf("links = %v and %v, want only the Moon landing's source's", selected[3].Links, selected[0].Links)
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
 		(len(s) > 0 && (s[0:len(substr)] == substr || contains(s[1:], substr))))
 }
+
+func TestParseHolidaySelection(t *testing.T) {
+	selector := NewSelector("test-key", "test-model", 2, nil)
+
+	holidays := []rss.Holiday{
+		{Title: "National Nacho Day", Link: "https://example.com/nacho"},
+		{Title: "Saxophone Day", Link: "https://example.com/sax"},
+	}
+
+	tests := []struct {
+		name        string
+		response    string
+		expectError bool
+		validate    func(t *testing.T, selected []SelectedHoliday)
+	}{
+		{
+			name:     "Valid JSON response",
+			response: `{"holidays": [{"title": "Saxophone Day", "quip": "Blow your own horn."}]}`,
+			validate: func(t *testing.T, selected []SelectedHoliday) {
+				if len(selected) != 1 {
+					t.Fatalf("len(selected) = %d, want 1", len(selected))
+				}
+				if selected[0].Quip != "Blow your own horn." {
+					t.Errorf("Quip = %q, want %q", selected[0].Quip, "Blow your own horn.")
+				}
+				if selected[0].Link != "https://example.com/sax" {
+					t.Errorf("Link = %q, want %q", selected[0].Link, "https://example.com/sax")
+				}
+			},
+		},
+		{
+			name:     "Title matching is case-insensitive and keeps source title",
+			response: "```json\n{\"holidays\": [{\"title\": \"national nacho day\", \"quip\": \"Cheese, please.\"}]}\n```",
+			validate: func(t *testing.T, selected []SelectedHoliday) {
+				if len(selected) != 1 {
+					t.Fatalf("len(selected) = %d, want 1", len(selected))
+				}
+				if selected[0].Title != "National Nacho Day" {
+					t.Errorf("Title = %q, want %q", selected[0].Title, "National Nacho Day")
+				}
+			},
+		},
+		{
+			name:     "Invented and duplicate holidays are dropped",
+			response: `{"holidays": [{"title": "Made Up Day", "quip": "x"}, {"title": "Saxophone Day", "quip": "a"}, {"title": "Saxophone Day", "quip": "b"}]}`,
+			validate: func(t *testing.T, selected []SelectedHoliday) {
+				if len(selected) != 1 {
+					t.Fatalf("len(selected) = %d, want 1", len(selected))
+				}
+				if selected[0].Quip != "a" {
+					t.Errorf("Quip = %q, want %q", selected[0].Quip, "a")
+				}
+			},
+		},
+		{
+			name:        "Only unknown holidays",
+			response:    `{"holidays": [{"title": "Made Up Day", "quip": "x"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "Invalid JSON",
+			response:    "not json",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selector.parseHolidaySelection(tt.response, holidays)
+
+			if tt.expectError {
+				if err == nil {
+					t.Error("parseHolidaySelection() should return error")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("parseHolidaySelection() returned unexpected error: %v", err)
+				}
+				if tt.validate != nil {
+					tt.validate(t, selected)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectKindRequest(t *testing.T) {
+	var got ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(ClaudeResponse{
+			Content: []ContentBlock{{Type: "text", Text: `{"events": [{"year": "1969", "title": "Moon landing", "description": "d", "category": "Science", "source": 1}]}`}},
+			Usage:   UsageInfo{InputTokens: 40, OutputTokens: 20, CacheReadInputTokens: 1500},
+		})
+	}))
+	defer server.Close()
+
+	events := []rss.HistoricalEvent{{Year: "1969", Title: "Apollo 11 Moon Landing", Category: "Science"}}
+	date := time.Date(2024, time.July, 20, 9, 0, 0, 0, time.UTC)
+
+	for _, caching := range []bool{true, false} {
+		got = ClaudeRequest{}
+		selector := NewSelector("test-key", "test-model", 2, nil)
+		selector.apiURL = server.URL
+		selector.SetPromptCaching(caching)
+
+		selected, err := selector.SelectKind(context.Background(), rss.KindEvent, events, 1, PromptData{Date: date})
+		if err != nil {
+			t.Fatalf("SelectKind() returned error: %v", err)
+		}
+		if len(selected) != 1 || selected[0].Title != "Moon landing" {
+			t.Errorf("SelectKind() = %+v", selected)
+		}
+
+		// Instructions go in the system prompt, candidates in the message
+		if len(got.System) != 1 || !strings.Contains(got.System[0].Text, "happened on July 20") || !strings.Contains(got.System[0].Text, `"source"`) {
+			t.Fatalf("system = %+v, want the rendered prompt and source instruction", got.System)
+		}
+		if (got.System[0].CacheControl != nil) != caching {
+			t.Errorf("caching %v: cache_control = %+v", caching, got.System[0].CacheControl)
+		}
+		if len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+			t.Fatalf("messages = %+v, want one user message", got.Messages)
+		}
+		message := got.Messages[0].Content
+		if !strings.Contains(message, "1. [1969] Apollo 11 Moon Landing") || strings.Contains(message, "Criteria") {
+			t.Errorf("message = %q, want only the candidate list", message)
+		}
+	}
+}
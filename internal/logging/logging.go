@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// NewLogger creates a logger writing in the given format ("json" or "text")
+// at the given level ("debug", "info", "warn" or "error")
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want json or text)", format)
+	}
+}
+
+type loggerKey struct{}
+
+type runIDKey struct{}
+
+type scheduledRunKey struct{}
+
+// NewRunID returns a short random identifier for a job run
+func NewRunID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRunID returns a context carrying the run ID and a logger that tags
+// every line with it
+func WithRunID(ctx context.Context, runID string) context.Context {
+	ctx = context.WithValue(ctx, runIDKey{}, runID)
+	return WithLogger(ctx, FromContext(ctx).With("run_id", runID))
+}
+
+// RunID returns the run ID carried by the context, if any
+func RunID(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// WithScheduledRun returns a context carrying the ID of the scheduled run
+// that the run in it is an attempt of. Each retry of a scheduled run gets a
+// run ID of its own, but they all share this one.
+func WithScheduledRun(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, scheduledRunKey{}, id)
+}
+
+// ScheduledRun returns the ID of the scheduled run carried by the context,
+// or its run ID if it has none
+func ScheduledRun(ctx context.Context) string {
+	if id, ok := ctx.Value(scheduledRunKey{}).(string); ok {
+		return id
+	}
+	return RunID(ctx)
+}
+
+// WithLogger returns a context carrying the given logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the context's logger, or the default logger if none is set
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Stage returns the context's logger tagged with a pipeline stage name
+func Stage(ctx context.Context, stage string) *slog.Logger {
+	return FromContext(ctx).With("stage", stage)
+}
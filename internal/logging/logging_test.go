@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		level       string
+		expectError bool
+	}{
+		{name: "JSON", format: "json", level: "info"},
+		{name: "Text", format: "text", level: "debug"},
+		{name: "Default format", format: "", level: "warn"},
+		{name: "Invalid format", format: "xml", level: "info", expectError: true},
+		{name: "Invalid level", format: "json", level: "loud", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLogger(&bytes.Buffer{}, tt.format, tt.level)
+			if tt.expectError && err == nil {
+				t.Error("NewLogger() should return error")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("NewLogger() returned unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunIDCarriedThroughContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("NewLogger() returned error: %v", err)
+	}
+
+	ctx := WithLogger(context.Background(), logger)
+	ctx = WithRunID(ctx, "abc123")
+	Stage(ctx, "rss").Info("fetched feed", "items", 3)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("failed to decode log line %q: %v", buf.String(), err)
+	}
+	if line["run_id"] != "abc123" {
+		t.Errorf("run_id = %v, want abc123", line["run_id"])
+	}
+	if line["stage"] != "rss" {
+		t.Errorf("stage = %v, want rss", line["stage"])
+	}
+	if got := RunID(ctx); got != "abc123" {
+		t.Errorf("RunID() = %q, want abc123", got)
+	}
+	if got := ScheduledRun(ctx); got != "abc123" {
+		t.Errorf("ScheduledRun() = %q, want the run ID without a scheduled run", got)
+	}
+	if got := ScheduledRun(WithRunID(WithScheduledRun(ctx, "sched1"), "def456")); got != "sched1" {
+		t.Errorf("ScheduledRun() = %q, want sched1", got)
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	if FromContext(context.Background()) == nil {
+		t.Error("FromContext() returned nil for empty context")
+	}
+	if RunID(context.Background()) != "" {
+		t.Error("RunID() should be empty for empty context")
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, b := NewRunID(), NewRunID()
+	if len(a) != 12 || strings.Trim(a, "0123456789abcdef") != "" {
+		t.Errorf("NewRunID() = %q, want 12 hex characters", a)
+	}
+	if a == b {
+		t.Error("NewRunID() returned the same ID twice")
+	}
+}
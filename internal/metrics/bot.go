@@ -0,0 +1,77 @@
+package metrics
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// Job metrics
+var (
+	JobRuns = NewCounterVec("history_bot_job_runs_total",
+		"Scheduled job runs by result (success or failure).", "result")
+	JobDuration = NewHistogramVec("history_bot_job_duration_seconds",
+		"Duration of a full job run.", DefaultBuckets)
+	LastRunTimestamp = NewGaugeVec("history_bot_last_run_timestamp_seconds",
+		"Unix time the last job run finished, by result.", "result")
+	NextRunTimestamp = NewGaugeVec("history_bot_next_run_timestamp_seconds",
+		"Unix time of the next scheduled job run.")
+)
+
+// Feed metrics
+var (
+	FeedFetchDuration = NewHistogramVec("history_bot_feed_fetch_duration_seconds",
+		"Latency of feed fetches.", DefaultBuckets, "feed")
+	FeedFetchErrors = NewCounterVec("history_bot_feed_fetch_errors_total",
+		"Failed feed fetches.", "feed")
+	FeedItems = NewGaugeVec("history_bot_feed_items",
+		"Items returned by the last fetch of each feed.", "feed")
+	Items = NewGaugeVec("history_bot_items",
+		"Item counts from the last run of each pipeline at each stage (e.g. events_fetched, events_selected).", "pipeline", "stage")
+)
+
+// Pipeline metrics
+var (
+	PipelineStageDuration = NewHistogramVec("history_bot_pipeline_stage_duration_seconds",
+		"Duration of each pipeline stage, by pipeline, stage and result.", DefaultBuckets, "pipeline", "stage", "result")
+	PregeneratedRuns = NewCounterVec("history_bot_pregenerated_runs_total",
+		"Pre-generated run lookups at post time, by pipeline and result (hit, miss, stale or invalid).", "pipeline", "result")
+)
+
+// Claude metrics
+var (
+	ClaudeRequestDuration = NewHistogramVec("history_bot_claude_request_duration_seconds",
+		"Latency of Claude API calls, including retries.", DefaultBuckets, "result")
+	ClaudeRetries = NewCounterVec("history_bot_claude_retries_total",
+		"Claude API requests that were retried.")
+	ClaudeTokens = NewCounterVec("history_bot_claude_tokens_total",
+		"Tokens used by Claude API calls, by model and direction (input, output, cache_write or cache_read).", "model", "direction")
+	ClaudeCost = NewCounterVec("history_bot_claude_cost_usd_total",
+		"Estimated cost of Claude API calls in US dollars, by model.", "model")
+	ClaudeMonthCost = NewGaugeVec("history_bot_claude_month_cost_usd",
+		"Estimated cost of Claude API calls so far this calendar month, in US dollars.")
+	ClaudeBudgetExceeded = NewCounterVec("history_bot_claude_budget_exceeded_total",
+		"Claude API calls refused or downgraded because a budget (run or month) or the monthly limit was spent.", "budget")
+)
+
+// Slack metrics
+var (
+	SlackPosts = NewCounterVec("history_bot_slack_posts_total",
+		"Slack webhook posts by result (success or failure).", "result")
+)
+
+func init() {
+	Default.Register(
+		JobRuns, JobDuration, LastRunTimestamp, NextRunTimestamp,
+		FeedFetchDuration, FeedFetchErrors, FeedItems, Items,
+		PipelineStageDuration, PregeneratedRuns,
+		ClaudeRequestDuration, ClaudeRetries, ClaudeTokens,
+		ClaudeCost, ClaudeMonthCost, ClaudeBudgetExceeded,
+		SlackPosts,
+	)
+}
+
+// Result returns the outcome label for an error
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_total", "A test counter.", "result")
+	c.Inc("success")
+	c.Inc("success")
+	c.Add(3, "failure")
+	c.Add(-1, "failure")
+
+	if got := c.Value("success"); got != 2 {
+		t.Errorf("Value(success) = %v, want 2", got)
+	}
+	if got := c.Value("failure"); got != 3 {
+		t.Errorf("Value(failure) = %v, want 3", got)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("test_gauge", "A test gauge.")
+	g.Set(5)
+	g.Set(2)
+
+	if got := g.Value(); got != 2 {
+		t.Errorf("Value() = %v, want 2", got)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "A test histogram.", []float64{1, 0.1}, "feed")
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(5, "a")
+
+	if got := h.Count("a"); got != 3 {
+		t.Errorf("Count(a) = %d, want 3", got)
+	}
+
+	var buf bytes.Buffer
+	if err := h.Write(&buf); err != nil {
+		t.Fatalf("Write() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`test_seconds_bucket{feed="a",le="0.1"} 1`,
+		`test_seconds_bucket{feed="a",le="1"} 2`,
+		`test_seconds_bucket{feed="a",le="+Inf"} 3`,
+		`test_seconds_sum{feed="a"} 5.55`,
+		`test_seconds_count{feed="a"} 3`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong number of label values")
+		}
+	}()
+
+	NewCounterVec("test_total", "A test counter.", "result").Inc()
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounterVec("b_total", "Second.", "feed")
+	g := NewGaugeVec("a_gauge", "First.")
+	r.Register(c, g)
+
+	c.Inc(`https://example.com/"feed"`)
+	g.Set(1)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", rec.Header().Get("Content-Type"))
+	}
+	if strings.Index(body, "a_gauge") > strings.Index(body, "b_total") {
+		t.Error("metrics should be sorted by name")
+	}
+	for _, want := range []string{
+		"# HELP a_gauge First.\n# TYPE a_gauge gauge\na_gauge 1\n",
+		"# TYPE b_total counter\n",
+		`b_total{feed="https://example.com/\"feed\""} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Category is a kind of sensitive content
+type Category string
+
+const (
+	Violence Category = "violence"
+	Tragedy  Category = "tragedy"
+	Politics Category = "politics"
+	Religion Category = "religion"
+)
+
+// Categories lists every category
+var Categories = []Category{Violence, Tragedy, Politics, Religion}
+
+// Action is what happens to a selected event flagged with a category
+type Action string
+
+const (
+	Allow    Action = "allow"    // Post as selected
+	Separate Action = "separate" // Post in its own section, away from the holidays
+	Soften   Action = "soften"   // Rewrite in a gentler tone
+	Swap     Action = "swap"     // Replace with an unflagged candidate
+)
+
+// severity orders actions from least to most intrusive
+var severity = map[Action]int{Allow: 0, Separate: 1, Soften: 2, Swap: 3}
+
+// Policy maps categories to actions. Categories not in the policy are allowed.
+type Policy map[Category]Action
+
+// ParsePolicy parses a policy like "violence=swap,tragedy=soften,politics=separate"
+func ParsePolicy(s string) (Policy, error) {
+	policy := make(Policy)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		category, action, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid policy entry %q (want category=action)", part)
+		}
+		if err := policy.Set(strings.TrimSpace(category), strings.TrimSpace(action)); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Set validates and sets the action for a category
+func (p Policy) Set(category, action string) error {
+	c := Category(strings.ToLower(category))
+	if !isCategory(c) {
+		return fmt.Errorf("unknown moderation category %q (want violence, tragedy, politics or religion)", category)
+	}
+	a := Action(strings.ToLower(action))
+	if _, ok := severity[a]; !ok {
+		return fmt.Errorf("unknown moderation action %q (want allow, separate, soften or swap)", action)
+	}
+	p[c] = a
+	return nil
+}
+
+// Merge returns a copy of p with the entries of override applied on top
+func (p Policy) Merge(override Policy) Policy {
+	merged := make(Policy, len(p)+len(override))
+	for c, a := range p {
+		merged[c] = a
+	}
+	for c, a := range override {
+		merged[c] = a
+	}
+	return merged
+}
+
+// ActionFor returns the most intrusive action among the flagged categories
+func (p Policy) ActionFor(categories []Category) Action {
+	action := Allow
+	for _, c := range categories {
+		if a, ok := p[c]; ok && severity[a] > severity[action] {
+			action = a
+		}
+	}
+	return action
+}
+
+// String renders the policy in ParsePolicy's format, in category order
+func (p Policy) String() string {
+	var parts []string
+	for _, c := range Categories {
+		if a, ok := p[c]; ok {
+			parts = append(parts, string(c)+"="+string(a))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func isCategory(c Category) bool {
+	for _, known := range Categories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultKeywords flag each category. Keywords match whole words; a
+// trailing "*" matches any word starting with the stem, so "massacre*" also
+// matches "massacred" and "massacres". Words such as "died" are left out of
+// tragedy, since every entry under "Died on this day" would match them.
+var defaultKeywords = map[Category][]string{
+	Violence: {
+		"massacre*", "murder*", "assassinat*", "kill", "kills", "killed", "killing*", "execut*",
+		"genocide*", "bomb*", "shooting*", "shot dead", "terror*", "attack*", "war", "wars",
+		"battle*", "invasion*", "invade*", "slaughter*", "lynch*", "tortur*", "hostage*", "riot*",
+	},
+	Tragedy: {
+		"disaster*", "catastroph*", "earthquake*", "tsunami*", "famine*", "epidemic*", "pandemic*",
+		"plague*", "crash", "crashes", "crashed", "explosion*", "sinks", "sank", "sinking",
+		"shipwreck*", "derail*", "victims", "casualties", "collapse*",
+		"hurricane*", "flood", "floods", "flooding",
+	},
+	Politics: {
+		"election*", "elected", "president*", "prime minister", "parliament*", "congress*",
+		"senate", "coup", "revolution*", "independence", "referendum*", "impeach*", "dictator*",
+		"regime*", "communis*", "fascis*", "nazi*", "protest*", "civil rights", "segregat*",
+	},
+	Religion: {
+		"church*", "pope", "popes", "papal", "mosque*", "synagogue*", "temple*", "crusade*",
+		"jihad*", "saint", "saints", "bishop*", "archbishop*", "clergy", "religio*", "heres*",
+		"heretic*", "inquisition", "excommunicat*", "islam*", "christian*", "jewish", "hindu*",
+		"buddhis*",
+	},
+}
+
+// Classifier flags text by keyword
+type Classifier struct {
+	patterns map[Category]*regexp.Regexp
+}
+
+// NewClassifier creates a classifier with the built-in keyword lists
+func NewClassifier() *Classifier {
+	return NewKeywordClassifier(defaultKeywords)
+}
+
+// NewKeywordClassifier creates a classifier from keywords per category, in
+// the same whole-word / "stem*" format as the built-in lists
+func NewKeywordClassifier(keywords map[Category][]string) *Classifier {
+	c := &Classifier{patterns: make(map[Category]*regexp.Regexp)}
+	for category, words := range keywords {
+		if len(words) == 0 {
+			continue
+		}
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			word = strings.ToLower(word)
+			if stem, ok := strings.CutSuffix(word, "*"); ok {
+				quoted[i] = regexp.QuoteMeta(stem) + `\w*`
+			} else {
+				quoted[i] = regexp.QuoteMeta(word)
+			}
+		}
+		c.patterns[category] = regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return c
+}
+
+// Classify returns the categories the text is flagged with, in category order
+func (c *Classifier) Classify(texts ...string) []Category {
+	text := strings.ToLower(strings.Join(texts, "\n"))
+
+	var flagged []Category
+	for category, pattern := range c.patterns {
+		if pattern.MatchString(text) {
+			flagged = append(flagged, category)
+		}
+	}
+	sort.Slice(flagged, func(i, j int) bool { return order(flagged[i]) < order(flagged[j]) })
+	return flagged
+}
+
+func order(c Category) int {
+	for i, known := range Categories {
+		if c == known {
+			return i
+		}
+	}
+	return len(Categories)
+}
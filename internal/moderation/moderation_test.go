@@ -0,0 +1,81 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	c := NewClassifier()
+
+	tests := []struct {
+		name string
+		text []string
+		want []Category
+	}{
+		{"harmless", []string{"First Moon landing", "Apollo 11 lands on the Moon."}, nil},
+		{"violence", []string{"Boston Massacre", "British soldiers kill five colonists."}, []Category{Violence}},
+		{"stem matches inflections", []string{"Lincoln assassinated"}, []Category{Violence}},
+		{"several categories", []string{"Pope survives attack"}, []Category{Violence, Religion}},
+		{"tragedy and politics", []string{"Earthquake hits capital as parliament meets"}, []Category{Tragedy, Politics}},
+		{"matches word starts only", []string{"Software release", "A warm welcome"}, nil},
+		{"case-insensitive", []string{"ELECTION DAY"}, []Category{Politics}},
+		{"obituary", []string{"Died: Johnny Cash", "American singer-songwriter, dead at 71; his death ended a 50-year career."}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Classify(tt.text...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Classify(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(" Violence=swap, tragedy=SOFTEN,politics=separate,,")
+	if err != nil {
+		t.Fatalf("ParsePolicy() returned error: %v", err)
+	}
+	if got := policy.String(); got != "violence=swap,tragedy=soften,politics=separate" {
+		t.Errorf("policy = %q", got)
+	}
+
+	for _, bad := range []string{"violence", "gore=swap", "violence=delete"} {
+		if _, err := ParsePolicy(bad); err == nil {
+			t.Errorf("ParsePolicy(%q) should return error", bad)
+		}
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	policy := Policy{Politics: Separate, Tragedy: Soften, Violence: Swap}
+
+	tests := []struct {
+		categories []Category
+		want       Action
+	}{
+		{nil, Allow},
+		{[]Category{Religion}, Allow},
+		{[]Category{Politics}, Separate},
+		{[]Category{Politics, Tragedy}, Soften},
+		{[]Category{Tragedy, Violence, Politics}, Swap},
+	}
+	for _, tt := range tests {
+		if got := policy.ActionFor(tt.categories); got != tt.want {
+			t.Errorf("ActionFor(%v) = %q, want %q", tt.categories, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := Policy{Violence: Swap, Politics: Separate}
+	merged := base.Merge(Policy{Politics: Allow, Religion: Soften})
+
+	if merged.String() != "violence=swap,politics=allow,religion=soften" {
+		t.Errorf("Merge() = %q", merged.String())
+	}
+	if base[Politics] != Separate {
+		t.Error("Merge() modified the base policy")
+	}
+}
@@ -0,0 +1,655 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/cache"
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// Run carries the state of a single pipeline execution from stage to stage
+type Run struct {
+	ID       string    // Correlation ID from the scheduler
+	Pipeline string    // Name of the pipeline (usually the destination)
+	Date     time.Time // Day the run is posting for
+
+	Events   []rss.HistoricalEvent // Candidate events from sources, after filters
+	Selected []llm.SelectedEvent   // Events picked by the selector
+	Holidays []llm.SelectedHoliday // Holidays added by enrichers
+	Message  *slack.SlackMessage   // Rendered message
+
+	Revision  int  // Times the run was regenerated at a reviewer's request
+	Skipped   bool // Set when approval skipped delivery
+	FromCache bool // Content came from a pre-generated run
+	Posted    bool // Set when the day had already been posted, so nothing was done
+}
+
+// Source produces candidate events
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context, run *Run) ([]rss.HistoricalEvent, error)
+}
+
+// Filter narrows or transforms candidate events before selection
+type Filter interface {
+	Name() string
+	Filter(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]rss.HistoricalEvent, error)
+}
+
+// Selector picks the events to post from the candidates
+type Selector interface {
+	Name() string
+	Select(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]llm.SelectedEvent, error)
+}
+
+// Reviewer checks the selected events before anything is added to them, and
+// may replace, rewrite or drop picks. Unlike enrichers, a failed review
+// fails the run.
+type Reviewer interface {
+	Name() string
+	Review(ctx context.Context, run *Run, selected []llm.SelectedEvent) ([]llm.SelectedEvent, error)
+}
+
+// Enricher adds optional content to a run, such as holidays. Enricher
+// failures are logged and don't fail the run.
+type Enricher interface {
+	Name() string
+	Enrich(ctx context.Context, run *Run) error
+}
+
+// Revalidator is implemented by enrichers whose checks should be repeated on
+// a pre-generated run before it's posted, such as ImageChecker
+type Revalidator interface {
+	Revalidate(ctx context.Context, run *Run) error
+}
+
+// Renderer turns the run's content into a message
+type Renderer interface {
+	Name() string
+	Render(ctx context.Context, run *Run) (slack.SlackMessage, error)
+}
+
+// Sink delivers the rendered run to a destination
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, run *Run) error
+}
+
+// Approval actions
+const (
+	Approve    = "approve"    // Deliver the run, with Decision.Message if edited
+	Regenerate = "regenerate" // Select and render again, then ask again
+	Skip       = "skip"       // Don't deliver today
+)
+
+// Decision is the outcome of an approval
+type Decision struct {
+	Action  string
+	Message *slack.SlackMessage // Edited message replacing the rendered one, if any
+}
+
+// Approver asks for sign-off on a rendered run before it's delivered
+type Approver interface {
+	Name() string
+	Approve(ctx context.Context, run *Run) (Decision, error)
+}
+
+// StageError records which stage of a pipeline failed
+type StageError struct {
+	StageName string
+	Err       error
+}
+
+func (e *StageError) Error() string { return fmt.Sprintf("%s: %v", e.StageName, e.Err) }
+func (e *StageError) Unwrap() error { return e.Err }
+
+// Stage returns the name of the failed stage
+func (e *StageError) Stage() string { return e.StageName }
+
+// Pipeline wires stages together: sources → filters → selector → reviewers →
+// enrichers → renderer → approver → sinks. Each destination composes its own
+// pipeline.
+type Pipeline struct {
+	Name      string
+	Sources   []Source
+	Filters   []Filter
+	Selector  Selector
+	Reviewers []Reviewer
+	Enrichers []Enricher
+	Renderer  Renderer
+	Approver  Approver
+	Sinks     []Sink
+
+	// Cache holds pre-generated runs; nil disables pre-generation. A
+	// pre-generated run older than CacheMaxAge (if set) isn't used.
+	Cache       *cache.Store
+	CacheMaxAge time.Duration
+
+	// Ledger, if set, records what each delivered run posted, so a day
+	// already posted isn't posted again after a restart
+	Ledger *ledger.Ledger
+
+	mu         sync.Mutex
+	postedDate string    // Last day delivered to every sink
+	pending    *delivery // Partly delivered run, for a retry to finish
+
+	now func() time.Time
+}
+
+// delivery is an approved run that some sinks failed to deliver. A retry on
+// the same day delivers it to the failed sinks only, without composing or
+// asking for approval again.
+type delivery struct {
+	run       *Run
+	delivered []bool // By index in Sinks
+}
+
+// Execute runs every stage in order and returns the finished run. With a
+// cache, a valid pre-generated run for the day is posted instead of fetching,
+// selecting and rendering live.
+//
+// Execute is safe to retry: a day already posted, by this process or as
+// recorded in the ledger, is skipped, and after a partly failed delivery the
+// next execution for the same day delivers the same message to the failed
+// sinks only.
+func (p *Pipeline) Execute(ctx context.Context) (*Run, error) {
+	run, ctx := p.newRun(ctx, p.clock())
+
+	if p.posted(ctx, run) {
+		run.Posted = true
+		logging.Stage(ctx, "ledger").Info("already posted today, skipping", "date", cache.DateKey(run.Date))
+		return run, nil
+	}
+
+	delivered := make([]bool, len(p.Sinks))
+	if pending := p.takePending(run.Date); pending != nil {
+		pending.run.ID = run.ID
+		run, delivered = pending.run, pending.delivered
+		logging.Stage(ctx, "pipeline").Info("resuming delivery to the sinks that failed")
+	} else {
+		if !p.fromCache(ctx, run) {
+			if err := p.gather(ctx, run); err != nil {
+				return run, err
+			}
+			if err := p.compose(ctx, run); err != nil {
+				return run, err
+			}
+		}
+
+		if p.Approver != nil {
+			approved, err := p.approve(ctx, run)
+			if err != nil || !approved {
+				return run, err
+			}
+		}
+	}
+
+	// Deliver to every sink even if one fails
+	var errs []error
+	for i, sink := range p.Sinks {
+		if delivered[i] {
+			continue
+		}
+		err := p.stage(ctx, sink.Name(), func() error {
+			return sink.Deliver(ctx, run)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered[i] = true
+	}
+	if len(errs) == 0 {
+		p.recordPost(ctx, run)
+	} else {
+		p.setPending(&delivery{run: run, delivered: delivered})
+	}
+
+	return run, errors.Join(errs...)
+}
+
+// posted reports whether the run's day was already delivered
+func (p *Pipeline) posted(ctx context.Context, run *Run) bool {
+	p.mu.Lock()
+	postedDate := p.postedDate
+	p.mu.Unlock()
+	if postedDate == cache.DateKey(run.Date) {
+		return true
+	}
+	if p.Ledger == nil {
+		return false
+	}
+	posted, err := p.Ledger.Posted(p.Name, cache.DateKey(run.Date))
+	if err != nil {
+		logging.Stage(ctx, "ledger").Warn("failed to check for an earlier post", "error", err)
+		return false
+	}
+	return posted
+}
+
+// takePending returns and clears the partly delivered run for date, if any
+func (p *Pipeline) takePending(date time.Time) *delivery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := p.pending
+	p.pending = nil
+	if pending == nil || cache.DateKey(pending.run.Date) != cache.DateKey(date) {
+		return nil
+	}
+	return pending
+}
+
+func (p *Pipeline) setPending(pending *delivery) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = pending
+}
+
+// recordPost notes that the run's day was posted and the delivered titles in
+// the ledger, so later runs on the same date can avoid them
+func (p *Pipeline) recordPost(ctx context.Context, run *Run) {
+	if len(p.Sinks) == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.postedDate = cache.DateKey(run.Date)
+	p.mu.Unlock()
+	if p.Ledger == nil {
+		return
+	}
+
+	post := ledger.Post{Date: cache.DateKey(run.Date)}
+	for _, event := range run.Selected {
+		post.Titles = append(post.Titles, event.Title)
+	}
+	if err := p.Ledger.Append(run.ID, p.Name, ledger.TypePost, post); err != nil {
+		logging.Stage(ctx, "ledger").Warn("failed to record post", "error", err)
+	}
+}
+
+// Pregenerate composes the run for a future post on date and stores it in
+// the cache, without approval or delivery
+func (p *Pipeline) Pregenerate(ctx context.Context, date time.Time) (*Run, error) {
+	run, ctx := p.newRun(ctx, date)
+
+	if p.Cache == nil {
+		return run, fmt.Errorf("no cache configured")
+	}
+	if err := p.gather(ctx, run); err != nil {
+		return run, err
+	}
+	if err := p.compose(ctx, run); err != nil {
+		return run, err
+	}
+
+	err := p.stage(ctx, "cache", func() error {
+		return p.Cache.Put(cache.Entry{
+			Pipeline:    p.Name,
+			Date:        cache.DateKey(date),
+			RunID:       run.ID,
+			GeneratedAt: p.clock(),
+			Selected:    run.Selected,
+			Holidays:    run.Holidays,
+			Message:     run.Message,
+		})
+	})
+	if err != nil {
+		return run, err
+	}
+	logging.Stage(ctx, "cache").Info("stored pre-generated run", "date", cache.DateKey(date), "events", len(run.Selected), "holidays", len(run.Holidays))
+
+	// Keep a week of entries for troubleshooting
+	if err := p.Cache.Prune(date.AddDate(0, 0, -7)); err != nil {
+		logging.Stage(ctx, "cache").Warn("failed to prune cache", "error", err)
+	}
+	return run, nil
+}
+
+func (p *Pipeline) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// newRun starts a run for date, returning a context that tags log lines
+// with the pipeline
+func (p *Pipeline) newRun(ctx context.Context, date time.Time) (*Run, context.Context) {
+	run := &Run{
+		ID:       logging.RunID(ctx),
+		Pipeline: p.Name,
+		Date:     date,
+	}
+	return run, logging.WithLogger(ctx, logging.FromContext(ctx).With("pipeline", p.Name))
+}
+
+// gather fetches candidate events from the sources and filters them
+func (p *Pipeline) gather(ctx context.Context, run *Run) error {
+	if err := p.fetch(ctx, run); err != nil {
+		return err
+	}
+	p.count("events_fetched", len(run.Events))
+
+	for _, filter := range p.Filters {
+		err := p.stage(ctx, filter.Name(), func() (err error) {
+			run.Events, err = filter.Filter(ctx, run, run.Events)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	p.count("events_filtered", len(run.Events))
+	return nil
+}
+
+// fromCache fills the run from a pre-generated run for its date, if there is
+// a valid one. The run is re-validated first: revalidating enrichers repeat
+// their checks, and the content is rendered again with the current renderer
+// so configuration changes since pre-generation take effect. It reports
+// whether the run came from the cache; if not, the run is left empty for
+// live generation.
+func (p *Pipeline) fromCache(ctx context.Context, run *Run) bool {
+	if p.Cache == nil {
+		return false
+	}
+	logger := logging.Stage(ctx, "cache")
+
+	result := p.loadCached(ctx, run)
+	metrics.PregeneratedRuns.Inc(p.Name, result)
+	if result != "hit" {
+		run.Selected, run.Holidays, run.Message, run.FromCache = nil, nil, nil, false
+		logger.Info("generating live", "reason", result)
+		return false
+	}
+
+	logger.Info("posting pre-generated run", "events", len(run.Selected), "holidays", len(run.Holidays))
+	return true
+}
+
+// loadCached does the work of fromCache, returning the lookup result
+func (p *Pipeline) loadCached(ctx context.Context, run *Run) string {
+	logger := logging.Stage(ctx, "cache")
+
+	entry, ok, err := p.Cache.Get(p.Name, run.Date)
+	if err != nil {
+		logger.Warn("failed to read pre-generated run", "error", err)
+		return "invalid"
+	}
+	if !ok {
+		return "miss"
+	}
+	if age := p.clock().Sub(entry.GeneratedAt); p.CacheMaxAge > 0 && age > p.CacheMaxAge {
+		logger.Warn("pre-generated run is too old", "age", age, "max_age", p.CacheMaxAge)
+		return "stale"
+	}
+	if entry.Pipeline != p.Name || entry.Date != cache.DateKey(run.Date) || (len(entry.Selected) == 0 && len(entry.Holidays) == 0) {
+		logger.Warn("pre-generated run doesn't match this run", "pipeline", entry.Pipeline, "date", entry.Date)
+		return "invalid"
+	}
+
+	run.Selected, run.Holidays, run.FromCache = entry.Selected, entry.Holidays, true
+	p.count("events_selected", len(run.Selected))
+
+	for _, enricher := range p.Enrichers {
+		revalidator, ok := enricher.(Revalidator)
+		if !ok {
+			continue
+		}
+		err := p.stage(ctx, enricher.Name(), func() error {
+			return revalidator.Revalidate(ctx, run)
+		})
+		if err != nil {
+			logger.Warn("pre-generated run failed re-validation", "stage", enricher.Name(), "error", err)
+			return "invalid"
+		}
+	}
+
+	if p.Renderer != nil {
+		var message slack.SlackMessage
+		err := p.stage(ctx, p.Renderer.Name(), func() (err error) {
+			message, err = p.Renderer.Render(ctx, run)
+			return err
+		})
+		if err != nil {
+			logger.Warn("pre-generated run no longer renders", "error", err)
+			return "invalid"
+		}
+		if entry.Message == nil || !reflect.DeepEqual(*entry.Message, message) {
+			logger.Info("rendering changed since pre-generation, using the current rendering")
+		}
+		run.Message = &message
+	}
+
+	return "hit"
+}
+
+// compose selects, reviews, enriches and renders the run's content
+func (p *Pipeline) compose(ctx context.Context, run *Run) error {
+	if p.Selector != nil {
+		err := p.stage(ctx, p.Selector.Name(), func() (err error) {
+			run.Selected, err = p.Selector.Select(ctx, run, run.Events)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		p.count("events_selected", len(run.Selected))
+	}
+
+	for _, reviewer := range p.Reviewers {
+		err := p.stage(ctx, reviewer.Name(), func() (err error) {
+			run.Selected, err = reviewer.Review(ctx, run, run.Selected)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(p.Reviewers) > 0 {
+		p.count("events_reviewed", len(run.Selected))
+	}
+
+	for _, enricher := range p.Enrichers {
+		err := p.stage(ctx, enricher.Name(), func() error {
+			return enricher.Enrich(ctx, run)
+		})
+		if err != nil {
+			logging.Stage(ctx, enricher.Name()).Warn("enricher failed, continuing without it", "error", err)
+		}
+	}
+
+	if p.Renderer != nil {
+		err := p.stage(ctx, p.Renderer.Name(), func() error {
+			message, err := p.Renderer.Render(ctx, run)
+			if err != nil {
+				return err
+			}
+			run.Message = &message
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// approve asks the approver about the run until it's approved or skipped,
+// composing it again each time a regeneration is requested. It reports
+// whether the run should be delivered.
+func (p *Pipeline) approve(ctx context.Context, run *Run) (bool, error) {
+	for {
+		var decision Decision
+		err := p.stage(ctx, p.Approver.Name(), func() (err error) {
+			decision, err = p.Approver.Approve(ctx, run)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+
+		logger := logging.Stage(ctx, p.Approver.Name())
+		switch decision.Action {
+		case Approve:
+			if decision.Message != nil {
+				run.Message = decision.Message
+			}
+			logger.Info("run approved", "revision", run.Revision, "edited", decision.Message != nil)
+			return true, nil
+		case Skip:
+			run.Skipped = true
+			logger.Info("run skipped", "revision", run.Revision)
+			return false, nil
+		case Regenerate:
+			logger.Info("regenerating run", "revision", run.Revision)
+			// A pre-generated run has no candidates yet
+			if run.FromCache {
+				run.FromCache = false
+				if err := p.gather(ctx, run); err != nil {
+					return false, err
+				}
+			}
+			run.Events = excludeSelected(run.Events, run.Selected)
+			run.Selected, run.Holidays, run.Message = nil, nil, nil
+			run.Revision++
+			if err := p.compose(ctx, run); err != nil {
+				return false, err
+			}
+		default:
+			return false, &StageError{StageName: p.Approver.Name(), Err: fmt.Errorf("unknown approval action %q", decision.Action)}
+		}
+	}
+}
+
+// excludeSelected drops the candidates a rejected selection came from, so a
+// regeneration picks something else. Candidates are matched by kind and year,
+// since the model rewrites titles.
+func excludeSelected(events []rss.HistoricalEvent, selected []llm.SelectedEvent) []rss.HistoricalEvent {
+	rejected := make(map[string]bool)
+	for _, event := range selected {
+		kind := event.Kind
+		if kind == "" {
+			kind = rss.KindEvent
+		}
+		rejected[string(kind)+"|"+strings.TrimSpace(event.Year)] = true
+	}
+
+	var kept []rss.HistoricalEvent
+	for _, event := range events {
+		if !rejected[string(rss.KindOf(event))+"|"+strings.TrimSpace(event.Year)] {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// fetch collects events from every source. It only fails when no source
+// returned any events.
+func (p *Pipeline) fetch(ctx context.Context, run *Run) error {
+	var failed []string
+	var errs []error
+
+	for _, source := range p.Sources {
+		var sourceErr error
+		p.stage(ctx, source.Name(), func() error {
+			var events []rss.HistoricalEvent
+			events, sourceErr = source.Fetch(ctx, run)
+			run.Events = append(run.Events, events...)
+			return sourceErr
+		})
+		if sourceErr != nil {
+			failed = append(failed, source.Name())
+			errs = append(errs, sourceErr)
+			logging.Stage(ctx, source.Name()).Warn("source failed", "error", sourceErr)
+		}
+	}
+
+	if len(run.Events) > 0 {
+		return nil
+	}
+	if len(errs) == 0 {
+		return &StageError{StageName: "sources", Err: fmt.Errorf("no events from any source")}
+	}
+	return &StageError{StageName: strings.Join(failed, ","), Err: errors.Join(errs...)}
+}
+
+// stage runs fn as the named stage, timing it and wrapping its error
+func (p *Pipeline) stage(ctx context.Context, name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start)
+
+	metrics.PipelineStageDuration.Observe(elapsed.Seconds(), p.Name, name, metrics.Result(err))
+	logging.Stage(ctx, name).Debug("stage finished", "duration", elapsed, "ok", err == nil)
+
+	if err != nil {
+		var stageErr *StageError
+		if errors.As(err, &stageErr) {
+			return err
+		}
+		return &StageError{StageName: name, Err: err}
+	}
+	return nil
+}
+
+// count publishes the number of items left after a stage
+func (p *Pipeline) count(stage string, n int) {
+	metrics.Items.Set(float64(n), p.Name, stage)
+}
+
+// Job returns a function that executes every pipeline, for use as a
+// scheduler job. Pipelines run concurrently, so one waiting for approval
+// doesn't hold up the others, and every pipeline runs even if another fails.
+func Job(pipelines ...*Pipeline) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		errs := make([]error, len(pipelines))
+		var wg sync.WaitGroup
+		for i, p := range pipelines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = p.Execute(ctx)
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+}
+
+// PregenerateJob returns a function that pre-generates every pipeline's run
+// for the date returned by target, for use with scheduler.RunDaily. Like Job,
+// pipelines run concurrently and all of them run even if one fails.
+func PregenerateJob(target func() (time.Time, error), pipelines ...*Pipeline) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		date, err := target()
+		if err != nil {
+			return err
+		}
+
+		errs := make([]error, len(pipelines))
+		var wg sync.WaitGroup
+		for i, p := range pipelines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = p.Pregenerate(ctx, date)
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,588 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/cache"
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+type fakeSource struct {
+	name    string
+	events  []rss.HistoricalEvent
+	err     error
+	fetches int
+}
+
+func (s *fakeSource) Name() string { return s.name }
+func (s *fakeSource) Fetch(ctx context.Context, run *Run) ([]rss.HistoricalEvent, error) {
+	s.fetches++
+	return s.events, s.err
+}
+
+type dropCategory struct{ category string }
+
+func (f *dropCategory) Name() string { return "drop" }
+func (f *dropCategory) Filter(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]rss.HistoricalEvent, error) {
+	var kept []rss.HistoricalEvent
+	for _, event := range events {
+		if event.Category != f.category {
+			kept = append(kept, event)
+		}
+	}
+	return kept, nil
+}
+
+type firstSelector struct{ err error }
+
+func (s *firstSelector) Name() string { return "llm" }
+func (s *firstSelector) Select(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]llm.SelectedEvent, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []llm.SelectedEvent{{Year: events[0].Year, Title: events[0].Title}}, nil
+}
+
+type holidayEnricher struct{ err error }
+
+func (e *holidayEnricher) Name() string { return "holidays" }
+func (e *holidayEnricher) Enrich(ctx context.Context, run *Run) error {
+	if e.err != nil {
+		return e.err
+	}
+	run.Holidays = []llm.SelectedHoliday{{Title: "Nacho Day"}}
+	return nil
+}
+
+type textRenderer struct{}
+
+func (r *textRenderer) Name() string { return "render" }
+func (r *textRenderer) Render(ctx context.Context, run *Run) (slack.SlackMessage, error) {
+	var parts []string
+	for _, event := range run.Selected {
+		parts = append(parts, event.Title)
+	}
+	for _, holiday := range run.Holidays {
+		parts = append(parts, holiday.Title)
+	}
+	return slack.SlackMessage{Text: strings.Join(parts, "|")}, nil
+}
+
+type recordingSink struct {
+	name      string
+	err       error
+	delivered []string
+}
+
+func (s *recordingSink) Name() string { return s.name }
+func (s *recordingSink) Deliver(ctx context.Context, run *Run) error {
+	s.delivered = append(s.delivered, run.Message.Text)
+	return s.err
+}
+
+func testEvents() []rss.HistoricalEvent {
+	return []rss.HistoricalEvent{
+		{Year: "1815", Title: "Battle of Waterloo", Category: "War"},
+		{Year: "1969", Title: "Apollo 11 lands", Category: "Science"},
+	}
+}
+
+func TestPipelineExecute(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:      "test",
+		Sources:   []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Filters:   []Filter{&dropCategory{category: "War"}},
+		Selector:  &firstSelector{},
+		Enrichers: []Enricher{&holidayEnricher{}},
+		Renderer:  &textRenderer{},
+		Sinks:     []Sink{sink},
+	}
+
+	run, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+	if len(run.Events) != 1 {
+		t.Errorf("len(run.Events) = %d, want 1 after filtering", len(run.Events))
+	}
+	if len(sink.delivered) != 1 || sink.delivered[0] != "Apollo 11 lands|Nacho Day" {
+		t.Errorf("delivered = %q, want [\"Apollo 11 lands|Nacho Day\"]", sink.delivered)
+	}
+	if run.Pipeline != "test" {
+		t.Errorf("run.Pipeline = %q, want %q", run.Pipeline, "test")
+	}
+}
+
+func TestPipelineSourceFailures(t *testing.T) {
+	p := &Pipeline{
+		Name: "test",
+		Sources: []Source{
+			&fakeSource{name: "feeds", err: errors.New("status 403")},
+			&fakeSource{name: "wikipedia", events: testEvents()},
+		},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+	}
+
+	// One working source is enough
+	if _, err := p.Execute(context.Background()); err != nil {
+		t.Errorf("Execute() returned error with one working source: %v", err)
+	}
+
+	p.Sources = []Source{&fakeSource{name: "feeds", err: errors.New("status 403")}}
+	_, err := p.Execute(context.Background())
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) {
+		t.Fatalf("Execute() error = %v, want a StageError", err)
+	}
+	if stageErr.Stage() != "feeds" {
+		t.Errorf("Stage() = %q, want %q", stageErr.Stage(), "feeds")
+	}
+}
+
+func TestPipelineSelectorFailureStopsRun(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{err: errors.New("status 529")},
+		Renderer: &textRenderer{},
+		Sinks:    []Sink{sink},
+	}
+
+	_, err := p.Execute(context.Background())
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage() != "llm" {
+		t.Errorf("Execute() error = %v, want an llm StageError", err)
+	}
+	if len(sink.delivered) != 0 {
+		t.Error("sink should not be called when selection fails")
+	}
+}
+
+type failingReviewer struct{}
+
+func (r *failingReviewer) Name() string { return "moderation" }
+func (r *failingReviewer) Review(ctx context.Context, run *Run, selected []llm.SelectedEvent) ([]llm.SelectedEvent, error) {
+	return nil, errors.New("classifier unavailable")
+}
+
+func TestPipelineReviewerFailureStopsRun(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:      "test",
+		Sources:   []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector:  &firstSelector{},
+		Reviewers: []Reviewer{&failingReviewer{}},
+		Renderer:  &textRenderer{},
+		Sinks:     []Sink{sink},
+	}
+
+	_, err := p.Execute(context.Background())
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage() != "moderation" {
+		t.Errorf("Execute() error = %v, want a moderation StageError", err)
+	}
+	if len(sink.delivered) != 0 {
+		t.Error("sink should not be called when review fails")
+	}
+}
+
+// scriptedApprover returns its decisions in order, recording each draft
+type scriptedApprover struct {
+	decisions []Decision
+	drafts    []string
+}
+
+func (a *scriptedApprover) Name() string { return "approval" }
+func (a *scriptedApprover) Approve(ctx context.Context, run *Run) (Decision, error) {
+	a.drafts = append(a.drafts, run.Message.Text)
+	decision := a.decisions[0]
+	a.decisions = a.decisions[1:]
+	return decision, nil
+}
+
+func TestPipelineApprovalRegeneratesThenDelivers(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	approver := &scriptedApprover{decisions: []Decision{
+		{Action: Regenerate},
+		{Action: Approve, Message: &slack.SlackMessage{Text: "Edited"}},
+	}}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Approver: approver,
+		Sinks:    []Sink{sink},
+	}
+
+	run, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	// The regenerated draft can't reuse the rejected pick
+	if len(approver.drafts) != 2 || approver.drafts[0] != "Battle of Waterloo" || approver.drafts[1] != "Apollo 11 lands" {
+		t.Errorf("drafts = %v, want Waterloo then Apollo", approver.drafts)
+	}
+	if run.Revision != 1 {
+		t.Errorf("Revision = %d, want 1", run.Revision)
+	}
+	if len(sink.delivered) != 1 || sink.delivered[0] != "Edited" {
+		t.Errorf("delivered = %v, want the edited message", sink.delivered)
+	}
+}
+
+func TestPipelineApprovalSkip(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Approver: &scriptedApprover{decisions: []Decision{{Action: Skip}}},
+		Sinks:    []Sink{sink},
+	}
+
+	run, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+	if !run.Skipped || len(sink.delivered) != 0 {
+		t.Errorf("Skipped = %v, delivered = %v; want a skipped run with nothing delivered", run.Skipped, sink.delivered)
+	}
+}
+
+func TestPipelineRecordsPostsInLedger(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("ledger.Open() returned error: %v", err)
+	}
+	failing := &recordingSink{name: "teams", err: errors.New("status 500")}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Sinks:    []Sink{&recordingSink{name: "slack"}},
+		Ledger:   l,
+		now:      func() time.Time { return time.Date(2024, time.June, 18, 9, 0, 0, 0, time.UTC) },
+	}
+	if _, err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	// A failed delivery isn't recorded as posted
+	p.Sinks = []Sink{failing}
+	p.now = func() time.Time { return time.Date(2024, time.June, 19, 9, 0, 0, 0, time.UTC) }
+	p.Execute(context.Background())
+
+	titles, err := l.PostedTitles("test", time.Date(2025, time.June, 18, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PostedTitles() returned error: %v", err)
+	}
+	if len(titles) != 1 || titles[0] != "Battle of Waterloo" {
+		t.Errorf("PostedTitles() = %q, want [\"Battle of Waterloo\"]", titles)
+	}
+}
+
+func TestPipelineSkipsPostedDay(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("ledger.Open() returned error: %v", err)
+	}
+	now := func() time.Time { return time.Date(2024, time.June, 18, 9, 0, 0, 0, time.UTC) }
+	newPipeline := func(sink Sink) *Pipeline {
+		return &Pipeline{
+			Name:     "test",
+			Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+			Selector: &firstSelector{},
+			Renderer: &textRenderer{},
+			Sinks:    []Sink{sink},
+			Ledger:   l,
+			now:      now,
+		}
+	}
+	if _, err := newPipeline(&recordingSink{name: "slack"}).Execute(context.Background()); err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	// A restarted process doesn't post the same day again
+	sink := &recordingSink{name: "slack"}
+	run, err := newPipeline(sink).Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+	if !run.Posted || len(sink.delivered) != 0 {
+		t.Errorf("Posted = %v, delivered = %v; want the posted day skipped", run.Posted, sink.delivered)
+	}
+}
+
+// flakySink fails its first failures deliveries
+type flakySink struct {
+	recordingSink
+	failures int
+}
+
+func (s *flakySink) Deliver(ctx context.Context, run *Run) error {
+	s.delivered = append(s.delivered, run.Message.Text)
+	if len(s.delivered) <= s.failures {
+		return errors.New("status 500")
+	}
+	return nil
+}
+
+func TestJobRetryDeliversOnlyToFailedDestinations(t *testing.T) {
+	slackSink := &recordingSink{name: "slack"}
+	teamsSink := &flakySink{recordingSink: recordingSink{name: "teams"}, failures: 1}
+	slackApprover := &scriptedApprover{decisions: []Decision{{Action: Approve}}}
+	teamsApprover := &scriptedApprover{decisions: []Decision{{Action: Approve}}}
+	teamsSource := &fakeSource{name: "feeds", events: testEvents()}
+	newPipeline := func(name string, source *fakeSource, approver Approver, sink Sink) *Pipeline {
+		return &Pipeline{
+			Name:     name,
+			Sources:  []Source{source},
+			Selector: &firstSelector{},
+			Renderer: &textRenderer{},
+			Approver: approver,
+			Sinks:    []Sink{sink},
+		}
+	}
+	job := Job(
+		newPipeline("slack", &fakeSource{name: "feeds", events: testEvents()}, slackApprover, slackSink),
+		newPipeline("teams", teamsSource, teamsApprover, teamsSink),
+	)
+
+	if err := job(context.Background()); err == nil {
+		t.Fatal("first attempt should fail with the teams delivery")
+	}
+	if err := job(context.Background()); err != nil {
+		t.Fatalf("retry returned error: %v", err)
+	}
+
+	if len(slackSink.delivered) != 1 {
+		t.Errorf("slack delivered %d times, want 1", len(slackSink.delivered))
+	}
+	if len(teamsSink.delivered) != 2 || teamsSink.delivered[0] != teamsSink.delivered[1] {
+		t.Errorf("teams delivered %q, want the same message twice", teamsSink.delivered)
+	}
+	if len(slackApprover.drafts) != 1 || len(teamsApprover.drafts) != 1 {
+		t.Errorf("approvals asked = %d and %d, want 1 each", len(slackApprover.drafts), len(teamsApprover.drafts))
+	}
+	if teamsSource.fetches != 1 {
+		t.Errorf("teams fetched %d times, want 1: a retry shouldn't compose again", teamsSource.fetches)
+	}
+
+	// Once delivered everywhere, the day isn't posted again
+	if err := job(context.Background()); err != nil {
+		t.Fatalf("third run returned error: %v", err)
+	}
+	if len(slackSink.delivered) != 1 || len(teamsSink.delivered) != 2 {
+		t.Errorf("deliveries after a third run = %d and %d, want no more", len(slackSink.delivered), len(teamsSink.delivered))
+	}
+}
+
+func TestPipelineRetryDeliversOnlyToFailedSinks(t *testing.T) {
+	working := &recordingSink{name: "slack"}
+	flaky := &flakySink{recordingSink: recordingSink{name: "teams"}, failures: 1}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Sinks:    []Sink{working, flaky},
+	}
+
+	if _, err := p.Execute(context.Background()); err == nil {
+		t.Fatal("Execute() should return the teams error")
+	}
+	if _, err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("retry returned error: %v", err)
+	}
+	if len(working.delivered) != 1 || len(flaky.delivered) != 2 {
+		t.Errorf("deliveries = %d and %d, want 1 to slack and 2 to teams", len(working.delivered), len(flaky.delivered))
+	}
+}
+
+func TestPipelineEnricherFailureIsNotFatal(t *testing.T) {
+	sink := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:      "test",
+		Sources:   []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector:  &firstSelector{},
+		Enrichers: []Enricher{&holidayEnricher{err: errors.New("holiday feed down")}},
+		Renderer:  &textRenderer{},
+		Sinks:     []Sink{sink},
+	}
+
+	if _, err := p.Execute(context.Background()); err != nil {
+		t.Errorf("Execute() returned error: %v", err)
+	}
+	if len(sink.delivered) != 1 || sink.delivered[0] != "Battle of Waterloo" {
+		t.Errorf("delivered = %q, want [\"Battle of Waterloo\"]", sink.delivered)
+	}
+}
+
+func TestPipelineDeliversToEverySink(t *testing.T) {
+	failing := &recordingSink{name: "teams", err: errors.New("status 500")}
+	working := &recordingSink{name: "slack"}
+	p := &Pipeline{
+		Name:     "test",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Sinks:    []Sink{failing, working},
+	}
+
+	_, err := p.Execute(context.Background())
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage() != "teams" {
+		t.Errorf("Execute() error = %v, want a teams StageError", err)
+	}
+	if len(working.delivered) != 1 {
+		t.Error("later sinks should still be called after a sink fails")
+	}
+}
+
+func TestJobRunsEveryPipeline(t *testing.T) {
+	first := &recordingSink{name: "slack"}
+	second := &recordingSink{name: "slack"}
+	failing := &Pipeline{
+		Name:    "broken",
+		Sources: []Source{&fakeSource{name: "feeds", err: errors.New("down")}},
+		Sinks:   []Sink{first},
+	}
+	working := &Pipeline{
+		Name:     "working",
+		Sources:  []Source{&fakeSource{name: "feeds", events: testEvents()}},
+		Selector: &firstSelector{},
+		Renderer: &textRenderer{},
+		Sinks:    []Sink{second},
+	}
+
+	if err := Job(failing, working)(context.Background()); err == nil {
+		t.Error("Job() should return the failing pipeline's error")
+	}
+	if len(second.delivered) != 1 {
+		t.Error("second pipeline should run even though the first failed")
+	}
+}
+
+// revalidatingEnricher counts re-validations of pre-generated runs
+type revalidatingEnricher struct {
+	revalidations int
+	err           error
+}
+
+func (e *revalidatingEnricher) Name() string                               { return "images" }
+func (e *revalidatingEnricher) Enrich(ctx context.Context, run *Run) error { return nil }
+func (e *revalidatingEnricher) Revalidate(ctx context.Context, run *Run) error {
+	e.revalidations++
+	return e.err
+}
+
+func cachedPipeline(t *testing.T, source *fakeSource, enricher *revalidatingEnricher, sink *recordingSink) *Pipeline {
+	store, err := cache.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.July, 20, 6, 0, 0, 0, time.UTC)
+	return &Pipeline{
+		Name:        "test",
+		Sources:     []Source{source},
+		Selector:    &firstSelector{},
+		Enrichers:   []Enricher{enricher},
+		Renderer:    &textRenderer{},
+		Sinks:       []Sink{sink},
+		Cache:       store,
+		CacheMaxAge: 12 * time.Hour,
+		now:         func() time.Time { return now },
+	}
+}
+
+func TestPipelinePostsPregeneratedRun(t *testing.T) {
+	source := &fakeSource{name: "feeds", events: testEvents()}
+	enricher := &revalidatingEnricher{}
+	sink := &recordingSink{name: "slack"}
+	p := cachedPipeline(t, source, enricher, sink)
+
+	if _, err := p.Pregenerate(context.Background(), p.now()); err != nil {
+		t.Fatalf("Pregenerate() returned error: %v", err)
+	}
+	if len(sink.delivered) != 0 {
+		t.Error("Pregenerate() should not deliver")
+	}
+
+	// Three hours later the feed is down, but the cached run is posted
+	posting := p.now().Add(3 * time.Hour)
+	p.now = func() time.Time { return posting }
+	source.err, source.events = errors.New("down"), nil
+
+	run, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+	if !run.FromCache || source.fetches != 1 {
+		t.Errorf("FromCache = %v, fetches = %d; want the cached run without fetching again", run.FromCache, source.fetches)
+	}
+	if enricher.revalidations != 1 {
+		t.Errorf("revalidations = %d, want 1", enricher.revalidations)
+	}
+	if len(sink.delivered) != 1 || sink.delivered[0] != "Battle of Waterloo" {
+		t.Errorf("delivered = %v, want the pre-generated message", sink.delivered)
+	}
+}
+
+func TestPipelineFallsBackToLiveGeneration(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Pipeline, enricher *revalidatingEnricher)
+	}{
+		{"no pre-generated run", func(p *Pipeline, e *revalidatingEnricher) {}},
+		{"stale", func(p *Pipeline, e *revalidatingEnricher) {
+			p.Pregenerate(context.Background(), p.now())
+			later := p.now().Add(13 * time.Hour)
+			p.now = func() time.Time { return later }
+		}},
+		{"fails re-validation", func(p *Pipeline, e *revalidatingEnricher) {
+			p.Pregenerate(context.Background(), p.now())
+			e.err = errors.New("image gone")
+		}},
+		{"other day", func(p *Pipeline, e *revalidatingEnricher) {
+			p.Pregenerate(context.Background(), p.now().AddDate(0, 0, 1))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &fakeSource{name: "feeds", events: testEvents()}
+			enricher := &revalidatingEnricher{}
+			sink := &recordingSink{name: "slack"}
+			p := cachedPipeline(t, source, enricher, sink)
+			tt.setup(p, enricher)
+			fetchesBefore := source.fetches
+
+			run, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute() returned error: %v", err)
+			}
+			if run.FromCache || source.fetches != fetchesBefore+1 || len(sink.delivered) != 1 {
+				t.Errorf("FromCache = %v, fetches = %d, delivered = %v; want a live run", run.FromCache, source.fetches-fetchesBefore, sink.delivered)
+			}
+		})
+	}
+}
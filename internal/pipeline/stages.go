@@ -0,0 +1,608 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dpeterka/history-slackbot/internal/approval"
+	"github.com/dpeterka/history-slackbot/internal/dataset"
+	"github.com/dpeterka/history-slackbot/internal/dedup"
+	"github.com/dpeterka/history-slackbot/internal/health"
+	"github.com/dpeterka/history-slackbot/internal/images"
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
+	"github.com/dpeterka/history-slackbot/internal/moderation"
+	"github.com/dpeterka/history-slackbot/internal/prefilter"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+	"github.com/dpeterka/history-slackbot/internal/sink"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+	"github.com/dpeterka/history-slackbot/internal/usage"
+	"github.com/dpeterka/history-slackbot/internal/wikipedia"
+)
+
+// FeedSource fetches events from RSS feeds
+type FeedSource struct {
+	Parser *rss.Parser
+	URLs   []string
+}
+
+func (s *FeedSource) Name() string { return "feeds" }
+
+func (s *FeedSource) Fetch(ctx context.Context, run *Run) ([]rss.HistoricalEvent, error) {
+	return s.Parser.FetchMultipleFeeds(ctx, s.URLs)
+}
+
+// WikipediaSource fetches the run date's entries from the Wikipedia
+// "onthisday" feed
+type WikipediaSource struct {
+	Client *wikipedia.Client
+	Types  []string // Entry types to include, e.g. "selected" and "events"
+}
+
+func (s *WikipediaSource) Name() string { return "wikipedia" }
+
+func (s *WikipediaSource) Fetch(ctx context.Context, run *Run) ([]rss.HistoricalEvent, error) {
+	feed, err := s.Client.FetchFeed(ctx, run.Date)
+	if err != nil {
+		return nil, err
+	}
+	return feed.ToEvents(s.Types), nil
+}
+
+// DatasetSource returns the run date's entries from a local curated dataset
+type DatasetSource struct {
+	Dataset *dataset.Dataset
+}
+
+func (s *DatasetSource) Name() string { return "dataset" }
+
+func (s *DatasetSource) Fetch(ctx context.Context, run *Run) ([]rss.HistoricalEvent, error) {
+	return s.Dataset.Events(run.Date), nil
+}
+
+// Dedup merges candidates that describe the same moment, as when several
+// feeds carry it, so Claude can't pick it twice. The merged event keeps every
+// source's links and the richest description.
+type Dedup struct {
+	Options dedup.Options
+}
+
+func (f *Dedup) Name() string { return "dedup" }
+
+func (f *Dedup) Filter(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]rss.HistoricalEvent, error) {
+	out, merged := dedup.Merge(events, f.Options)
+	logging.Stage(ctx, f.Name()).Info("merged duplicate candidates", "in", len(events), "out", len(out), "merged", merged)
+	return out, nil
+}
+
+// Prefilter trims the candidates before they're sent to Claude, keeping the
+// prompt small: blocked categories are dropped, descriptions truncated and
+// each kind sampled down to a token budget
+type Prefilter struct {
+	Options prefilter.Options
+}
+
+func (f *Prefilter) Name() string { return "prefilter" }
+
+func (f *Prefilter) Filter(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]rss.HistoricalEvent, error) {
+	out, stats := prefilter.Apply(events, f.Options)
+	logging.Stage(ctx, f.Name()).Info("prefiltered candidates", "in", len(events), "out", len(out),
+		"blocked", stats.Blocked, "truncated", stats.Truncated, "sampled_out", stats.Sampled)
+	return out, nil
+}
+
+// ClaudeSelector picks events with the LLM selector. With Budgets set, each
+// kind of event (events, births, deaths...) is selected separately, up to its
+// own budget; kinds without a budget are dropped. Failing to select events
+// fails the stage, while other kinds are left out if they fail. Without
+// Budgets every candidate is selected from in one go.
+//
+// Prompt holds the destination's prompt variables, such as the audience;
+// the run's date is filled in, and with History set so are the titles
+// already posted on this date. Once the Claude budget is spent, Fallback (if
+// set) selects instead.
+type ClaudeSelector struct {
+	Selector *llm.Selector
+	Budgets  map[rss.EventKind]int
+	Prompt   llm.PromptData
+	History  *ledger.Ledger
+	Fallback Selector
+}
+
+func (s *ClaudeSelector) Name() string { return "llm" }
+
+func (s *ClaudeSelector) Select(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]llm.SelectedEvent, error) {
+	selected, err := s.selectWithClaude(ctx, run, events)
+	if errors.Is(err, usage.ErrBudgetExceeded) && s.Fallback != nil {
+		logging.Stage(ctx, s.Name()).Warn("Claude budget spent, using the fallback selector", "fallback", s.Fallback.Name(), "reason", err)
+		return s.Fallback.Select(ctx, run, events)
+	}
+	return selected, err
+}
+
+func (s *ClaudeSelector) selectWithClaude(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]llm.SelectedEvent, error) {
+	logger := logging.Stage(ctx, s.Name())
+
+	data := s.Prompt
+	data.Date = run.Date
+	if s.History != nil {
+		posted, err := s.History.PostedTitles(run.Pipeline, run.Date)
+		if err != nil {
+			logger.Warn("failed to read previously posted titles", "error", err)
+		}
+		data.PreviouslyPosted = posted
+	}
+
+	if s.Budgets == nil {
+		return s.Selector.SelectEvents(ctx, events, data)
+	}
+
+	byKind := groupByKind(events)
+
+	var selected []llm.SelectedEvent
+	var errs []error
+	eventsFailed := false
+	for _, kind := range rss.Kinds {
+		candidates := byKind[kind]
+		budget := s.Budgets[kind]
+		if len(candidates) == 0 {
+			continue
+		}
+		if budget <= 0 {
+			logger.Debug("no budget for event kind, dropping candidates", "kind", kind, "candidates", len(candidates))
+			continue
+		}
+
+		picked, err := s.Selector.SelectKind(ctx, kind, candidates, budget, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", kind, err))
+			eventsFailed = eventsFailed || kind == rss.KindEvent
+			continue
+		}
+		metrics.Items.Set(float64(len(picked)), run.Pipeline, string(kind)+"_selected")
+		selected = append(selected, picked...)
+	}
+
+	// Events are the post's main content, so failing to select them fails the
+	// stage, for the fallback or a retry to take over. Another failed kind
+	// only fails it if nothing else was selected.
+	if eventsFailed || len(selected) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, fmt.Errorf("no events to select from")
+	}
+	for _, err := range errs {
+		logger.Warn("failed to select event kind, continuing without it", "error", err)
+	}
+
+	return selected, nil
+}
+
+// FallbackSelector picks events without the LLM, for when Claude can't be
+// used. Each kind is picked up to its budget, as with ClaudeSelector; the
+// picks are spread evenly over the candidates so they don't all come from the
+// top of one feed.
+type FallbackSelector struct {
+	Budgets map[rss.EventKind]int
+}
+
+func (s *FallbackSelector) Name() string { return "fallback" }
+
+func (s *FallbackSelector) Select(ctx context.Context, run *Run, events []rss.HistoricalEvent) ([]llm.SelectedEvent, error) {
+	byKind := groupByKind(events)
+
+	var selected []llm.SelectedEvent
+	for _, kind := range rss.Kinds {
+		candidates := byKind[kind]
+		budget := min(s.Budgets[kind], len(candidates))
+		for i := 0; i < budget; i++ {
+			candidate := candidates[i*len(candidates)/budget]
+			selected = append(selected, llm.SelectedEvent{
+				Kind:        kind,
+				Year:        candidate.Year,
+				Title:       candidate.Title,
+				Description: prefilter.Truncate(candidate.Description, maxSourceDescription),
+				Category:    candidate.Category,
+				ImageURL:    candidate.ImageURL,
+				Links:       candidate.Links,
+			})
+		}
+		if budget > 0 {
+			metrics.Items.Set(float64(budget), run.Pipeline, string(kind)+"_selected")
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no events to select from")
+	}
+	return selected, nil
+}
+
+// groupByKind splits events by kind, keeping their order
+func groupByKind(events []rss.HistoricalEvent) map[rss.EventKind][]rss.HistoricalEvent {
+	byKind := make(map[rss.EventKind][]rss.HistoricalEvent)
+	for _, event := range events {
+		kind := rss.KindOf(event)
+		byKind[kind] = append(byKind[kind], event)
+	}
+	return byKind
+}
+
+// Softener rewrites an event in a gentler tone; *llm.Selector implements it
+type Softener interface {
+	SoftenEvent(ctx context.Context, event llm.SelectedEvent) (llm.SelectedEvent, error)
+}
+
+// Moderator classifies each selected event by sensitivity and applies the
+// destination's policy: flagged events are swapped for an unflagged
+// candidate of the same kind, softened, or separated from the holidays.
+// A swap with no clean candidate drops the event, and a failed rewrite
+// falls back to separating it, so flagged events never post unreviewed.
+type Moderator struct {
+	Classifier *moderation.Classifier
+	Policy     moderation.Policy
+	Softener   Softener
+}
+
+func (m *Moderator) Name() string { return "moderation" }
+
+func (m *Moderator) Review(ctx context.Context, run *Run, selected []llm.SelectedEvent) ([]llm.SelectedEvent, error) {
+	logger := logging.Stage(ctx, m.Name())
+
+	// Years already in the post, so a swap doesn't repeat one
+	used := make(map[string]bool)
+	for _, event := range selected {
+		used[strings.TrimSpace(event.Year)] = true
+	}
+
+	var reviewed []llm.SelectedEvent
+	flagged := 0
+	for _, event := range selected {
+		categories := m.Classifier.Classify(event.Title, event.Description, event.Category)
+		if len(categories) == 0 {
+			reviewed = append(reviewed, event)
+			continue
+		}
+		flagged++
+		event.Flags = categoryNames(categories)
+
+		action := m.Policy.ActionFor(categories)
+		logger.Info("flagged selected event", "title", event.Title, "categories", event.Flags, "action", action)
+
+		switch action {
+		case moderation.Swap:
+			kind := event.Kind
+			if kind == "" {
+				kind = rss.KindEvent
+			}
+			replacement, ok := m.replacement(run.Events, kind, used)
+			if !ok {
+				logger.Warn("no clean candidate to swap in, dropping event", "title", event.Title)
+				continue
+			}
+			used[strings.TrimSpace(replacement.Year)] = true
+			logger.Info("swapped flagged event", "title", event.Title, "replacement", replacement.Title)
+			reviewed = append(reviewed, replacement)
+		case moderation.Soften:
+			softened, err := m.soften(ctx, event)
+			if err != nil {
+				logger.Warn("failed to soften event, separating it instead", "title", event.Title, "error", err)
+				event.Separate = true
+				reviewed = append(reviewed, event)
+				continue
+			}
+			reviewed = append(reviewed, softened)
+		case moderation.Separate:
+			event.Separate = true
+			reviewed = append(reviewed, event)
+		default:
+			reviewed = append(reviewed, event)
+		}
+	}
+
+	metrics.Items.Set(float64(flagged), run.Pipeline, "events_flagged")
+	return reviewed, nil
+}
+
+// soften rewrites an event with the softener, if there is one
+func (m *Moderator) soften(ctx context.Context, event llm.SelectedEvent) (llm.SelectedEvent, error) {
+	if m.Softener == nil {
+		return event, fmt.Errorf("no softener configured")
+	}
+	return m.Softener.SoftenEvent(ctx, event)
+}
+
+// replacement finds the first candidate of the given kind that the policy
+// allows as-is and whose year isn't already in the post
+func (m *Moderator) replacement(candidates []rss.HistoricalEvent, kind rss.EventKind, used map[string]bool) (llm.SelectedEvent, bool) {
+	for _, candidate := range candidates {
+		if rss.KindOf(candidate) != kind || used[strings.TrimSpace(candidate.Year)] {
+			continue
+		}
+		categories := m.Classifier.Classify(candidate.Title, candidate.Description, candidate.Category)
+		if m.Policy.ActionFor(categories) != moderation.Allow {
+			continue
+		}
+		return llm.SelectedEvent{
+			Kind:        kind,
+			Year:        candidate.Year,
+			Title:       candidate.Title,
+			Description: prefilter.Truncate(candidate.Description, maxSourceDescription),
+			Category:    candidate.Category,
+			ImageURL:    candidate.ImageURL,
+			Links:       candidate.Links,
+			Flags:       categoryNames(categories),
+		}, true
+	}
+	return llm.SelectedEvent{}, false
+}
+
+// maxSourceDescription caps the description of an event taken straight from
+// the source rather than written by the model, such as a swapped-in candidate
+const maxSourceDescription = 300
+
+func categoryNames(categories []moderation.Category) []string {
+	if len(categories) == 0 {
+		return nil
+	}
+	names := make([]string, len(categories))
+	for i, c := range categories {
+		names[i] = string(c)
+	}
+	return names
+}
+
+// HolidayEnricher adds fun holidays to the run, from the holiday feed and,
+// if set, Wikipedia's holidays and observances. Serious or political holidays
+// are filtered out by keyword, then Claude picks the best of the rest; if
+// that call fails the first holidays in feed order are used.
+type HolidayEnricher struct {
+	Parser      *rss.Parser
+	URL         string
+	Wikipedia   *wikipedia.Client
+	Selector    *llm.Selector
+	MaxHolidays int
+	Prompt      llm.PromptData // Prompt variables; the run's date is filled in
+}
+
+func (e *HolidayEnricher) Name() string { return "holidays" }
+
+func (e *HolidayEnricher) Enrich(ctx context.Context, run *Run) error {
+	if (e.URL == "" && e.Wikipedia == nil) || e.MaxHolidays <= 0 {
+		return nil
+	}
+	logger := logging.Stage(ctx, e.Name())
+
+	holidayData, err := e.fetch(ctx, run)
+	if err != nil {
+		return err
+	}
+	metrics.Items.Set(float64(len(holidayData)), run.Pipeline, "holidays_fetched")
+
+	// Filter for fun holidays (skip serious/political ones)
+	funHolidays := FilterFunHolidays(holidayData)
+	if len(funHolidays) == 0 {
+		return nil
+	}
+
+	// Let Claude pick the best ones, falling back to feed order
+	data := e.Prompt
+	data.Date = run.Date
+	holidays, err := e.Selector.SelectHolidays(ctx, funHolidays, e.MaxHolidays, data)
+	if err != nil {
+		logger.Warn("failed to select holidays with Claude, using feed order", "error", err)
+		holidays = firstHolidays(funHolidays, e.MaxHolidays)
+	}
+
+	run.Holidays = holidays
+	metrics.Items.Set(float64(len(holidays)), run.Pipeline, "holidays_selected")
+	logger.Info("selected holidays", "fetched", len(holidayData), "fun", len(funHolidays), "selected", len(holidays))
+
+	return nil
+}
+
+// fetch collects holidays from the feed and Wikipedia. It only fails when no
+// source returned any holidays and one failed.
+func (e *HolidayEnricher) fetch(ctx context.Context, run *Run) ([]rss.Holiday, error) {
+	var holidays []rss.Holiday
+	var errs []error
+
+	if e.URL != "" {
+		feed, err := e.Parser.FetchHolidays(ctx, e.URL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch holidays: %w", err))
+		}
+		holidays = append(holidays, feed...)
+	}
+	if e.Wikipedia != nil {
+		feed, err := e.Wikipedia.FetchFeed(ctx, run.Date)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch Wikipedia holidays: %w", err))
+		} else {
+			holidays = append(holidays, feed.ToHolidays()...)
+		}
+	}
+
+	if len(holidays) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		logging.Stage(ctx, e.Name()).Warn("holiday source failed", "error", err)
+	}
+	return holidays, nil
+}
+
+// seriousHolidayKeywords indicate serious/political/religious holidays to skip
+var seriousHolidayKeywords = []string{
+	"International", "World", "National Awareness", "Day for",
+	"Memorial", "Remembrance", "Victims", "Prevention",
+	"Human Rights", "Peace", "Conflict", "War", "Violence",
+	"Exploitation", "Poverty", "Hunger", "Disease",
+	"Awareness Week", "Awareness Month", "Solidarity",
+	"Against", "United Nations", "Commemoration",
+}
+
+// FilterFunHolidays filters out serious/political holidays and keeps only fun ones
+func FilterFunHolidays(holidays []rss.Holiday) []rss.Holiday {
+	var funHolidays []rss.Holiday
+	for _, holiday := range holidays {
+		isFun := true
+		title := strings.ToLower(holiday.Title)
+
+		// Check if title contains any serious keywords (case-insensitive)
+		for _, keyword := range seriousHolidayKeywords {
+			if strings.Contains(title, strings.ToLower(keyword)) {
+				isFun = false
+				break
+			}
+		}
+
+		if isFun {
+			funHolidays = append(funHolidays, holiday)
+		}
+	}
+
+	return funHolidays
+}
+
+// firstHolidays returns up to max holidays in feed order, without quips
+func firstHolidays(holidays []rss.Holiday, max int) []llm.SelectedHoliday {
+	if max > len(holidays) {
+		max = len(holidays)
+	}
+
+	selected := make([]llm.SelectedHoliday, 0, max)
+	for _, holiday := range holidays[:max] {
+		selected = append(selected, llm.SelectedHoliday{
+			Title: holiday.Title,
+			Link:  holiday.Link,
+		})
+	}
+
+	return selected
+}
+
+// ImageChecker drops image URLs from selected events unless they respond
+// with an image, so broken thumbnails never reach a post
+type ImageChecker struct {
+	Checker *images.Checker
+}
+
+func (e *ImageChecker) Name() string { return "images" }
+
+// Revalidate checks a pre-generated run's images again, since they may have
+// gone away since
+func (e *ImageChecker) Revalidate(ctx context.Context, run *Run) error {
+	return e.Enrich(ctx, run)
+}
+
+func (e *ImageChecker) Enrich(ctx context.Context, run *Run) error {
+	logger := logging.Stage(ctx, e.Name())
+
+	for i := range run.Selected {
+		event := &run.Selected[i]
+		if event.ImageURL == "" {
+			continue
+		}
+		if err := e.Checker.Check(ctx, event.ImageURL); err != nil {
+			logger.Warn("dropping image", "title", event.Title, "url", event.ImageURL, "error", err)
+			event.ImageURL = ""
+		}
+	}
+
+	return nil
+}
+
+// SlackRenderer renders the run with a Slack renderer, such as the Block Kit
+// renderer or a user-defined template
+type SlackRenderer struct {
+	Renderer slack.Renderer
+}
+
+func (r *SlackRenderer) Name() string { return "render" }
+
+func (r *SlackRenderer) Render(ctx context.Context, run *Run) (slack.SlackMessage, error) {
+	content := slack.NewContent(run.Date, run.Selected, run.Holidays)
+	if content.Empty() {
+		return slack.SlackMessage{}, fmt.Errorf("no events or holidays to post")
+	}
+	return r.Renderer.Render(content)
+}
+
+// ApprovalGate posts each rendered run as a draft to a review channel and
+// waits for a reviewer to approve, regenerate, edit or skip it. Runs without a
+// Slack message, such as those for Teams or email, are previewed with the
+// default Block Kit layout and can't be edited.
+type ApprovalGate struct {
+	Service          *approval.Service
+	MaxRegenerations int
+}
+
+func (g *ApprovalGate) Name() string { return "approval" }
+
+func (g *ApprovalGate) Approve(ctx context.Context, run *Run) (Decision, error) {
+	draft := approval.Draft{
+		RunID:         run.ID,
+		Pipeline:      run.Pipeline,
+		Date:          run.Date,
+		Revision:      run.Revision,
+		Editable:      run.Message != nil,
+		CanRegenerate: run.Revision < g.MaxRegenerations,
+	}
+	if run.Message != nil {
+		draft.Message = *run.Message
+	} else {
+		renderer, _ := slack.NewBlockKitRenderer(slack.DefaultRenderOptions())
+		preview, err := renderer.Render(slack.NewContent(run.Date, run.Selected, run.Holidays))
+		if err != nil {
+			return Decision{}, err
+		}
+		draft.Message = preview
+	}
+
+	decision, err := g.Service.Request(ctx, draft)
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{Action: decision.Action, Message: decision.Message}, nil
+}
+
+// SlackSink posts the rendered message to a Slack webhook
+type SlackSink struct {
+	Poster *slack.Poster
+}
+
+func (s *SlackSink) Name() string { return "slack" }
+
+func (s *SlackSink) Deliver(ctx context.Context, run *Run) error {
+	if run.Message == nil {
+		return fmt.Errorf("no rendered message to post")
+	}
+	err := s.Poster.PostMessage(ctx, *run.Message)
+	health.Components.Record("sink:"+run.Pipeline, err)
+	return err
+}
+
+// DigestSink delivers the run's selected events and holidays through a sink
+// that does its own rendering, such as Teams, Discord, Mattermost or email
+type DigestSink struct {
+	Sink sink.Sink
+}
+
+func (s *DigestSink) Name() string { return s.Sink.Name() }
+
+func (s *DigestSink) Deliver(ctx context.Context, run *Run) error {
+	digest := sink.NewDigest(run.Date, run.Selected, run.Holidays)
+	if digest.Empty() {
+		return fmt.Errorf("no events or holidays to post")
+	}
+
+	err := s.Sink.Send(ctx, digest)
+	health.Components.Record("sink:"+run.Pipeline, err)
+	return err
+}
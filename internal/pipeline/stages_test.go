@@ -0,0 +1,325 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/images"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/moderation"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+	"github.com/dpeterka/history-slackbot/internal/usage"
+	"github.com/dpeterka/history-slackbot/internal/wikipedia"
+)
+
+func TestFilterFunHolidays(t *testing.T) {
+	holidays := []rss.Holiday{
+		{Title: "National Nacho Day"},
+		{Title: "International Day for the Elimination of Violence"},
+		{Title: "World Kindness Day"},
+		{Title: "memorial day"},
+		{Title: "Saxophone Day"},
+	}
+
+	got := FilterFunHolidays(holidays)
+	if len(got) != 2 {
+		t.Fatalf("len(FilterFunHolidays()) = %d, want 2: %v", len(got), got)
+	}
+	if got[0].Title != "National Nacho Day" || got[1].Title != "Saxophone Day" {
+		t.Errorf("FilterFunHolidays() = %v, want Nacho and Saxophone days", got)
+	}
+}
+
+func TestFirstHolidays(t *testing.T) {
+	holidays := []rss.Holiday{
+		{Title: "A", Link: "https://example.com/a"},
+		{Title: "B"},
+		{Title: "C"},
+	}
+
+	got := firstHolidays(holidays, 2)
+	if len(got) != 2 || got[0].Title != "A" || got[1].Title != "B" {
+		t.Errorf("firstHolidays() = %v, want A and B", got)
+	}
+	if got[0].Link != "https://example.com/a" || got[0].Quip != "" {
+		t.Errorf("firstHolidays()[0] = %+v, want link copied and no quip", got[0])
+	}
+	if got := firstHolidays(holidays, 10); len(got) != 3 {
+		t.Errorf("len(firstHolidays(10)) = %d, want 3", len(got))
+	}
+}
+
+func TestGroupByKind(t *testing.T) {
+	events := []rss.HistoricalEvent{
+		{Title: "Moon landing"},
+		{Title: "Natalie Wood", Kind: rss.KindBirth},
+		{Title: "Colombia independence", Kind: rss.KindEvent},
+		{Title: "Bruce Lee", Kind: rss.KindDeath},
+		{Title: "Another birth", Kind: rss.KindBirth},
+	}
+
+	got := groupByKind(events)
+	if len(got[rss.KindEvent]) != 2 {
+		t.Errorf("events = %v, want 2 including the one without a kind", got[rss.KindEvent])
+	}
+	if births := got[rss.KindBirth]; len(births) != 2 || births[0].Title != "Natalie Wood" {
+		t.Errorf("births = %v, want Natalie Wood then Another birth", births)
+	}
+	if len(got[rss.KindDeath]) != 1 {
+		t.Errorf("deaths = %v, want 1", got[rss.KindDeath])
+	}
+}
+
+func TestFallbackSelector(t *testing.T) {
+	var events []rss.HistoricalEvent
+	for _, year := range []string{"1066", "1215", "1492", "1776", "1815", "1969"} {
+		events = append(events, rss.HistoricalEvent{Year: year, Title: "Event " + year})
+	}
+	events = append(events, rss.HistoricalEvent{Kind: rss.KindBirth, Year: "1938", Title: "Natalie Wood"})
+
+	s := &FallbackSelector{Budgets: map[rss.EventKind]int{rss.KindEvent: 3, rss.KindBirth: 2}}
+	got, err := s.Select(context.Background(), &Run{Pipeline: "test"}, events)
+	if err != nil {
+		t.Fatalf("Select() returned error: %v", err)
+	}
+
+	var years []string
+	for _, event := range got {
+		years = append(years, event.Year)
+	}
+	if strings.Join(years, ",") != "1066,1492,1815,1938" {
+		t.Errorf("Select() years = %v, want picks spread over the events plus the one birth", years)
+	}
+	if got[3].Kind != rss.KindBirth {
+		t.Errorf("Kind = %q, want birth", got[3].Kind)
+	}
+
+	if _, err := s.Select(context.Background(), &Run{}, nil); err == nil {
+		t.Error("Select() expected an error with no candidates")
+	}
+}
+
+func TestClaudeSelectorFallsBackOverBudget(t *testing.T) {
+	tracker, err := usage.NewTracker(usage.PriceTable{"claude-test": {Input: 1}}, usage.Budget{Run: 0.5}, nil)
+	if err != nil {
+		t.Fatalf("NewTracker() returned error: %v", err)
+	}
+	ctx := logging.WithRunID(context.Background(), "run1")
+	tracker.Record(ctx, "claude-test", usage.Tokens{Input: 500_000})
+
+	// Over budget, the selector refuses to call Claude at all
+	selector := llm.NewSelector("test-key", "claude-test", 1, nil)
+	selector.SetBudget(tracker, "")
+
+	budgets := map[rss.EventKind]int{rss.KindEvent: 1}
+	s := &ClaudeSelector{Selector: selector, Budgets: budgets, Fallback: &FallbackSelector{Budgets: budgets}}
+	got, err := s.Select(ctx, &Run{Pipeline: "test"}, testEvents())
+	if err != nil {
+		t.Fatalf("Select() returned error: %v", err)
+	}
+	if len(got) != 1 || got[0].Title != "Battle of Waterloo" {
+		t.Errorf("Select() = %+v, want the fallback's pick", got)
+	}
+
+	s.Fallback = nil
+	if _, err := s.Select(ctx, &Run{Pipeline: "test"}, testEvents()); !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Errorf("Select() without a fallback = %v, want ErrBudgetExceeded", err)
+	}
+
+	// Past the monthly limit, not even the budget model is called
+	limited, err := usage.NewTracker(usage.PriceTable{"claude-test": {Input: 1}}, usage.Budget{Limit: 0.5}, nil)
+	if err != nil {
+		t.Fatalf("NewTracker() returned error: %v", err)
+	}
+	limited.Record(ctx, "claude-test", usage.Tokens{Input: 500_000})
+	selector.SetBudget(limited, "claude-test")
+	if _, err := s.Select(ctx, &Run{Pipeline: "test"}, testEvents()); !errors.Is(err, usage.ErrLimitReached) {
+		t.Errorf("Select() past the limit = %v, want ErrLimitReached", err)
+	}
+}
+
+// roundTripFunc answers HTTP requests in place of a server
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r), nil }
+
+func TestClaudeSelectorFailsWithoutEvents(t *testing.T) {
+	// Claude fails to select events but selects births
+	transport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) *http.Response {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "Here are today's historical events") {
+			return &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader("bad request"))}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(
+			`{"content": [{"type": "text", "text": "{\"events\": [{\"year\": \"1854\", \"title\": \"John Philip Sousa\", \"source\": 1}]}"}]}`))}
+	})
+
+	events := append(testEvents(), rss.HistoricalEvent{Kind: rss.KindBirth, Year: "1854", Title: "John Philip Sousa"})
+	budgets := map[rss.EventKind]int{rss.KindEvent: 1, rss.KindBirth: 1}
+	s := &ClaudeSelector{Selector: llm.NewSelector("test-key", "claude-test", 1, nil), Budgets: budgets}
+
+	// A post of births alone isn't the post, so the stage fails for a retry
+	if got, err := s.Select(context.Background(), &Run{Pipeline: "test"}, events); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("Select() = %+v, %v; want the event selection's error", got, err)
+	}
+
+	// Without events to select from, births alone are fine
+	got, err := s.Select(context.Background(), &Run{Pipeline: "test"}, events[2:])
+	if err != nil || len(got) != 1 || got[0].Title != "John Philip Sousa" {
+		t.Errorf("Select() = %+v, %v; want the birth", got, err)
+	}
+}
+
+func TestHolidayEnricherUsesWikipedia(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"holidays": [
+			{"text": "International Day of Peace", "pages": []},
+			{"text": "Moon Day", "pages": [{"extract": "Marks the first Moon landing.", "content_urls": {"desktop": {"page": "https://en.wikipedia.org/wiki/Moon_Day"}}}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	// Over budget, so holidays are picked in feed order without calling Claude
+	tracker, err := usage.NewTracker(usage.PriceTable{"claude-test": {Input: 1}}, usage.Budget{Run: 0.5}, nil)
+	if err != nil {
+		t.Fatalf("NewTracker() returned error: %v", err)
+	}
+	ctx := logging.WithRunID(context.Background(), "run1")
+	tracker.Record(ctx, "claude-test", usage.Tokens{Input: 500_000})
+	selector := llm.NewSelector("test-key", "claude-test", 1, nil)
+	selector.SetBudget(tracker, "")
+
+	enricher := &HolidayEnricher{
+		Wikipedia:   wikipedia.NewClient(server.URL, "en"),
+		Selector:    selector,
+		MaxHolidays: 2,
+	}
+	run := &Run{Pipeline: "test", Date: time.Date(2024, time.July, 20, 0, 0, 0, 0, time.UTC)}
+	if err := enricher.Enrich(ctx, run); err != nil {
+		t.Fatalf("Enrich() returned error: %v", err)
+	}
+	if len(run.Holidays) != 1 || run.Holidays[0].Title != "Moon Day" || run.Holidays[0].Link != "https://en.wikipedia.org/wiki/Moon_Day" {
+		t.Errorf("Holidays = %+v, want only Moon Day", run.Holidays)
+	}
+}
+
+func TestImageCheckerDropsBrokenImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok.jpg" {
+			w.Header().Set("Content-Type", "image/jpeg")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	run := &Run{Selected: []llm.SelectedEvent{
+		{Title: "Has image", ImageURL: server.URL + "/ok.jpg"},
+		{Title: "Broken image", ImageURL: server.URL + "/gone.jpg"},
+		{Title: "No image"},
+	}}
+
+	enricher := &ImageChecker{Checker: images.NewChecker()}
+	if err := enricher.Enrich(context.Background(), run); err != nil {
+		t.Fatalf("Enrich() returned error: %v", err)
+	}
+
+	if run.Selected[0].ImageURL == "" {
+		t.Error("valid image URL was dropped")
+	}
+	if run.Selected[1].ImageURL != "" {
+		t.Errorf("broken image URL = %q, want it dropped", run.Selected[1].ImageURL)
+	}
+}
+
+// fakeSoftener rewrites descriptions, or fails with err
+type fakeSoftener struct{ err error }
+
+func (f *fakeSoftener) SoftenEvent(ctx context.Context, event llm.SelectedEvent) (llm.SelectedEvent, error) {
+	if f.err != nil {
+		return event, f.err
+	}
+	event.Description = "A gentler telling."
+	return event, nil
+}
+
+func TestModerator(t *testing.T) {
+	run := &Run{
+		Pipeline: "test",
+		Events: []rss.HistoricalEvent{
+			{Year: "1606", Title: "Battle of somewhere", Description: "A bloody battle."},
+			{Year: "1969", Title: "Apollo 11 lands on the Moon"},
+			{Year: "1903", Title: "First powered flight", Description: "The Wright brothers fly at Kitty Hawk."},
+			{Year: "1938", Title: "Natalie Wood", Kind: rss.KindBirth},
+		},
+	}
+	selected := []llm.SelectedEvent{
+		{Year: "1969", Title: "Apollo 11 lands on the Moon", Category: "Science"},
+		{Year: "1815", Title: "Battle of Waterloo", Description: "Napoleon is defeated."},
+		{Year: "1912", Title: "Titanic disaster", Description: "The liner sinks."},
+		{Year: "1860", Title: "Lincoln elected president"},
+		{Kind: rss.KindDeath, Year: "2003", Title: "Johnny Cash", Description: "American singer-songwriter, died aged 71."},
+	}
+	m := &Moderator{
+		Classifier: moderation.NewClassifier(),
+		Policy:     moderation.Policy{moderation.Violence: moderation.Swap, moderation.Tragedy: moderation.Soften, moderation.Politics: moderation.Separate},
+		Softener:   &fakeSoftener{},
+	}
+
+	got, err := m.Review(context.Background(), run, selected)
+	if err != nil {
+		t.Fatalf("Review() returned error: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("len(Review()) = %d, want 5: %+v", len(got), got)
+	}
+
+	if got[0].Title != "Apollo 11 lands on the Moon" || got[0].Flags != nil || got[0].Separate {
+		t.Errorf("unflagged event changed: %+v", got[0])
+	}
+	// The battle is swapped for the first clean candidate whose year isn't already posted
+	if got[1].Title != "First powered flight" || got[1].Kind != rss.KindEvent {
+		t.Errorf("swap = %+v, want First powered flight", got[1])
+	}
+	if got[2].Description != "A gentler telling." || got[2].Separate || len(got[2].Flags) != 1 || got[2].Flags[0] != "tragedy" {
+		t.Errorf("soften = %+v, want rewritten and flagged tragedy", got[2])
+	}
+	if !got[3].Separate || got[3].Flags[0] != "politics" {
+		t.Errorf("separate = %+v, want separated and flagged politics", got[3])
+	}
+	// A plain death entry isn't a tragedy
+	if got[4].Title != "Johnny Cash" || got[4].Flags != nil || got[4].Description != "American singer-songwriter, died aged 71." {
+		t.Errorf("death entry = %+v, want it posted as selected", got[4])
+	}
+}
+
+func TestModeratorFailsClosed(t *testing.T) {
+	run := &Run{Pipeline: "test"}
+	selected := []llm.SelectedEvent{
+		{Year: "1815", Title: "Battle of Waterloo"},
+		{Year: "1912", Title: "Titanic disaster"},
+	}
+	m := &Moderator{
+		Classifier: moderation.NewClassifier(),
+		Policy:     moderation.Policy{moderation.Violence: moderation.Swap, moderation.Tragedy: moderation.Soften},
+		Softener:   &fakeSoftener{err: errors.New("status 529")},
+	}
+
+	got, err := m.Review(context.Background(), run, selected)
+	if err != nil {
+		t.Fatalf("Review() returned error: %v", err)
+	}
+	// No candidate to swap in drops the battle; a failed rewrite separates the disaster
+	if len(got) != 1 || got[0].Title != "Titanic disaster" || !got[0].Separate {
+		t.Errorf("Review() = %+v, want only the separated disaster", got)
+	}
+}
@@ -0,0 +1,256 @@
+package prefilter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// Options configures Apply
+type Options struct {
+	BlockedCategories []string // Categories dropped outright, matched case-insensitively
+	MaxDescription    int      // Runes kept of each description; 0 keeps them whole
+	TokenBudget       int      // Estimated prompt tokens per kind of event; 0 is unlimited
+}
+
+// Stats counts what Apply removed or shortened
+type Stats struct {
+	Blocked   int // Items in a blocked category
+	Truncated int // Descriptions shortened
+	Sampled   int // Items left out to fit the token budget
+}
+
+// Apply trims candidate events before they're sent to the LLM: it drops
+// blocked categories, truncates descriptions and samples each kind of event
+// down to the token budget. Events keep their order. Duplicates are merged
+// beforehand by the dedup stage.
+func Apply(events []rss.HistoricalEvent, opts Options) ([]rss.HistoricalEvent, Stats) {
+	var stats Stats
+
+	events, stats.Blocked = dropCategories(events, opts.BlockedCategories)
+
+	if opts.MaxDescription > 0 {
+		for i := range events {
+			if short := Truncate(events[i].Description, opts.MaxDescription); short != strings.TrimSpace(events[i].Description) {
+				events[i].Description = short
+				stats.Truncated++
+			}
+		}
+	}
+
+	if opts.TokenBudget > 0 {
+		before := len(events)
+		events = sampleByKind(events, opts.TokenBudget)
+		stats.Sampled = before - len(events)
+	}
+
+	return events, stats
+}
+
+// dropCategories removes events whose category is blocked
+func dropCategories(events []rss.HistoricalEvent, blocked []string) ([]rss.HistoricalEvent, int) {
+	if len(blocked) == 0 {
+		return events, 0
+	}
+	set := make(map[string]bool, len(blocked))
+	for _, category := range blocked {
+		set[strings.ToLower(strings.TrimSpace(category))] = true
+	}
+
+	out := events[:0:0]
+	for _, event := range events {
+		if !set[strings.ToLower(strings.TrimSpace(event.Category))] {
+			out = append(out, event)
+		}
+	}
+	return out, len(events) - len(out)
+}
+
+// Truncate shortens s to at most max runes, at a word boundary, marking the
+// cut with an ellipsis
+func Truncate(s string, max int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= max {
+		return string(runes)
+	}
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	// Don't cut a link in two
+	if open := strings.LastIndex(cut, "["); open >= 0 && !strings.Contains(cut[open:], ")") {
+		cut = strings.TrimSpace(cut[:open])
+	}
+	return strings.TrimRight(cut, " ,;:") + "…"
+}
+
+// EstimateTokens estimates the prompt tokens an event takes up in the LLM's
+// candidate list, at about four characters per token. The layout mirrors the
+// llm package's numbered list, which leaves out link targets.
+func EstimateTokens(event rss.HistoricalEvent) int {
+	chars := len(fmt.Sprintf("000. [%s] %s\n", event.Year, event.Title))
+	if event.Description != "" {
+		chars += len("   ") + len(htmltext.StripLinks(event.Description)) + 1
+	}
+	if event.Category != "" {
+		chars += len("   Category: ") + len(event.Category) + 1
+	}
+	return (chars+1)/4 + 1
+}
+
+// sampleByKind samples each kind of event to the budget separately, since
+// each kind is selected with its own prompt
+func sampleByKind(events []rss.HistoricalEvent, budget int) []rss.HistoricalEvent {
+	byKind := make(map[rss.EventKind][]int)
+	var kinds []rss.EventKind
+	for i, event := range events {
+		kind := rss.KindOf(event)
+		if _, ok := byKind[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+		byKind[kind] = append(byKind[kind], i)
+	}
+
+	keep := make([]bool, len(events))
+	for _, kind := range kinds {
+		indexes := byKind[kind]
+		group := make([]rss.HistoricalEvent, len(indexes))
+		for j, i := range indexes {
+			group[j] = events[i]
+		}
+		for _, j := range sample(group, budget) {
+			keep[indexes[j]] = true
+		}
+	}
+
+	out := make([]rss.HistoricalEvent, 0, len(events))
+	for i, event := range events {
+		if keep[i] {
+			out = append(out, event)
+		}
+	}
+	return out
+}
+
+// Sample picks events to fit the token budget, spread across eras and,
+// within each era, across categories, so a feed heavy on one century or
+// topic doesn't crowd out the rest. It takes one event from each era in
+// turn, rotating through each era's categories, and skips events that no
+// longer fit. Events keep their feed order within each category.
+func Sample(events []rss.HistoricalEvent, budget int) []rss.HistoricalEvent {
+	if budget <= 0 {
+		return events
+	}
+	out := make([]rss.HistoricalEvent, 0, len(events))
+	for _, i := range sample(events, budget) {
+		out = append(out, events[i])
+	}
+	return out
+}
+
+// sample returns the indexes of the events Sample picks, in order
+func sample(events []rss.HistoricalEvent, budget int) []int {
+	total := 0
+	for _, event := range events {
+		total += EstimateTokens(event)
+	}
+	if total <= budget {
+		all := make([]int, len(events))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	// Queue each event under its era and category
+	eras := make([]*stratum, unknownEra+1)
+	for i, event := range events {
+		e := Era(event)
+		if eras[e] == nil {
+			eras[e] = &stratum{byCategory: make(map[string]int)}
+		}
+		era := eras[e]
+		era.push(strings.ToLower(strings.TrimSpace(event.Category)), i)
+	}
+
+	keep := make([]bool, len(events))
+	spent := 0
+	for remaining := len(events); remaining > 0; {
+		for _, era := range eras {
+			i, ok := era.next()
+			if !ok {
+				continue
+			}
+			remaining--
+			if cost := EstimateTokens(events[i]); spent+cost <= budget {
+				keep[i] = true
+				spent += cost
+			}
+		}
+	}
+
+	var picked []int
+	for i, kept := range keep {
+		if kept {
+			picked = append(picked, i)
+		}
+	}
+	return picked
+}
+
+// stratum holds one era's events, queued by category
+type stratum struct {
+	byCategory map[string]int // Category to index in queues
+	queues     [][]int
+	cursor     int
+}
+
+func (s *stratum) push(category string, i int) {
+	q, ok := s.byCategory[category]
+	if !ok {
+		q = len(s.queues)
+		s.byCategory[category] = q
+		s.queues = append(s.queues, nil)
+	}
+	s.queues[q] = append(s.queues[q], i)
+}
+
+// next pops the next event of the next category with events left
+func (s *stratum) next() (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+	for range s.queues {
+		q := s.cursor
+		s.cursor = (s.cursor + 1) % len(s.queues)
+		if len(s.queues[q]) > 0 {
+			i := s.queues[q][0]
+			s.queues[q] = s.queues[q][1:]
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// eraBounds are the first years of each era after the first: ancient,
+// medieval, early modern, the 19th, 20th and 21st centuries
+var eraBounds = []int{500, 1500, 1800, 1900, 2000}
+
+// unknownEra is the era of years that can't be parsed
+var unknownEra = len(eraBounds) + 1
+
+// Era returns the era of an event's year, from 0 (ancient) to
+// len(eraBounds) (the 21st century), or unknownEra
+func Era(event rss.HistoricalEvent) int {
+	year, ok := rss.SortYear(event)
+	if !ok {
+		return unknownEra
+	}
+	era := 0
+	for era < len(eraBounds) && year >= eraBounds[era] {
+		era++
+	}
+	return era
+}
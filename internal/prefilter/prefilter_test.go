@@ -0,0 +1,142 @@
+package prefilter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+func TestApply(t *testing.T) {
+	events := []rss.HistoricalEvent{
+		{Year: "1815", Title: "Battle of Waterloo", Category: "War", Description: "Napoleon is defeated."},
+		{Year: "1969", Title: "Apollo 11 lands", Category: "Science", Description: strings.Repeat("word ", 40)},
+		{Year: "1912", Title: "Titanic sinks", Category: "war"},
+	}
+
+	got, stats := Apply(events, Options{BlockedCategories: []string{"WAR"}, MaxDescription: 50})
+	if len(got) != 1 || got[0].Title != "Apollo 11 lands" {
+		t.Fatalf("Apply() = %+v, want only the Moon landing", got)
+	}
+	if len([]rune(got[0].Description)) > 51 || !strings.HasSuffix(got[0].Description, "…") {
+		t.Errorf("Description = %q, want it truncated to 50 runes", got[0].Description)
+	}
+	want := Stats{Blocked: 2, Truncated: 1}
+	if stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestTruncateKeepsLinksWhole(t *testing.T) {
+	s := "Apollo 11 lands. See [the mission page](https://example.com/apollo) for more."
+	if got, want := Truncate(s, 40), "Apollo 11 lands. See…"; got != want {
+		t.Errorf("Truncate() = %q, want %q", got, want)
+	}
+}
+
+func TestSampleSpreadsAcrossErasAndCategories(t *testing.T) {
+	// A feed heavy on 20th-century politics, with one ancient and one
+	// medieval event and one 20th-century science event at the end
+	var events []rss.HistoricalEvent
+	for i := 0; i < 20; i++ {
+		events = append(events, rss.HistoricalEvent{Year: fmt.Sprint(1901 + i), Title: fmt.Sprintf("Election %d", i), Category: "Politics"})
+	}
+	events = append(events,
+		rss.HistoricalEvent{Year: "44 BC", Title: "Caesar assassinated", Category: "Politics"},
+		rss.HistoricalEvent{Year: "1066", Title: "Battle of Hastings", Category: "War"},
+		rss.HistoricalEvent{Year: "1953", Title: "DNA structure published", Category: "Science"},
+	)
+
+	budget := 5 * EstimateTokens(events[0])
+	got := Sample(events, budget)
+
+	titles := make(map[string]bool)
+	spent := 0
+	for _, event := range got {
+		titles[event.Title] = true
+		spent += EstimateTokens(event)
+	}
+	if spent > budget {
+		t.Errorf("sample costs %d tokens, over the budget of %d", spent, budget)
+	}
+	for _, want := range []string{"Caesar assassinated", "Battle of Hastings", "DNA structure published", "Election 0"} {
+		if !titles[want] {
+			t.Errorf("sample is missing %q: %v", want, titles)
+		}
+	}
+
+	// Feed order is kept
+	for i := 1; i < len(got); i++ {
+		if indexOf(events, got[i].Title) < indexOf(events, got[i-1].Title) {
+			t.Errorf("sample is out of feed order: %v", got)
+		}
+	}
+
+	// Under budget, nothing is dropped
+	if got := Sample(events, 1_000_000); len(got) != len(events) {
+		t.Errorf("len(Sample()) = %d, want all %d under budget", len(got), len(events))
+	}
+}
+
+func TestApplySamplesEachKind(t *testing.T) {
+	var events []rss.HistoricalEvent
+	for i := 0; i < 10; i++ {
+		events = append(events,
+			rss.HistoricalEvent{Year: fmt.Sprint(1900 + i), Title: fmt.Sprintf("Event %d", i)},
+			rss.HistoricalEvent{Kind: rss.KindBirth, Year: fmt.Sprint(1800 + i), Title: fmt.Sprintf("Person %d", i)})
+	}
+
+	budget := 3 * EstimateTokens(events[0])
+	got, stats := Apply(events, Options{TokenBudget: budget})
+
+	kinds := make(map[rss.EventKind]int)
+	for _, event := range got {
+		kinds[rss.KindOf(event)]++
+	}
+	if kinds[rss.KindEvent] != 3 || kinds[rss.KindBirth] != 3 {
+		t.Errorf("kept %v, want 3 of each kind", kinds)
+	}
+	if stats.Sampled != 14 {
+		t.Errorf("Sampled = %d, want 14", stats.Sampled)
+	}
+}
+
+func TestEra(t *testing.T) {
+	tests := []struct {
+		year string
+		want int
+	}{
+		{"44 BC", 0},
+		{"300 BCE", 0},
+		{"499", 0},
+		{"1066", 1},
+		{"1776", 2},
+		{"1815", 3},
+		{"1969", 4},
+		{"2004", 5},
+		{"c. 1500", 2},
+		{"5th century BC", 0},
+		{"1960s", 4},
+		{"unknown", unknownEra},
+	}
+	for _, tt := range tests {
+		if got := Era(rss.HistoricalEvent{Year: tt.year}); got != tt.want {
+			t.Errorf("Era(%q) = %d, want %d", tt.year, got, tt.want)
+		}
+	}
+
+	// A source's numeric year wins over the display string
+	if got := Era(rss.HistoricalEvent{Year: "356 BC", SortYear: -356}); got != 0 {
+		t.Errorf("Era() = %d, want 0", got)
+	}
+}
+
+func indexOf(events []rss.HistoricalEvent, title string) int {
+	for i, event := range events {
+		if event.Title == title {
+			return i
+		}
+	}
+	return -1
+}
@@ -2,12 +2,19 @@ package rss
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/health"
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
 )
 
 // Feed represents an RSS feed
@@ -32,15 +39,59 @@ type Item struct {
 	PubDate     string   `xml:"pubDate"`
 	Categories  []string `xml:"category"`
 	GUID        string   `xml:"guid"`
+
+	Enclosure      *Enclosure `xml:"enclosure"`
+	MediaContent   []Media    `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnail []Media    `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// Enclosure is an RSS enclosure, such as an attached image
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// Media is a Media RSS (media:content or media:thumbnail) element
+type Media struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+// EventKind distinguishes the kinds of entries sources produce
+type EventKind string
+
+const (
+	KindEvent      EventKind = "event"
+	KindBirth      EventKind = "birth"
+	KindDeath      EventKind = "death"
+	KindHoliday    EventKind = "holiday"
+	KindObservance EventKind = "observance"
+)
+
+// Kinds lists every event kind, in display order
+var Kinds = []EventKind{KindEvent, KindBirth, KindDeath, KindHoliday, KindObservance}
+
+// KindOf returns the event's kind, treating an unset kind as a plain event
+func KindOf(event HistoricalEvent) EventKind {
+	if event.Kind == "" {
+		return KindEvent
+	}
+	return event.Kind
 }
 
 // HistoricalEvent represents a parsed historical event
 type HistoricalEvent struct {
-	Year        string
+	Kind        EventKind
+	Year        string // Year as displayed, such as "1969", "44 BC" or "c. 1500"
+	SortYear    int    // Year as a number for sorting, negative for BC; 0 if unknown
 	Title       string
 	Description string
 	Category    string
 	Link        string
+	Links       []string // All related links, when a source provides several
+	ImageURL    string   // Thumbnail, when a source provides one
 	RawItem     Item
 }
 
@@ -66,9 +117,9 @@ func NewParser() *Parser {
 }
 
 // FetchAndParse fetches and parses an RSS feed from the given URL
-func (p *Parser) FetchAndParse(url string) ([]HistoricalEvent, error) {
+func (p *Parser) FetchAndParse(ctx context.Context, url string) ([]HistoricalEvent, error) {
 	// Create request with browser headers
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -128,15 +179,22 @@ func (p *Parser) FetchAndParse(url string) ([]HistoricalEvent, error) {
 func (p *Parser) parseItem(item Item) HistoricalEvent {
 	event := HistoricalEvent{
 		Title:       item.Title,
-		Description: cleanHTML(item.Description),
+		Description: htmltext.ToText(item.Description),
 		Link:        item.Link,
 		RawItem:     item,
 	}
 
-	// Extract year from title if present (e.g., "1969: Apollo 11...")
-	if parts := strings.SplitN(item.Title, ":", 2); len(parts) == 2 {
-		event.Year = strings.TrimSpace(parts[0])
-		event.Title = strings.TrimSpace(parts[1])
+	// Take the year from the start of the title (e.g., "1969: Apollo 11..."),
+	// else from the description or, failing that, the pubDate
+	year, title, ok := splitTitleYear(item.Title)
+	if ok {
+		event.Title = title
+	} else if year, ok = FindYear(event.Description); !ok {
+		year, ok = pubDateYear(item.PubDate)
+	}
+	if ok {
+		event.Year = year.Text
+		event.SortYear = year.Sort()
 	}
 
 	// Use first category if available
@@ -144,68 +202,121 @@ func (p *Parser) parseItem(item Item) HistoricalEvent {
 		event.Category = item.Categories[0]
 	}
 
+	event.Kind = itemKind(item.Categories, event.Title)
+	event.ImageURL = itemImage(item)
+
 	return event
 }
 
-// cleanHTML strips basic HTML tags from a string
-// For more robust HTML cleaning, consider using golang.org/x/net/html
-func cleanHTML(s string) string {
-	// Remove common HTML tags
-	s = strings.ReplaceAll(s, "<br>", "\n")
-	s = strings.ReplaceAll(s, "<br/>", "\n")
-	s = strings.ReplaceAll(s, "<br />", "\n")
-	s = strings.ReplaceAll(s, "<p>", "\n")
-	s = strings.ReplaceAll(s, "</p>", "\n")
-
-	// Remove all remaining tags
-	for {
-		start := strings.Index(s, "<")
-		if start == -1 {
-			break
+// itemImage returns the item's image URL, preferring media:content, then an
+// image enclosure, then media:thumbnail
+func itemImage(item Item) string {
+	for _, media := range item.MediaContent {
+		if media.URL != "" && (media.Medium == "image" || strings.HasPrefix(media.Type, "image/")) {
+			return media.URL
 		}
-		end := strings.Index(s[start:], ">")
-		if end == -1 {
-			break
+	}
+	if item.Enclosure != nil && item.Enclosure.URL != "" && strings.HasPrefix(item.Enclosure.Type, "image/") {
+		return item.Enclosure.URL
+	}
+	for _, media := range item.MediaThumbnail {
+		if media.URL != "" {
+			return media.URL
 		}
-		s = s[:start] + s[start+end+1:]
 	}
+	return ""
+}
 
-	// Clean up whitespace
-	s = strings.TrimSpace(s)
-
-	// Remove excessive newlines
-	for strings.Contains(s, "\n\n\n") {
-		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+// itemKind infers an item's kind from its categories, falling back to the
+// wording of its title (e.g. "Birth of Ada Lovelace")
+func itemKind(categories []string, title string) EventKind {
+	for _, category := range categories {
+		switch strings.ToLower(strings.TrimSpace(category)) {
+		case "birth", "births", "birthday", "birthdays":
+			return KindBirth
+		case "death", "deaths":
+			return KindDeath
+		case "holiday", "holidays":
+			return KindHoliday
+		case "observance", "observances":
+			return KindObservance
+		}
 	}
 
-	return s
+	lower := strings.ToLower(title)
+	switch {
+	case strings.HasPrefix(lower, "born "), strings.HasPrefix(lower, "birth of "):
+		return KindBirth
+	case strings.HasPrefix(lower, "died "), strings.HasPrefix(lower, "death of "):
+		return KindDeath
+	}
+	return KindEvent
 }
 
 // FetchMultipleFeeds fetches and parses multiple RSS feeds
-func (p *Parser) FetchMultipleFeeds(urls []string) ([]HistoricalEvent, error) {
+func (p *Parser) FetchMultipleFeeds(ctx context.Context, urls []string) ([]HistoricalEvent, error) {
+	logger := logging.Stage(ctx, "rss")
 	var allEvents []HistoricalEvent
 
 	for _, url := range urls {
-		events, err := p.FetchAndParse(url)
+		start := time.Now()
+		events, err := p.FetchAndParse(ctx, url)
+		elapsed := time.Since(start)
+		feed := FeedName(url)
+		metrics.FeedFetchDuration.Observe(elapsed.Seconds(), feed)
 		if err != nil {
+			metrics.FeedFetchErrors.Inc(feed)
 			// Log error but continue with other feeds
-			fmt.Printf("Warning: failed to fetch feed %s: %v\n", url, err)
+			logger.Warn("failed to fetch feed", "feed", feed, "duration", elapsed, "error", err)
 			continue
 		}
+		metrics.FeedItems.Set(float64(len(events)), feed)
+		logger.Debug("fetched feed", "feed", feed, "items", len(events), "duration", elapsed)
 		allEvents = append(allEvents, events...)
 	}
 
 	if len(allEvents) == 0 {
-		return nil, fmt.Errorf("no events fetched from any feed")
+		err := fmt.Errorf("no events fetched from any feed")
+		health.Components.Record(health.ComponentFeeds, err)
+		return nil, err
 	}
 
+	health.Components.Record(health.ComponentFeeds, nil)
 	return allEvents, nil
 }
 
 // FetchHolidays fetches holidays from a holiday RSS feed
-func (p *Parser) FetchHolidays(url string) ([]Holiday, error) {
+func (p *Parser) FetchHolidays(ctx context.Context, url string) ([]Holiday, error) {
+	start := time.Now()
+	holidays, err := p.fetchHolidays(ctx, url)
+	elapsed := time.Since(start)
+	feed := FeedName(url)
+	metrics.FeedFetchDuration.Observe(elapsed.Seconds(), feed)
+	health.Components.Record(health.ComponentHolidays, err)
+	if err != nil {
+		metrics.FeedFetchErrors.Inc(feed)
+		return nil, err
+	}
+	metrics.FeedItems.Set(float64(len(holidays)), feed)
+	logging.Stage(ctx, "rss").Debug("fetched holiday feed", "feed", feed, "items", len(holidays), "duration", elapsed)
+	return holidays, nil
+}
+
+// FeedName names a feed in metrics and logs by its URL's host and path. The
+// scheme, credentials, query and fragment are left out, since feed URLs
+// often carry API keys or tokens in them.
+func FeedName(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Host + strings.TrimSuffix(u.EscapedPath(), "/")
+}
+
+// fetchHolidays does the actual fetching and parsing for FetchHolidays
+func (p *Parser) fetchHolidays(ctx context.Context, url string) ([]Holiday, error) {
 	// Create request with browser headers
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -256,7 +367,7 @@ func (p *Parser) FetchHolidays(url string) ([]Holiday, error) {
 	for _, item := range feed.Channel.Items {
 		holiday := Holiday{
 			Title:       item.Title,
-			Description: cleanHTML(item.Description),
+			Description: htmltext.ToText(item.Description),
 			Link:        item.Link,
 		}
 		holidays = append(holidays, holiday)
@@ -0,0 +1,304 @@
+package rss
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Year is a parsed year expression such as "1969", "44 BC", "c. 1500",
+// "1960s" or "1914–1918". Years are numbered for sorting: AD years as
+// themselves and BC years as negative numbers, so 44 BC is -44. There is no
+// year 0.
+type Year struct {
+	Text  string // The expression as written, for display
+	Start int    // First year covered
+	End   int    // Last year covered; Start for a single year
+	Circa bool   // The year is approximate
+}
+
+// Sort returns the year used for sorting and eras: the first year covered
+func (y Year) Sort() int { return y.Start }
+
+var (
+	// yearPoint matches a single year: an optional AD prefix, the number, an
+	// optional "s" for a decade or century and an optional era suffix
+	yearPoint = regexp.MustCompile(`(?i)^(?:(AD|A\.D\.)\s*)?(\d{1,3}(?:,\d{3})+|\d{1,5})('?s)?(?:\s*(BCE|B\.C\.E\.|BC|B\.C\.|AD|A\.D\.|CE|C\.E\.))?$`)
+
+	// yearCentury matches "5th century BC" and the like
+	yearCentury = regexp.MustCompile(`(?i)^(\d{1,2})(?:st|nd|rd|th)\s+century(?:\s*(BCE|B\.C\.E\.|BC|B\.C\.|AD|A\.D\.|CE|C\.E\.))?$`)
+
+	// yearRangeSep separates the ends of a range
+	yearRangeSep = regexp.MustCompile(`(?i)\s*(?:[-–—]|\bto\b)\s*`)
+
+	// yearInText finds year expressions in free text, to be checked by
+	// ParseYear
+	yearInText = regexp.MustCompile(`(?i)(?:\b(?:circa|ca\.|c\.)\s*)?(?:\b(?:AD|A\.D\.)\s*)?\b\d{1,4}(?:'?s)?(?:\s*(?:[-–—]|to)\s*\d{1,4}(?:'?s)?)?(?:\s*(?:B\.C\.(?:E\.)?|A\.D\.|C\.E\.|(?:BCE|BC|AD|CE)\b))?`)
+
+	// yearEra finds an era in a year expression with its dots removed
+	yearEra = regexp.MustCompile(`(?i)\b(?:BCE|BC|AD|CE)\b`)
+
+	// yearContext matches the end of text that puts a date after it: a
+	// preposition ("in 1969", "on 20 July 1969", "in the 1950s") or a month
+	// ("July 1969", "July 20, 1969")
+	yearContext = regexp.MustCompile(`(?i)(?:\b(?:in|on|of|since|by|until|till|from|during|before|after|between)(?:\s+(?:the|early|late|mid-?|year))*|\b(?:jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec|january|february|march|april|june|july|august|september|october|november|december)\.?(?:\s+\d{1,2}(?:st|nd|rd|th)?,?)?)\s*$`)
+
+	// isoDate matches a date such as 1969-07-20
+	isoDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\b`)
+)
+
+// circaPrefixes mark an approximate year, longest first
+var circaPrefixes = []string{"circa", "ca.", "ca", "c.", "c", "~"}
+
+// ParseYear parses a year expression: a year with an optional era (BC, BCE,
+// AD, CE), optionally marked approximate ("c. 1500", "circa 1500"), a decade
+// or century ("1960s", "1800s", "5th century BC") or a range ("1914–1918",
+// "1914–18", "44–43 BC"). The whole string must be the expression.
+func ParseYear(s string) (Year, bool) {
+	text := strings.TrimSpace(s)
+	y := Year{Text: text}
+
+	rest := text
+	lower := strings.ToLower(rest)
+	for _, prefix := range circaPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			trimmed := strings.TrimSpace(rest[len(prefix):])
+			if trimmed != "" && trimmed[0] >= '0' && trimmed[0] <= '9' {
+				y.Circa = true
+				rest = trimmed
+				break
+			}
+		}
+	}
+	if rest == "" {
+		return Year{}, false
+	}
+
+	if m := yearCentury.FindStringSubmatch(rest); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if n == 0 {
+			return Year{}, false
+		}
+		if isBC(m[2]) {
+			y.Start, y.End = -n*100, -((n-1)*100 + 1)
+		} else {
+			y.Start, y.End = (n-1)*100+1, n*100
+		}
+		return y, true
+	}
+
+	var ends []string
+	if loc := yearRangeSep.FindStringIndex(rest); loc != nil && loc[0] > 0 {
+		ends = []string{rest[:loc[0]], rest[loc[1]:]}
+	} else {
+		ends = []string{rest}
+	}
+
+	first, ok := parsePoint(ends[0])
+	if !ok {
+		return Year{}, false
+	}
+	if len(ends) == 1 {
+		y.Start, y.End = first.span()
+		return y, true
+	}
+
+	last, ok := parsePoint(ends[1])
+	if !ok {
+		return Year{}, false
+	}
+	// An era written once applies to both ends: "44–43 BC", "1914–18"
+	if first.era == "" && last.era == "bc" {
+		first.era = "bc"
+	}
+	if last.era == "" {
+		last.era = first.era
+		if first.era != "bc" && len(last.digits) < len(first.digits) {
+			scale := 1
+			for range last.digits {
+				scale *= 10
+			}
+			last.n += first.n - first.n%scale
+		}
+	}
+
+	y.Start, _ = first.span()
+	_, y.End = last.span()
+	if y.End < y.Start {
+		return Year{}, false
+	}
+	return y, true
+}
+
+// yearPart is one end of a year expression
+type yearPart struct {
+	n      int
+	digits string
+	plural bool   // "1960s"
+	era    string // "bc", "ad" or "" if not written
+}
+
+func parsePoint(s string) (yearPart, bool) {
+	m := yearPoint.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[1] != "" && isBC(m[4])) {
+		return yearPart{}, false
+	}
+	digits := strings.ReplaceAll(m[2], ",", "")
+	n, err := strconv.Atoi(digits)
+	if err != nil || n == 0 {
+		return yearPart{}, false
+	}
+
+	p := yearPart{n: n, digits: digits, plural: m[3] != ""}
+	switch {
+	case isBC(m[4]):
+		p.era = "bc"
+	case m[1] != "" || m[4] != "":
+		p.era = "ad"
+	}
+	if p.plural && n%10 != 0 {
+		return yearPart{}, false
+	}
+	return p, true
+}
+
+// span returns the first and last sortable years the part covers
+func (p yearPart) span() (int, int) {
+	length := 1
+	if p.plural {
+		length = 10
+		if p.n%100 == 0 {
+			length = 100
+		}
+	}
+	if p.era == "bc" {
+		return -(p.n + length - 1), -p.n
+	}
+	return p.n, p.n + length - 1
+}
+
+func isBC(era string) bool {
+	era = strings.ToUpper(strings.ReplaceAll(era, ".", ""))
+	return era == "BC" || era == "BCE"
+}
+
+// FindYear finds the first year expression in free text, such as a
+// description. To avoid taking other numbers for years, as in "killing 1517
+// people", a year needs an era ("AD 79", "44 BC") or four digits in a date:
+// after a preposition or month ("in 1969", "on July 20, 1969", "in the
+// 1950s"), marked approximate ("c. 1500") or as an ISO date ("1969-07-20").
+// It must not be in the future.
+func FindYear(text string) (Year, bool) {
+	for _, loc := range yearInText.FindAllStringIndex(text, -1) {
+		match := text[loc[0]:loc[1]]
+		y, ok := ParseYear(match)
+		if !ok {
+			// A date such as 1969-07-20 isn't a range, but starts with a year
+			match = leadingDigits(match)
+			if y, ok = ParseYear(match); !ok {
+				continue
+			}
+		}
+		if yearEra.MatchString(strings.ReplaceAll(match, ".", "")) {
+			return y, true
+		}
+		if len(leadingDigits(match)) == 4 && y.End <= time.Now().Year() &&
+			(y.Circa || yearContext.MatchString(text[:loc[0]]) || isoDate.MatchString(text[loc[0]:])) {
+			return y, true
+		}
+	}
+	return Year{}, false
+}
+
+// leadingDigits returns the first run of digits in s
+func leadingDigits(s string) string {
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return ""
+	}
+	end := start
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[start:end]
+}
+
+// FormatYear formats a sortable year for display: 1969 as "1969" and -44 as
+// "44 BC"
+func FormatYear(n int) string {
+	if n < 0 {
+		return strconv.Itoa(-n) + " BC"
+	}
+	return strconv.Itoa(n)
+}
+
+// SortYear returns the event's sortable year, parsing its Year if SortYear
+// isn't set. It reports false if the event has no year that can be parsed.
+func SortYear(event HistoricalEvent) (int, bool) {
+	if event.SortYear != 0 {
+		return event.SortYear, true
+	}
+	y, ok := ParseYear(event.Year)
+	if !ok {
+		return 0, false
+	}
+	return y.Sort(), true
+}
+
+// titleYearSeps separate a year from the rest of a title
+var titleYearSeps = []string{":", " – ", " — ", " - "}
+
+// splitTitleYear splits a leading year off a title such as "44 BC: Caesar is
+// assassinated" or "1914–1918 – World War I". It reports false, leaving the
+// title whole, if the title doesn't start with a year, as in "Note: ...".
+func splitTitleYear(title string) (Year, string, bool) {
+	var (
+		found Year
+		rest  string
+		ok    bool
+	)
+	// The longest prefix that is a year wins, so a range isn't cut short
+	for _, sep := range titleYearSeps {
+		for i := 0; i < len(title); {
+			j := strings.Index(title[i:], sep)
+			if j < 0 {
+				break
+			}
+			j += i
+			if y, parsed := ParseYear(title[:j]); parsed && (!ok || len(y.Text) > len(found.Text)) {
+				found, rest, ok = y, strings.TrimSpace(title[j+len(sep):]), true
+			}
+			i = j + len(sep)
+		}
+	}
+	return found, rest, ok
+}
+
+// pubDateFormats are the date formats RSS feeds use in pubDate
+var pubDateFormats = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", time.RFC3339, "2006-01-02",
+}
+
+// pubDateYear returns the year of an item's pubDate. A pubDate is usually
+// when the item was published, not when the event happened, so only years
+// before last year are taken as the event's.
+func pubDateYear(pubDate string) (Year, bool) {
+	pubDate = strings.TrimSpace(pubDate)
+	if pubDate == "" {
+		return Year{}, false
+	}
+	for _, format := range pubDateFormats {
+		t, err := time.Parse(format, pubDate)
+		if err != nil {
+			continue
+		}
+		if t.Year() >= time.Now().Year()-1 || t.Year() < 1 {
+			return Year{}, false
+		}
+		return Year{Text: strconv.Itoa(t.Year()), Start: t.Year(), End: t.Year()}, true
+	}
+	return Year{}, false
+}
@@ -0,0 +1,114 @@
+package rss
+
+import (
+	"testing"
+)
+
+func TestParseYear(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int
+		circa      bool
+	}{
+		{"1969", 1969, 1969, false},
+		{" 1969 ", 1969, 1969, false},
+		{"44 BC", -44, -44, false},
+		{"44 B.C.", -44, -44, false},
+		{"300 BCE", -300, -300, false},
+		{"AD 79", 79, 79, false},
+		{"79 CE", 79, 79, false},
+		{"c. 1500", 1500, 1500, true},
+		{"circa 1500", 1500, 1500, true},
+		{"ca. 800 BC", -800, -800, true},
+		{"c1500", 1500, 1500, true},
+		{"1960s", 1960, 1969, false},
+		{"1960's", 1960, 1969, false},
+		{"1800s", 1800, 1899, false},
+		{"5th century BC", -500, -401, false},
+		{"15th century", 1401, 1500, false},
+		{"1914–1918", 1914, 1918, false},
+		{"1914-18", 1914, 1918, false},
+		{"1914 to 1918", 1914, 1918, false},
+		{"44–43 BC", -44, -43, false},
+		{"27 BC – AD 14", -27, 14, false},
+		{"10,000 BC", -10000, -10000, false},
+	}
+	for _, tt := range tests {
+		y, ok := ParseYear(tt.in)
+		if !ok {
+			t.Errorf("ParseYear(%q) failed", tt.in)
+			continue
+		}
+		if y.Start != tt.start || y.End != tt.end || y.Circa != tt.circa {
+			t.Errorf("ParseYear(%q) = %+v, want %d to %d, circa %v", tt.in, y, tt.start, tt.end, tt.circa)
+		}
+	}
+
+	for _, bad := range []string{"", "Note", "0", "1965s", "1918–1914", "AD 44 BC", "c.", "Apollo 11", "1969 Apollo"} {
+		if y, ok := ParseYear(bad); ok {
+			t.Errorf("ParseYear(%q) = %+v, want it rejected", bad, y)
+		}
+	}
+}
+
+func TestFindYear(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+		ok   bool
+	}{
+		{"Apollo 11 lands on the Moon in 1969 with 3 astronauts.", 1969, true},
+		{"Vesuvius erupts in AD 79, burying Pompeii.", 79, true},
+		{"Julius Caesar is assassinated in 44 BC.", -44, true},
+		{"The mission launched on 1969-07-16.", 1969, true},
+		{"Rock and roll takes off in the 1950s.", 1950, true},
+		{"Signed on July 4, 1776, in Philadelphia.", 1776, true},
+		{"Printing spreads c. 1500 across Europe.", 1500, true},
+		{"An earthquake strikes, killing 1517 people in 1905.", 1905, true},
+		{"An earthquake strikes, killing 1517 people.", 0, false},
+		{"The ship carried 1200 passengers.", 0, false},
+		{"Over 300 soldiers and 11 ships were lost.", 0, false},
+		{"A forecast for 2999 is published.", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		y, ok := FindYear(tt.text)
+		if ok != tt.ok || (ok && y.Sort() != tt.want) {
+			t.Errorf("FindYear(%q) = %+v, %v; want %d, %v", tt.text, y, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSplitTitleYear(t *testing.T) {
+	tests := []struct {
+		title, year, rest string
+		ok                bool
+	}{
+		{"1969: Apollo 11 lands", "1969", "Apollo 11 lands", true},
+		{"44 BC: Caesar is assassinated", "44 BC", "Caesar is assassinated", true},
+		{"c. 1500: Printing spreads", "c. 1500", "Printing spreads", true},
+		{"1914 – 1918 – World War I", "1914 – 1918", "World War I", true},
+		{"1789 - Storming of the Bastille", "1789", "Storming of the Bastille", true},
+		{"Note: the feed is moving", "", "", false},
+		{"Apollo 11: the first landing", "", "", false},
+		{"Apollo 11 lands", "", "", false},
+	}
+	for _, tt := range tests {
+		y, rest, ok := splitTitleYear(tt.title)
+		if ok != tt.ok || (ok && (y.Text != tt.year || rest != tt.rest)) {
+			t.Errorf("splitTitleYear(%q) = %q, %q, %v; want %q, %q, %v", tt.title, y.Text, rest, ok, tt.year, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestFormatYear(t *testing.T) {
+	if got := FormatYear(1969); got != "1969" {
+		t.Errorf("FormatYear(1969) = %q", got)
+	}
+	if got := FormatYear(-44); got != "44 BC" {
+		t.Errorf("FormatYear(-44) = %q", got)
+	}
+	if y, ok := ParseYear(FormatYear(-44)); !ok || y.Sort() != -44 {
+		t.Errorf("ParseYear(FormatYear(-44)) = %+v, %v", y, ok)
+	}
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryPolicy controls same-day retries of a failed job run
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per run, including the first; 1 or less disables retries
+	Backoff     time.Duration // Delay between a failed attempt and the next one
+	Cutoff      time.Duration // Latest time of day (since midnight) a retry may start; zero means no cutoff
+}
+
+// NoRetry is the default policy: each run is attempted once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// nextAttempt returns when to retry after attempt number attempt failed at
+// failedAt, or the zero time if no further attempt should be made. The cutoff
+// applies to the day the run's first attempt started.
+func (p RetryPolicy) nextAttempt(attempt int, firstStart, failedAt time.Time) time.Time {
+	if attempt >= p.MaxAttempts {
+		return time.Time{}
+	}
+
+	next := failedAt.Add(p.Backoff)
+	if p.Cutoff > 0 {
+		year, month, day := firstStart.Date()
+		cutoff := time.Date(year, month, day, 0, 0, 0, 0, firstStart.Location()).Add(p.Cutoff)
+		if next.After(cutoff) {
+			return time.Time{}
+		}
+	}
+
+	return next
+}
+
+// ParseTimeOfDay parses a "HH:MM" time of day into an offset from midnight
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM): %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
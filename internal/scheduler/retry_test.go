@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyNextAttempt(t *testing.T) {
+	day := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	firstStart := day.Add(9 * time.Hour)
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		failedAt time.Time
+		want     time.Time
+	}{
+		{
+			name:     "No retries",
+			policy:   NoRetry,
+			attempt:  1,
+			failedAt: firstStart,
+		},
+		{
+			name:     "Retry after backoff",
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: 20 * time.Minute},
+			attempt:  1,
+			failedAt: firstStart,
+			want:     day.Add(9*time.Hour + 20*time.Minute),
+		},
+		{
+			name:     "Attempts exhausted",
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: 20 * time.Minute},
+			attempt:  3,
+			failedAt: firstStart.Add(time.Hour),
+		},
+		{
+			name:     "Retry before cutoff",
+			policy:   RetryPolicy{MaxAttempts: 5, Backoff: 30 * time.Minute, Cutoff: 11 * time.Hour},
+			attempt:  2,
+			failedAt: day.Add(10*time.Hour + 30*time.Minute),
+			want:     day.Add(11 * time.Hour),
+		},
+		{
+			name:     "Retry past cutoff",
+			policy:   RetryPolicy{MaxAttempts: 5, Backoff: 30 * time.Minute, Cutoff: 11 * time.Hour},
+			attempt:  2,
+			failedAt: day.Add(10*time.Hour + 45*time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.nextAttempt(tt.attempt, firstStart, tt.failedAt)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextAttempt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	got, err := ParseTimeOfDay("11:30")
+	if err != nil {
+		t.Fatalf("ParseTimeOfDay() returned error: %v", err)
+	}
+	if want := 11*time.Hour + 30*time.Minute; got != want {
+		t.Errorf("ParseTimeOfDay() = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"", "25:00", "11", "noon"} {
+		if _, err := ParseTimeOfDay(bad); err == nil {
+			t.Errorf("ParseTimeOfDay(%q) should return error", bad)
+		}
+	}
+}
+
+func TestSchedulerRetriesFailedRun(t *testing.T) {
+	calls := 0
+	job := func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("feed down")
+		}
+		return nil
+	}
+
+	scheduler := NewScheduler(job, 0, true)
+	scheduler.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	var results []RunResult
+	scheduler.OnResult(func(ctx context.Context, result RunResult) {
+		results = append(results, result)
+	})
+
+	if err := scheduler.Start(context.Background()); err != nil {
+		t.Errorf("Start() returned error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("job called %d times, want 3", calls)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if !results[0].Retrying || results[0].Attempt != 1 || results[0].MaxAttempts != 3 {
+		t.Errorf("first result = %+v, want a retrying attempt 1 of 3", results[0])
+	}
+	// Attempts of one run count as one failed run
+	if results[0].ConsecutiveFailures != 1 || results[1].ConsecutiveFailures != 1 {
+		t.Errorf("ConsecutiveFailures = %d, %d; want 1, 1", results[0].ConsecutiveFailures, results[1].ConsecutiveFailures)
+	}
+	if results[2].Err != nil || results[2].Retrying {
+		t.Errorf("last result = %+v, want success without retry", results[2])
+	}
+}
+
+func TestSchedulerGivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	job := func(ctx context.Context) error {
+		calls++
+		return errors.New("feed down")
+	}
+
+	scheduler := NewScheduler(job, 0, true)
+	scheduler.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+
+	if err := scheduler.Start(context.Background()); err == nil {
+		t.Error("Start() should return error")
+	}
+	if calls != 2 {
+		t.Errorf("job called %d times, want 2", calls)
+	}
+	if failures := scheduler.Status().ConsecutiveFailures; failures != 1 {
+		t.Errorf("ConsecutiveFailures = %d, want 1 for one failed run", failures)
+	}
+}
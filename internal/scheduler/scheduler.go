@@ -3,18 +3,43 @@ package scheduler
 import (
 	"context"
 	"fmt"
-	"log"
+	"sync"
 	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
 )
 
 // Job represents a scheduled job
 type Job func(ctx context.Context) error
 
+// RunResult describes the outcome of a single job attempt
+type RunResult struct {
+	RunID               string
+	Trigger             string // "once", "initial" or "scheduled"
+	Attempt             int    // 1 for the first attempt, 2 for the first retry, ...
+	MaxAttempts         int
+	Start               time.Time
+	End                 time.Time
+	Err                 error
+	ConsecutiveFailures int       // Failed runs in a row, counting this run if this attempt failed
+	NextRun             time.Time // Next retry or scheduled run; zero when none
+	Retrying            bool      // NextRun is a retry of this run
+}
+
+// ResultHandler is called after every job attempt
+type ResultHandler func(ctx context.Context, result RunResult)
+
 // Scheduler handles scheduling of jobs
 type Scheduler struct {
 	job      Job
 	interval time.Duration
 	runOnce  bool
+	retry    RetryPolicy
+
+	mu       sync.Mutex
+	status   Status
+	handlers []ResultHandler
 }
 
 // NewScheduler creates a new scheduler
@@ -23,60 +48,158 @@ func NewScheduler(job Job, interval time.Duration, runOnce bool) *Scheduler {
 		job:      job,
 		interval: interval,
 		runOnce:  runOnce,
+		retry:    NoRetry,
+		status:   Status{State: StateIdle},
+	}
+}
+
+// SetRetryPolicy configures same-day retries of failed runs
+func (s *Scheduler) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
 	}
+	s.retry = policy
+}
+
+// OnResult registers a handler to be called after every job attempt
+func (s *Scheduler) OnResult(handler ResultHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
 }
 
 // Start starts the scheduler
 func (s *Scheduler) Start(ctx context.Context) error {
-	log.Printf("Scheduler starting...")
+	logger := logging.Stage(ctx, "scheduler")
+	logger.Info("scheduler starting")
+	defer s.setState(StateStopped)
 
 	// If runOnce is true, execute immediately and return
 	if s.runOnce {
-		log.Printf("Running job once...")
-		if err := s.job(ctx); err != nil {
+		if err := s.runWithRetries(ctx, "once", time.Time{}); err != nil {
 			return fmt.Errorf("job failed: %w", err)
 		}
-		log.Printf("Job completed successfully")
 		return nil
 	}
 
 	// Otherwise, run on a schedule
-	log.Printf("Scheduling job to run every %v", s.interval)
-
-	// Run immediately on startup
-	log.Printf("Running initial job...")
-	if err := s.job(ctx); err != nil {
-		log.Printf("Initial job failed: %v", err)
-		// Continue with scheduling even if initial run fails
-	} else {
-		log.Printf("Initial job completed successfully")
-	}
+	logger.Info("scheduling job", "interval", s.interval, "max_attempts", s.retry.MaxAttempts)
 
 	// Create ticker for subsequent runs
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
+	nextRun := time.Now().Add(s.interval)
+	s.setNextRun(nextRun)
+
+	// Run immediately on startup; continue with scheduling even if it fails
+	s.runWithRetries(ctx, "initial", nextRun)
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Scheduler stopping...")
+			logger.Info("scheduler stopping")
 			return ctx.Err()
 		case <-ticker.C:
-			log.Printf("Running scheduled job...")
-			if err := s.job(ctx); err != nil {
-				log.Printf("Scheduled job failed: %v", err)
-				// Continue running even if job fails
-			} else {
-				log.Printf("Scheduled job completed successfully")
-			}
+			nextRun = nextRun.Add(s.interval)
+			s.setNextRun(nextRun)
+			// Continue running even if the job fails
+			s.runWithRetries(ctx, "scheduled", nextRun)
 		}
 	}
 }
 
+// runWithRetries runs the job, retrying failed attempts according to the
+// retry policy. nextScheduled is the following scheduled run (zero if none).
+// It returns the error of the last attempt. The attempts share a scheduled
+// run ID, so per-run budgets cover all of them.
+func (s *Scheduler) runWithRetries(ctx context.Context, trigger string, nextScheduled time.Time) error {
+	firstStart := time.Now()
+	ctx = logging.WithScheduledRun(ctx, logging.NewRunID())
+
+	for attempt := 1; ; attempt++ {
+		retryAt, err := s.runJob(ctx, trigger, attempt, firstStart, nextScheduled)
+		if err == nil || retryAt.IsZero() {
+			return err
+		}
+
+		s.setNextRun(retryAt)
+		timer := time.NewTimer(time.Until(retryAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if !nextScheduled.IsZero() {
+			s.setNextRun(nextScheduled)
+		}
+	}
+}
+
+// runJob executes a single attempt under a fresh run ID and records its
+// outcome. It returns when to retry, or the zero time if no retry is due.
+func (s *Scheduler) runJob(ctx context.Context, trigger string, attempt int, firstStart, nextScheduled time.Time) (time.Time, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	logger := logging.Stage(ctx, "scheduler").With("trigger", trigger, "attempt", attempt)
+	logger.Info("job starting")
+
+	start := time.Now()
+	s.recordStart(start)
+	err := s.job(ctx)
+	finished := time.Now()
+
+	var retryAt time.Time
+	if err != nil {
+		retryAt = s.retry.nextAttempt(attempt, firstStart, finished)
+	}
+	s.recordResult(finished, err, !retryAt.IsZero())
+	status := s.Status()
+
+	outcome := metrics.Result(err)
+	metrics.JobRuns.Inc(outcome)
+	metrics.JobDuration.Observe(finished.Sub(start).Seconds())
+	metrics.LastRunTimestamp.Set(float64(finished.Unix()), outcome)
+
+	if err != nil {
+		logger.Error("job failed", "duration", finished.Sub(start), "retry_at", retryAt, "error", err)
+	} else {
+		logger.Info("job completed", "duration", finished.Sub(start))
+	}
+
+	// A run being retried hasn't failed yet, but reports count it
+	failures := status.ConsecutiveFailures
+	if !retryAt.IsZero() {
+		failures++
+	}
+
+	result := RunResult{
+		RunID:               logging.RunID(ctx),
+		Trigger:             trigger,
+		Attempt:             attempt,
+		MaxAttempts:         s.retry.MaxAttempts,
+		Start:               start,
+		End:                 finished,
+		Err:                 err,
+		ConsecutiveFailures: failures,
+		NextRun:             nextScheduled,
+	}
+	if !retryAt.IsZero() {
+		result.NextRun = retryAt
+		result.Retrying = true
+	}
+
+	s.mu.Lock()
+	handlers := append([]ResultHandler(nil), s.handlers...)
+	s.mu.Unlock()
+	for _, handler := range handlers {
+		handler(ctx, result)
+	}
+
+	return retryAt, err
+}
+
 // StartAt starts the scheduler with an initial delay
 func (s *Scheduler) StartAt(ctx context.Context, firstRun time.Time) error {
-	log.Printf("Scheduler will start at %v", firstRun)
-
 	// Calculate delay until first run
 	delay := time.Until(firstRun)
 	if delay < 0 {
@@ -84,7 +207,9 @@ func (s *Scheduler) StartAt(ctx context.Context, firstRun time.Time) error {
 		delay = delay + 24*time.Hour
 	}
 
-	log.Printf("Waiting %v until first run...", delay)
+	logging.Stage(ctx, "scheduler").Info("waiting until first run", "first_run", firstRun, "delay", delay)
+	s.setState(StateWaiting)
+	s.setNextRun(time.Now().Add(delay))
 
 	// Wait for the first run time
 	timer := time.NewTimer(delay)
@@ -92,6 +217,7 @@ func (s *Scheduler) StartAt(ctx context.Context, firstRun time.Time) error {
 
 	select {
 	case <-ctx.Done():
+		s.setState(StateStopped)
 		return ctx.Err()
 	case <-timer.C:
 		// First run time reached, start the scheduler
@@ -99,6 +225,38 @@ func (s *Scheduler) StartAt(ctx context.Context, firstRun time.Time) error {
 	}
 }
 
+// RunDaily runs the job every day at the time given by cronExpr until ctx is
+// cancelled. Unlike a Scheduler it keeps no status, publishes no job metrics
+// and doesn't retry, so it suits background chores such as pre-generating
+// posts. Each run gets its own run ID.
+func RunDaily(ctx context.Context, cronExpr string, job Job) error {
+	logger := logging.Stage(ctx, "scheduler")
+
+	for {
+		next, err := NextRunTime(cronExpr)
+		if err != nil {
+			return err
+		}
+		logger.Info("waiting for daily job", "next_run", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		runCtx := logging.WithRunID(ctx, logging.NewRunID())
+		start := time.Now()
+		if err := job(runCtx); err != nil {
+			logging.Stage(runCtx, "scheduler").Error("daily job failed", "duration", time.Since(start), "error", err)
+		} else {
+			logging.Stage(runCtx, "scheduler").Info("daily job completed", "duration", time.Since(start))
+		}
+	}
+}
+
 // ParseCron parses a simple cron expression and returns the next run time
 // Format: "minute hour * * *" (e.g., "0 9 * * *" for 9:00 AM daily)
 // This is a simplified implementation. For full cron support, use github.com/robfig/cron
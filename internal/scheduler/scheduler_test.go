@@ -180,3 +180,30 @@ func TestDailyInterval(t *testing.T) {
 		t.Errorf("DailyInterval() = %v, want %v", interval, expected)
 	}
 }
+
+func TestSchedulerStatus(t *testing.T) {
+	job := func(ctx context.Context) error {
+		return errors.New("feed down")
+	}
+
+	scheduler := NewScheduler(job, 0, true)
+	if state := scheduler.Status().State; state != StateIdle {
+		t.Errorf("initial state = %q, want %q", state, StateIdle)
+	}
+
+	scheduler.Start(context.Background())
+
+	status := scheduler.Status()
+	if status.State != StateStopped {
+		t.Errorf("state = %q, want %q", status.State, StateStopped)
+	}
+	if status.LastError != "feed down" {
+		t.Errorf("LastError = %q, want %q", status.LastError, "feed down")
+	}
+	if status.ConsecutiveFailures != 1 {
+		t.Errorf("ConsecutiveFailures = %d, want 1", status.ConsecutiveFailures)
+	}
+	if status.LastRunEnd.IsZero() {
+		t.Error("LastRunEnd should be set")
+	}
+}
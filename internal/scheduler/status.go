@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/metrics"
+)
+
+// State describes what the scheduler is currently doing
+type State string
+
+const (
+	StateIdle    State = "idle"    // Created but not started
+	StateWaiting State = "waiting" // Waiting for the next run
+	StateRunning State = "running" // Job is executing
+	StateStopped State = "stopped" // Scheduler has returned
+)
+
+// Status is a snapshot of the scheduler's state and its most recent run
+type Status struct {
+	State               State     `json:"state"`
+	LastRunStart        time.Time `json:"last_run_start"`
+	LastRunEnd          time.Time `json:"last_run_end"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastSuccess         time.Time `json:"last_success"`
+	ConsecutiveFailures int       `json:"consecutive_failures"` // Failed runs in a row; a run fails once its last attempt does
+	NextRun             time.Time `json:"next_run"`
+}
+
+// Running reports how long the current job has been executing, or zero if idle
+func (st Status) Running(now time.Time) time.Duration {
+	if st.State != StateRunning {
+		return 0
+	}
+	return now.Sub(st.LastRunStart)
+}
+
+// Status returns a snapshot of the scheduler's current status
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Scheduler) setState(state State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.State = state
+}
+
+// setNextRun records and publishes the next scheduled run time
+func (s *Scheduler) setNextRun(t time.Time) {
+	s.mu.Lock()
+	s.status.NextRun = t
+	s.mu.Unlock()
+
+	metrics.NextRunTimestamp.Set(float64(t.Unix()))
+}
+
+func (s *Scheduler) recordStart(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.State = StateRunning
+	s.status.LastRunStart = start
+}
+
+// recordResult records an attempt's outcome. A failed attempt that will be
+// retried doesn't count as a failed run yet, so retries don't add up to
+// HEALTH_MAX_FAILURES within a single run.
+func (s *Scheduler) recordResult(finished time.Time, err error, retrying bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.State = StateWaiting
+	s.status.LastRunEnd = finished
+	if err != nil {
+		s.status.LastError = err.Error()
+		if !retrying {
+			s.status.ConsecutiveFailures++
+		}
+	} else {
+		s.status.LastError = ""
+		s.status.LastSuccess = finished
+		s.status.ConsecutiveFailures = 0
+	}
+}
@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+)
+
+// Discord limits for webhook messages. The total counts the titles,
+// descriptions and footers of all embeds together.
+const (
+	discordMaxEmbeds      = 10
+	discordMaxTitle       = 256
+	discordMaxDescription = 4096
+	discordMaxFooter      = 2048
+	discordMaxTotal       = 6000
+)
+
+// Discord posts embeds to a Discord webhook
+type Discord struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewDiscord creates a Discord sink
+func NewDiscord(webhookURL string) *Discord {
+	return &Discord{webhookURL: webhookURL, client: newHTTPClient()}
+}
+
+func (d *Discord) Name() string { return "discord" }
+
+type discordMessage struct {
+	Content         string                 `json:"content,omitempty"`
+	Embeds          []discordEmbed         `json:"embeds"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+// discordAllowedMentions says which mentions in a message notify anyone. An
+// empty Parse list notifies no one, so a feed's @everyone stays text.
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color,omitempty"`
+	Thumbnail   *discordImage  `json:"thumbnail,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// Embed colors for each section
+const (
+	discordHolidayColor = 0xF1C40F
+	discordEventColor   = 0x3498DB
+	discordPeopleColor  = 0x9B59B6
+)
+
+func (d *Discord) Send(ctx context.Context, digest Digest) error {
+	return postJSON(ctx, d.client, d.webhookURL, discordPayload(digest))
+}
+
+// discordPayload renders a digest as one embed per event, plus one each for
+// holidays, births and deaths
+func discordPayload(digest Digest) discordMessage {
+	var embeds []discordEmbed
+
+	if len(digest.Holidays) > 0 {
+		var lines []string
+		for _, holiday := range digest.Holidays {
+			line := "• **" + holiday.Title + "**"
+			if holiday.Quip != "" {
+				line += " — *" + holiday.Quip + "*"
+			}
+			lines = append(lines, line)
+		}
+		embeds = append(embeds, discordEmbed{
+			Title:       "Today's Fun Holidays",
+			Description: strings.Join(lines, "\n"),
+			Color:       discordHolidayColor,
+		})
+	}
+
+	for _, event := range digest.Events {
+		embed := discordEmbed{
+			Title:       event.Year + " — " + event.Title,
+			Description: event.Description,
+			Color:       discordEventColor,
+		}
+		if event.Category != "" {
+			embed.Footer = &discordFooter{Text: event.Category}
+		}
+		if event.ImageURL != "" {
+			embed.Thumbnail = &discordImage{URL: event.ImageURL}
+		}
+		embeds = append(embeds, embed)
+	}
+
+	for _, people := range []struct {
+		title  string
+		events []llm.SelectedEvent
+	}{
+		{"Born on this day", digest.Births},
+		{"Died on this day", digest.Deaths},
+		{"Also on this day", digest.Separated},
+	} {
+		if len(people.events) == 0 {
+			continue
+		}
+		var lines []string
+		for _, person := range people.events {
+			lines = append(lines, "• "+personLine(person, markdownBold))
+		}
+		embeds = append(embeds, discordEmbed{
+			Title:       people.title,
+			Description: strings.Join(lines, "\n"),
+			Color:       discordPeopleColor,
+		})
+	}
+
+	if len(embeds) > discordMaxEmbeds {
+		embeds = embeds[:discordMaxEmbeds]
+	}
+	embeds = fitEmbeds(embeds)
+
+	return discordMessage{
+		Content:         "**" + Title(digest) + "**",
+		Embeds:          embeds,
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}
+}
+
+// fitEmbeds truncates each embed's fields to Discord's limits, then shortens
+// the description that crosses the total limit and drops the embeds after it
+func fitEmbeds(embeds []discordEmbed) []discordEmbed {
+	remaining := discordMaxTotal
+	for i := range embeds {
+		embed := &embeds[i]
+		embed.Title = truncate(embed.Title, discordMaxTitle)
+		size := utf8.RuneCountInString(embed.Title)
+		if embed.Footer != nil {
+			embed.Footer.Text = truncate(embed.Footer.Text, discordMaxFooter)
+			size += utf8.RuneCountInString(embed.Footer.Text)
+		}
+		if size >= remaining {
+			return embeds[:i]
+		}
+		embed.Description = truncate(embed.Description, min(discordMaxDescription, remaining-size))
+		remaining -= size + utf8.RuneCountInString(embed.Description)
+	}
+	return embeds
+}
+
+// truncate shortens s to at most max runes, ending with an ellipsis if cut
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
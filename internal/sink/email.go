@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+)
+
+// EmailConfig configures the SMTP email sink
+type EmailConfig struct {
+	Addr     string // SMTP server host:port
+	Username string // Optional; PLAIN auth is used when set
+	Password string
+	From     string
+	To       []string
+}
+
+// emailTimeout bounds a whole SMTP session
+const emailTimeout = 30 * time.Second
+
+// Email sends a plain-text digest by SMTP. The server's STARTTLS is used when
+// offered; PLAIN auth requires TLS unless the server is on localhost.
+type Email struct {
+	cfg EmailConfig
+}
+
+// NewEmail creates an email sink
+func NewEmail(cfg EmailConfig) *Email {
+	return &Email{cfg: cfg}
+}
+
+func (e *Email) Name() string { return "email" }
+
+func (e *Email) Send(ctx context.Context, digest Digest) error {
+	if len(e.cfg.To) == 0 {
+		return fmt.Errorf("no email recipients")
+	}
+
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(e.cfg.Addr)
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, host)
+	}
+
+	return e.send(ctx, auth, emailMessage(e.cfg.From, e.cfg.To, digest))
+}
+
+// send delivers a message like smtp.SendMail, but bounded by the context
+// and a timeout so a stalled server can't hang the run
+func (e *Email) send(ctx context.Context, auth smtp.Auth, message []byte) error {
+	host, _, err := net.SplitHostPort(e.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %q: %w", e.cfg.Addr, err)
+	}
+
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	deadline := time.Now().Add(emailTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(e.cfg.From); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	for _, to := range e.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// emailMessage builds an RFC 5322 message with a UTF-8 plain-text body
+func emailMessage(from string, to []string, digest Digest) []byte {
+	var buf strings.Builder
+
+	headers := []struct{ name, value string }{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", Title(digest))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, h := range headers {
+		buf.WriteString(h.name + ": " + h.value + "\r\n")
+	}
+	buf.WriteString("\r\n")
+
+	// Plain text has no link markup: links are written out as "label (url)"
+	body := Title(digest) + "\n\n" + htmltext.PlainLinks(markdown(digest, ""))
+	// SMTP needs CRLF line endings; the smtp package's DATA writer escapes
+	// lines starting with "."
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return []byte(buf.String())
+}
@@ -0,0 +1,24 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+)
+
+// Mattermost posts a Markdown message to a Mattermost incoming webhook
+type Mattermost struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewMattermost creates a Mattermost sink
+func NewMattermost(webhookURL string) *Mattermost {
+	return &Mattermost{webhookURL: webhookURL, client: newHTTPClient()}
+}
+
+func (m *Mattermost) Name() string { return "mattermost" }
+
+func (m *Mattermost) Send(ctx context.Context, digest Digest) error {
+	text := neutralizeMentions("#### " + Title(digest) + "\n\n" + markdown(digest, "**"))
+	return postJSON(ctx, m.client, m.webhookURL, map[string]string{"text": text})
+}
@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/slack"
+)
+
+// Digest is the day's content as every sink sees it: selected events split
+// into events, births and deaths, plus holidays. It's the same content the
+// Slack renderers work from.
+type Digest = slack.Content
+
+// NewDigest builds a digest from the selected events and holidays
+func NewDigest(date time.Time, selected []llm.SelectedEvent, holidays []llm.SelectedHoliday) Digest {
+	return slack.NewContent(date, selected, holidays)
+}
+
+// Sink delivers a digest to a chat tool or mailbox
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, digest Digest) error
+}
+
+// Title is the heading every sink uses for a digest
+func Title(digest Digest) string {
+	return "On This Day in History - " + digest.Date.Format("Monday, January 2")
+}
+
+// newHTTPClient returns the client used by webhook sinks
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+	}
+}
+
+// postJSON posts a JSON payload to a webhook and fails on non-2xx responses
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// markdown renders a digest as CommonMark-style text, as used by Mattermost
+// and plain-text email. bold wraps strong text ("**" for Markdown, "" for
+// plain text).
+func markdown(digest Digest, bold string) string {
+	strong := func(s string) string { return bold + s + bold }
+
+	var sections []string
+
+	if len(digest.Holidays) > 0 {
+		lines := []string{strong("Today's Fun Holidays")}
+		for _, holiday := range digest.Holidays {
+			line := "- " + strong(holiday.Title)
+			if holiday.Quip != "" {
+				line += " — " + holiday.Quip
+			}
+			lines = append(lines, line)
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+
+	for _, event := range digest.Events {
+		header := event.Year
+		if event.Category != "" {
+			header += " • " + event.Category
+		}
+		sections = append(sections, fmt.Sprintf("%s\n%s\n%s", strong(header), strong(event.Title), event.Description))
+	}
+
+	for _, people := range []struct {
+		title  string
+		events []llm.SelectedEvent
+	}{
+		{"Born on this day", digest.Births},
+		{"Died on this day", digest.Deaths},
+		{"Also on this day", digest.Separated},
+	} {
+		if len(people.events) == 0 {
+			continue
+		}
+		lines := []string{strong(people.title)}
+		for _, person := range people.events {
+			lines = append(lines, "- "+personLine(person, strong))
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(sections, "\n\n")
+}
+
+// mention matches the start of an @-mention, such as @channel or @ada, but
+// not the @ of an email address or URL path, and a Teams <at> mention tag
+var mention = regexp.MustCompile(`(^|[^\w./+-])@(\w)|(?i)<(/?at)>`)
+
+// neutralizeMentions keeps text from notifying anyone by putting a word
+// joiner, which renders as nothing, between an @ and the name after it, or
+// inside a Teams <at> tag. Events and quips come from feeds and Claude, so
+// they might mention @channel or @all.
+func neutralizeMentions(s string) string {
+	return mention.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "<") {
+			return "<\u2060" + m[1:]
+		}
+		at := strings.Index(m, "@")
+		return m[:at+1] + "\u2060" + m[at+1:]
+	})
+}
+
+// markdownBold wraps text in Markdown strong emphasis
+func markdownBold(s string) string { return "**" + s + "**" }
+
+// personLine renders a birth or death as "Name (year) — description"
+func personLine(person llm.SelectedEvent, strong func(string) string) string {
+	line := strong(person.Title)
+	if person.Year != "" {
+		line += " (" + person.Year + ")"
+	}
+	if person.Description != "" {
+		line += " — " + person.Description
+	}
+	return line
+}
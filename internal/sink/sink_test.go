@@ -0,0 +1,302 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+func testDigest() Digest {
+	return NewDigest(time.Date(2024, time.November, 6, 9, 0, 0, 0, time.UTC),
+		[]llm.SelectedEvent{
+			{Year: "1860", Title: "Lincoln elected", Description: "Abraham Lincoln wins the presidency.", Category: "Politics", ImageURL: "https://example.com/lincoln.jpg"},
+			{Year: "1913", Title: "Gandhi arrested", Description: "Arrested leading a [march of miners](https://example.com/march).", Category: "Politics"},
+			{Kind: rss.KindBirth, Year: "1854", Title: "John Philip Sousa", Description: "Composer."},
+		},
+		[]llm.SelectedHoliday{{Title: "Nachos Day", Quip: "Cheese counts."}})
+}
+
+// webhookServer records the last JSON payload posted to it
+func webhookServer(t *testing.T, status int) (*httptest.Server, *map[string]any) {
+	t.Helper()
+	var payload map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("webhook body is not JSON: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &payload
+}
+
+func TestMattermost(t *testing.T) {
+	server, payload := webhookServer(t, http.StatusOK)
+
+	if err := NewMattermost(server.URL).Send(context.Background(), testDigest()); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	text, _ := (*payload)["text"].(string)
+	for _, want := range []string{
+		"#### On This Day in History - Wednesday, November 6",
+		"- **Nachos Day** — Cheese counts.",
+		"**1860 • Politics**\n**Lincoln elected**\nAbraham Lincoln wins the presidency.",
+		"**Born on this day**\n- **John Philip Sousa** (1854) — Composer.",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestTeams(t *testing.T) {
+	server, payload := webhookServer(t, http.StatusAccepted)
+
+	if err := NewTeams(server.URL).Send(context.Background(), testDigest()); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	raw, _ := json.Marshal(*payload)
+	var msg teamsMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("payload is not a Teams message: %v", err)
+	}
+	if msg.Type != "message" || len(msg.Attachments) != 1 {
+		t.Fatalf("message = %+v, want one attachment", msg)
+	}
+	card := msg.Attachments[0]
+	if card.ContentType != "application/vnd.microsoft.card.adaptive" || card.Content.Type != "AdaptiveCard" {
+		t.Errorf("attachment = %s / %s, want an Adaptive Card", card.ContentType, card.Content.Type)
+	}
+
+	text := string(raw)
+	for _, want := range []string{"On This Day in History - Wednesday, November 6", "Lincoln elected", "https://example.com/lincoln.jpg", "Born on this day", "**Nachos Day**"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("card missing %q", want)
+		}
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	server, payload := webhookServer(t, http.StatusNoContent)
+
+	if err := NewDiscord(server.URL).Send(context.Background(), testDigest()); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	raw, _ := json.Marshal(*payload)
+	var msg discordMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("payload is not a Discord message: %v", err)
+	}
+
+	// Holidays, two events, births
+	if len(msg.Embeds) != 4 {
+		t.Fatalf("len(Embeds) = %d, want 4: %+v", len(msg.Embeds), msg.Embeds)
+	}
+	lincoln := msg.Embeds[1]
+	if lincoln.Title != "1860 — Lincoln elected" || lincoln.Thumbnail == nil || lincoln.Footer == nil || lincoln.Footer.Text != "Politics" {
+		t.Errorf("event embed = %+v, want title, thumbnail and category footer", lincoln)
+	}
+	if msg.Embeds[2].Thumbnail != nil {
+		t.Error("event without image has a thumbnail")
+	}
+}
+
+func TestDiscordLimits(t *testing.T) {
+	var events []llm.SelectedEvent
+	for i := 0; i < 15; i++ {
+		events = append(events, llm.SelectedEvent{Year: "1900", Title: "Event", Description: "Short."})
+	}
+	if msg := discordPayload(NewDigest(time.Now(), events, nil)); len(msg.Embeds) != discordMaxEmbeds {
+		t.Errorf("len(Embeds) = %d, want %d", len(msg.Embeds), discordMaxEmbeds)
+	}
+
+	for i := range events {
+		events[i].Description = strings.Repeat("x", 5000)
+	}
+	msg := discordPayload(NewDigest(time.Now(), events, nil))
+	if n := len([]rune(msg.Embeds[0].Description)); n != discordMaxDescription {
+		t.Errorf("description length = %d, want %d", n, discordMaxDescription)
+	}
+
+	// The embeds' text together stays within the total limit
+	total := 0
+	for _, embed := range msg.Embeds {
+		total += len([]rune(embed.Title)) + len([]rune(embed.Description))
+	}
+	if len(msg.Embeds) != 2 || total != discordMaxTotal {
+		t.Errorf("%d embeds total %d characters, want 2 totalling %d", len(msg.Embeds), total, discordMaxTotal)
+	}
+
+	long := []llm.SelectedEvent{{Year: "1900", Title: strings.Repeat("y", 300), Description: "x"}}
+	if n := len([]rune(discordPayload(NewDigest(time.Now(), long, nil)).Embeds[0].Title)); n != discordMaxTitle {
+		t.Errorf("title length = %d, want %d", n, discordMaxTitle)
+	}
+}
+
+func TestMentionsNeutralized(t *testing.T) {
+	digest := NewDigest(time.Date(2024, time.November, 6, 9, 0, 0, 0, time.UTC),
+		[]llm.SelectedEvent{{Year: "1969", Title: "@channel Moon landing", Description: "Tell @all (and <at>Ada</at>) at ada@example.com or https://x.com/@nasa."}}, nil)
+
+	server, payload := webhookServer(t, http.StatusOK)
+	if err := NewMattermost(server.URL).Send(context.Background(), digest); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	text, _ := (*payload)["text"].(string)
+	for _, want := range []string{"@\u2060channel", "@\u2060all", "ada@example.com", "https://x.com/@nasa"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Mattermost text missing %q:\n%s", want, text)
+		}
+	}
+
+	card, _ := json.Marshal(teamsCard(digest))
+	for _, unwanted := range []string{"@channel", "@all", `\u003cat`} {
+		if strings.Contains(string(card), unwanted) {
+			t.Errorf("Teams card contains %q: %s", unwanted, card)
+		}
+	}
+
+	raw, _ := json.Marshal(discordPayload(digest))
+	if !strings.Contains(string(raw), `"allowed_mentions":{"parse":[]}`) {
+		t.Errorf("Discord payload = %s, want no mentions allowed", raw)
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server, _ := webhookServer(t, http.StatusBadRequest)
+
+	for _, s := range []Sink{NewMattermost(server.URL), NewTeams(server.URL), NewDiscord(server.URL)} {
+		if err := s.Send(context.Background(), testDigest()); err == nil {
+			t.Errorf("%s: Send() should return error for status 400", s.Name())
+		}
+	}
+}
+
+// smtpServer is a minimal SMTP stand-in that accepts one message
+type smtpServer struct {
+	addr string
+	from string
+	to   []string
+	data chan string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{addr: ln.Addr().String(), data: make(chan string, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		upper := strings.ToUpper(cmd)
+		switch {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			s.from = strings.Trim(strings.Fields(cmd[len("MAIL FROM:"):])[0], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(cmd[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case upper == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data <- data.String()
+			reply("250 OK")
+		case upper == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	server := newSMTPServer(t)
+
+	email := NewEmail(EmailConfig{
+		Addr: server.addr,
+		From: "bot@example.com",
+		To:   []string{"team@example.com", "ops@example.com"},
+	})
+	if err := email.Send(context.Background(), testDigest()); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	data := <-server.data
+	if server.from != "bot@example.com" || len(server.to) != 2 {
+		t.Errorf("envelope = %s -> %v, want bot@example.com to two recipients", server.from, server.to)
+	}
+	for _, want := range []string{
+		"Subject: On This Day in History - Wednesday, November 6",
+		"Content-Type: text/plain; charset=utf-8",
+		"- Nachos Day — Cheese counts.\r\n",
+		"1860 • Politics\r\nLincoln elected\r\n",
+		"- John Philip Sousa (1854) — Composer.",
+		"Arrested leading a march of miners (https://example.com/march).",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("email missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(data, "**") {
+		t.Errorf("plain-text email contains Markdown:\n%s", data)
+	}
+}
+
+func TestEmailNoRecipients(t *testing.T) {
+	if err := NewEmail(EmailConfig{Addr: "127.0.0.1:25"}).Send(context.Background(), testDigest()); err == nil {
+		t.Error("Send() should return error without recipients")
+	}
+}
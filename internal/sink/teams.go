@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+)
+
+// Teams posts an Adaptive Card to a Microsoft Teams incoming webhook or
+// Workflows webhook
+type Teams struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewTeams creates a Teams sink
+func NewTeams(webhookURL string) *Teams {
+	return &Teams{webhookURL: webhookURL, client: newHTTPClient()}
+}
+
+func (t *Teams) Name() string { return "teams" }
+
+// teamsMessage is the webhook envelope around an Adaptive Card
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Body    []cardElement  `json:"body"`
+	MSTeams map[string]any `json:"msteams,omitempty"`
+}
+
+// cardElement is the subset of Adaptive Card elements the digest uses:
+// TextBlock, Image and ColumnSet/Column
+type cardElement struct {
+	Type      string        `json:"type"`
+	Text      string        `json:"text,omitempty"`
+	Size      string        `json:"size,omitempty"`
+	Weight    string        `json:"weight,omitempty"`
+	IsSubtle  bool          `json:"isSubtle,omitempty"`
+	Wrap      bool          `json:"wrap,omitempty"`
+	Separator bool          `json:"separator,omitempty"`
+	URL       string        `json:"url,omitempty"`
+	AltText   string        `json:"altText,omitempty"`
+	Width     string        `json:"width,omitempty"`
+	Columns   []cardElement `json:"columns,omitempty"`
+	Items     []cardElement `json:"items,omitempty"`
+}
+
+func (t *Teams) Send(ctx context.Context, digest Digest) error {
+	return postJSON(ctx, t.client, t.webhookURL, teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content:     teamsCard(digest),
+		}},
+	})
+}
+
+// teamsCard renders a digest as an Adaptive Card
+func teamsCard(digest Digest) adaptiveCard {
+	body := []cardElement{
+		{Type: "TextBlock", Text: Title(digest), Size: "Large", Weight: "Bolder", Wrap: true},
+	}
+
+	if len(digest.Holidays) > 0 {
+		body = append(body, cardElement{Type: "TextBlock", Text: "Today's Fun Holidays", Weight: "Bolder", Separator: true})
+		for _, holiday := range digest.Holidays {
+			text := "- **" + holiday.Title + "**"
+			if holiday.Quip != "" {
+				text += " — _" + holiday.Quip + "_"
+			}
+			body = append(body, cardElement{Type: "TextBlock", Text: text, Wrap: true})
+		}
+	}
+
+	for _, event := range digest.Events {
+		header := event.Year
+		if event.Category != "" {
+			header += " • " + event.Category
+		}
+		items := []cardElement{
+			{Type: "TextBlock", Text: header, IsSubtle: true, Wrap: true},
+			{Type: "TextBlock", Text: event.Title, Weight: "Bolder", Wrap: true},
+			{Type: "TextBlock", Text: event.Description, Wrap: true},
+		}
+
+		if event.ImageURL == "" {
+			items[0].Separator = true
+			body = append(body, items...)
+			continue
+		}
+		body = append(body, cardElement{
+			Type:      "ColumnSet",
+			Separator: true,
+			Columns: []cardElement{
+				{Type: "Column", Width: "stretch", Items: items},
+				{Type: "Column", Width: "auto", Items: []cardElement{
+					{Type: "Image", URL: event.ImageURL, AltText: event.Title, Size: "Medium"},
+				}},
+			},
+		})
+	}
+
+	for _, people := range []struct {
+		title  string
+		events []llm.SelectedEvent
+	}{
+		{"Born on this day", digest.Births},
+		{"Died on this day", digest.Deaths},
+		{"Also on this day", digest.Separated},
+	} {
+		if len(people.events) == 0 {
+			continue
+		}
+		body = append(body, cardElement{Type: "TextBlock", Text: people.title, Weight: "Bolder", Separator: true})
+		for _, person := range people.events {
+			body = append(body, cardElement{Type: "TextBlock", Text: "- " + personLine(person, markdownBold), Wrap: true})
+		}
+	}
+
+	neutralizeCardMentions(body)
+	return adaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    body,
+		MSTeams: map[string]any{"width": "Full"},
+	}
+}
+
+// neutralizeCardMentions neutralizes mentions in the text of elements and
+// their columns and items
+func neutralizeCardMentions(elements []cardElement) {
+	for i := range elements {
+		elements[i].Text = neutralizeMentions(elements[i].Text)
+		neutralizeCardMentions(elements[i].Columns)
+		neutralizeCardMentions(elements[i].Items)
+	}
+}
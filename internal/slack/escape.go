@@ -0,0 +1,157 @@
+package slack
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Escape makes feed- or LLM-supplied text safe to interpolate into Slack
+// mrkdwn:
+//   - &, < and > are escaped (existing &amp;, &lt; and &gt; are kept)
+//   - special commands and mentions such as <!channel>, <!here>, <@U123> and
+//     <#C123> are shown as literal text instead of pinging anyone, and bare
+//     @channel, @here and @everyone are broken with a zero-width space
+//   - well-formed <http(s)://…|label> and <mailto:…> links are kept, with the
+//     URL and label re-escaped; links with other schemes become literal text
+//   - control and bidirectional-override characters are removed and line
+//     endings normalized
+//
+// Formatting characters such as * and _ are left alone.
+func Escape(s string) string {
+	s = strings.ToValidUTF8(s, "\ufffd")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.Map(stripUnsafeRune, s)
+
+	var buf strings.Builder
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '<')
+		if open < 0 {
+			buf.WriteString(escapeText(s))
+			break
+		}
+		buf.WriteString(escapeText(s[:open]))
+		s = s[open:]
+
+		if end := strings.IndexByte(s, '>'); end > 0 {
+			if link, ok := normalizeLink(s[1:end]); ok {
+				buf.WriteString(link)
+				s = s[end+1:]
+				continue
+			}
+		}
+
+		// Not a link: show the "<" literally and carry on after it
+		buf.WriteString("&lt;")
+		s = s[1:]
+	}
+
+	return buf.String()
+}
+
+// broadcastMentions are the bare mentions that notify a whole channel
+var broadcastMentions = []string{"@channel", "@here", "@everyone"}
+
+// escapeText escapes a run of plain text
+func escapeText(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			if hasEntityAt(s, i) {
+				buf.WriteByte(c)
+			} else {
+				buf.WriteString("&amp;")
+			}
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '@':
+			buf.WriteByte(c)
+			if isBroadcastMention(s[i:]) {
+				buf.WriteString("\u200b") // Zero-width space
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// hasEntityAt reports whether s has one of Slack's three entities at i
+func hasEntityAt(s string, i int) bool {
+	rest := s[i:]
+	return strings.HasPrefix(rest, "&amp;") || strings.HasPrefix(rest, "&lt;") || strings.HasPrefix(rest, "&gt;")
+}
+
+// isBroadcastMention reports whether s starts with a broadcast mention that
+// isn't just the start of a longer word (e.g. "@channels" is left alone)
+func isBroadcastMention(s string) bool {
+	lower := strings.ToLower(s)
+	for _, mention := range broadcastMentions {
+		if !strings.HasPrefix(lower, mention) {
+			continue
+		}
+		next := lower[len(mention):]
+		if next == "" {
+			return true
+		}
+		r := []rune(next)[0]
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLink rebuilds the inside of a <…> sequence if it's an http(s) or
+// mailto link, optionally with a |label
+func normalizeLink(inner string) (string, bool) {
+	target, label, hasLabel := strings.Cut(inner, "|")
+
+	// Undo any escaping so the URL is checked and re-escaped once
+	target = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">").Replace(target)
+	if target == "" || strings.ContainsAny(target, " \t\n<>|") {
+		return "", false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	link := "<" + strings.ReplaceAll(target, "&", "&amp;")
+	if hasLabel && strings.TrimSpace(label) != "" {
+		link += "|" + escapeText(label)
+	}
+	return link + ">", true
+}
+
+// stripUnsafeRune drops control characters (other than newlines and tabs)
+// and bidirectional overrides that could disguise text
+func stripUnsafeRune(r rune) rune {
+	switch {
+	case r == '\n' || r == '\t':
+		return r
+	case r == '\r':
+		return '\n'
+	case unicode.IsControl(r):
+		return -1
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		return -1
+	}
+	return r
+}
@@ -0,0 +1,171 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Apollo 11 lands", "Apollo 11 lands"},
+		{"special characters", "AT&T <3 > all", "AT&amp;T &lt;3 &gt; all"},
+		{"existing entities kept", "Tom &amp; Jerry &lt;3", "Tom &amp; Jerry &lt;3"},
+		{"channel command", "Hey <!channel> look", "Hey &lt;!channel&gt; look"},
+		{"user mention", "thanks <@U12345>", "thanks &lt;@U12345&gt;"},
+		{"channel link", "see <#C12345|general>", "see &lt;#C12345|general&gt;"},
+		{"bare broadcast mentions", "@channel @HERE @everyone!", "@\u200bchannel @\u200bHERE @\u200beveryone!"},
+		{"longer words left alone", "@channels @hereford", "@channels @hereford"},
+		{"labelled link kept", "<https://example.com/a?b=1&c=2|Tom & Jerry>", "<https://example.com/a?b=1&amp;c=2|Tom &amp; Jerry>"},
+		{"bare link kept", "<http://example.com>", "<http://example.com>"},
+		{"mailto kept", "<mailto:a@example.com|write>", "<mailto:a@example.com|write>"},
+		{"escaped link URL not double-escaped", "<https://x.io/?a=1&amp;b=2>", "<https://x.io/?a=1&amp;b=2>"},
+		{"javascript link", "<javascript:alert(1)|click>", "&lt;javascript:alert(1)|click&gt;"},
+		{"link label with mention", "<https://x.io|@channel>", "<https://x.io|@\u200bchannel>"},
+		{"unclosed bracket", "a < b and <https://x.io", "a &lt; b and &lt;https://x.io"},
+		{"control characters", "tab\tnew\r\nline\x00\x1b[31m", "tab\tnew\nline[31m"},
+		{"bidi override", "abc\u202edcba", "abcdcba"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Escape(tt.in); got != tt.want {
+				t.Errorf("Escape(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderersEscapeContent(t *testing.T) {
+	content := NewContent(time.Now(),
+		[]llm.SelectedEvent{{Year: "1969", Title: "<!channel> Moon & stars", Description: "<@U1> said hi", Category: "A&B"}},
+		[]llm.SelectedHoliday{{Title: "Pi <Day>", Quip: "@here 3.14"}})
+
+	renderer, _ := NewBlockKitRenderer(DefaultRenderOptions())
+	compact, _ := NewBlockKitRenderer(RenderOptions{Style: StyleCompact})
+	tmpl, err := ParseTemplate("test", "{{range .Events}}{{.Title}} {{.Description}} {{.Category}}{{end}}{{range .Holidays}}{{.Title}} {{.Quip}}{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, r := range map[string]Renderer{"verbose": renderer, "compact": compact, "template": tmpl} {
+		message, err := r.Render(content)
+		if err != nil {
+			t.Fatalf("%s: Render() returned error: %v", name, err)
+		}
+		text := allText(message)
+		for _, unsafe := range []string{"<!channel>", "<@U1>", "<Day>", " & ", "@here"} {
+			if strings.Contains(text, unsafe) {
+				t.Errorf("%s: rendered message contains %q:\n%s", name, unsafe, text)
+			}
+		}
+	}
+
+	message, _ := renderer.Render(NewContent(time.Now(), []llm.SelectedEvent{{Title: "Tom & Jerry", ImageURL: "https://x.io/a.png"}}, nil))
+	for _, block := range message.Blocks {
+		if block.Accessory != nil && block.Accessory.AltText != "Tom & Jerry" {
+			t.Errorf("alt text = %q, want the unescaped title", block.Accessory.AltText)
+		}
+	}
+}
+
+func TestRenderersConvertLinks(t *testing.T) {
+	content := NewContent(time.Now(),
+		[]llm.SelectedEvent{{Year: "1969", Title: "Moon landing", Description: "See [the <mission> page](https://example.com/a?b=1&c=2)."}}, nil)
+
+	renderer, _ := NewBlockKitRenderer(DefaultRenderOptions())
+	message, err := renderer.Render(content)
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+	if want := "See <https://example.com/a?b=1&amp;c=2|the ‹mission› page>."; !strings.Contains(allText(message), want) {
+		t.Errorf("rendered message doesn't contain %q:\n%s", want, allText(message))
+	}
+}
+
+func TestRenderersLinkMergedSources(t *testing.T) {
+	content := NewContent(time.Now(), []llm.SelectedEvent{{
+		Year: "1969", Title: "Moon landing", Description: "Armstrong walks on the Moon.",
+		Links: []string{"https://www.nasa.gov/apollo11", "https://en.wikipedia.org/wiki/Apollo_11"},
+	}}, nil)
+
+	renderer, _ := NewBlockKitRenderer(DefaultRenderOptions())
+	message, err := renderer.Render(content)
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+	if want := "Armstrong walks on the Moon.\nSources: <https://www.nasa.gov/apollo11|nasa.gov>, <https://en.wikipedia.org/wiki/Apollo_11|en.wikipedia.org>"; !strings.Contains(allText(message), want) {
+		t.Errorf("rendered message doesn't contain %q:\n%s", want, allText(message))
+	}
+}
+
+// allowedLink matches the only "<…>" sequences Escape may emit
+var allowedLink = regexp.MustCompile(`^<(https?://[^\s<>|]+|mailto:[^\s<>|]+)(\|[^<>]*)?>$`)
+
+func FuzzEscape(f *testing.F) {
+	for _, seed := range []string{
+		"", "plain", "a & b", "<!channel>", "<!here|here>", "<@U123>", "<#C1|x>",
+		"<https://example.com|Example>", "<https://x.io/?a=1&amp;b=2>", "<mailto:a@b.c>",
+		"<javascript:alert(1)>", "<<https://x.io>>", "@channel!", "x\x00y\u202ez", "\xff\xfe",
+		"&amp;lt;", "<https://x.io|<!channel>>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		out := Escape(in)
+
+		if !utf8.ValidString(out) {
+			t.Fatalf("Escape(%q) = %q is not valid UTF-8", in, out)
+		}
+		if again := Escape(out); again != out {
+			t.Fatalf("Escape is not idempotent: Escape(%q) = %q, Escape of that = %q", in, out, again)
+		}
+
+		for _, r := range out {
+			if (unicode.IsControl(r) && r != '\n' && r != '\t') || (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') {
+				t.Fatalf("Escape(%q) = %q contains unsafe rune %U", in, out, r)
+			}
+		}
+
+		// Every remaining "<" must open an allowed link
+		rest := out
+		for {
+			open := strings.IndexByte(rest, '<')
+			if open < 0 {
+				break
+			}
+			end := strings.IndexByte(rest[open:], '>')
+			if end < 0 {
+				t.Fatalf("Escape(%q) = %q has an unclosed <", in, out)
+			}
+			if link := rest[open : open+end+1]; !allowedLink.MatchString(link) {
+				t.Fatalf("Escape(%q) = %q contains disallowed sequence %q", in, out, link)
+			}
+			rest = rest[open+end+1:]
+		}
+
+		lower := strings.ToLower(out)
+		for _, mention := range broadcastMentions {
+			for i := strings.Index(lower, mention); i >= 0; {
+				if isBroadcastMention(lower[i:]) {
+					t.Fatalf("Escape(%q) = %q contains live mention %s", in, out, mention)
+				}
+				next := strings.Index(lower[i+1:], mention)
+				if next < 0 {
+					break
+				}
+				i += next + 1
+			}
+		}
+	})
+}
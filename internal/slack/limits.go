@@ -0,0 +1,222 @@
+package slack
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slack Block Kit and message limits
+const (
+	MaxBlocks       = 50    // Blocks per message
+	MaxSectionText  = 3000  // Characters in a section or context text
+	MaxHeaderText   = 150   // Characters in a header's plain text
+	MaxAltText      = 2000  // Characters in an image's alt text
+	MaxMessageText  = 40000 // Characters in a message's top-level text
+	MaxFallbackText = 3000  // Characters of fallback text generated for block messages
+	MaxInputText    = 3000  // Characters in a plain-text input, such as a modal's text box
+)
+
+// Fit makes a message valid for Slack. Over-long texts are truncated at a
+// safe point, blocks beyond MaxBlocks are split across as many messages as
+// needed (preferring to break at dividers), over-long text-only messages are
+// split at paragraph breaks, and every message gets a top-level text fallback
+// for notifications and screen readers.
+//
+// Incoming webhooks don't return a message timestamp, so continuation
+// messages are posted to the channel in order rather than as a thread.
+func Fit(message SlackMessage) []SlackMessage {
+	if len(message.Blocks) == 0 {
+		var messages []SlackMessage
+		for i, text := range splitText(message.Text, MaxMessageText) {
+			part := SlackMessage{Text: text}
+			if i == 0 {
+				part.Attachments = message.Attachments
+			}
+			messages = append(messages, part)
+		}
+		return messages
+	}
+
+	blocks := make([]Block, len(message.Blocks))
+	for i, block := range message.Blocks {
+		blocks[i] = fitBlock(block)
+	}
+
+	var messages []SlackMessage
+	for i, chunk := range splitBlocks(blocks) {
+		part := SlackMessage{Blocks: chunk}
+		if i == 0 {
+			part.Attachments = message.Attachments
+			part.Text = message.Text
+		}
+		if part.Text == "" {
+			part.Text = FallbackText(chunk)
+		}
+		part.Text = truncateText(part.Text, MaxMessageText)
+		messages = append(messages, part)
+	}
+	return messages
+}
+
+// FallbackText derives a plain summary of blocks for the message's text field
+func FallbackText(blocks []Block) string {
+	return truncateText(PlainText(blocks), MaxFallbackText)
+}
+
+// PlainText joins the texts of blocks, one per line, without truncating
+func PlainText(blocks []Block) string {
+	var lines []string
+	for _, block := range blocks {
+		switch {
+		case block.Text != nil:
+			lines = append(lines, block.Text.Text)
+		case block.Type == "context":
+			for _, element := range block.Elements {
+				lines = append(lines, element.Text)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fitBlock truncates a block's texts to Slack's limits
+func fitBlock(block Block) Block {
+	if block.Text != nil {
+		text := *block.Text
+		if block.Type == "header" {
+			text.Text = truncateText(text.Text, MaxHeaderText)
+		} else {
+			text.Text = truncateText(text.Text, MaxSectionText)
+		}
+		block.Text = &text
+	}
+
+	if len(block.Elements) > 0 {
+		elements := make([]TextObject, len(block.Elements))
+		for i, element := range block.Elements {
+			element.Text = truncateText(element.Text, MaxSectionText)
+			elements[i] = element
+		}
+		block.Elements = elements
+	}
+
+	if block.Accessory != nil {
+		accessory := *block.Accessory
+		accessory.AltText = truncateText(accessory.AltText, MaxAltText)
+		block.Accessory = &accessory
+	}
+
+	return block
+}
+
+// splitBlocks breaks blocks into chunks of at most MaxBlocks, cutting at the
+// last divider of a full chunk where there is one. Dividers at the edges of a
+// chunk are dropped.
+func splitBlocks(blocks []Block) [][]Block {
+	var chunks [][]Block
+	var current []Block
+
+	for _, block := range blocks {
+		if len(current) == MaxBlocks {
+			cut := len(current)
+			for i := len(current) - 1; i > 0; i-- {
+				if current[i].Type == "divider" {
+					cut = i
+					break
+				}
+			}
+			if chunk := trimDividers(current[:cut]); len(chunk) > 0 {
+				chunks = append(chunks, chunk)
+			}
+			current = append([]Block(nil), current[cut:]...)
+		}
+		current = append(current, block)
+	}
+	if chunk := trimDividers(current); len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// trimDividers drops dividers from the start and end of blocks
+func trimDividers(blocks []Block) []Block {
+	for len(blocks) > 0 && blocks[0].Type == "divider" {
+		blocks = blocks[1:]
+	}
+	for len(blocks) > 0 && blocks[len(blocks)-1].Type == "divider" {
+		blocks = blocks[:len(blocks)-1]
+	}
+	return blocks
+}
+
+// splitText breaks text into parts of at most max characters, preferring
+// paragraph breaks, then line breaks, then spaces
+func splitText(text string, max int) []string {
+	var parts []string
+	for {
+		runes := []rune(text)
+		if len(runes) <= max {
+			if strings.TrimSpace(text) != "" || len(parts) == 0 {
+				parts = append(parts, text)
+			}
+			return parts
+		}
+
+		window := string(runes[:max])
+		cut := strings.LastIndex(window, "\n\n")
+		if cut <= 0 {
+			cut = strings.LastIndex(window, "\n")
+		}
+		if cut <= 0 {
+			cut = strings.LastIndexFunc(window, unicode.IsSpace)
+		}
+		if cut <= 0 {
+			cut = len(window)
+		}
+
+		parts = append(parts, strings.TrimRightFunc(text[:cut], unicode.IsSpace))
+		text = strings.TrimLeftFunc(text[cut:], unicode.IsSpace)
+	}
+}
+
+// truncateText shortens text to at most max characters, ending with an
+// ellipsis. It never cuts a multi-byte character, an entity, or a
+// <url|text> link or mention in half, and prefers to cut at a space.
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+
+	cut := runes[:max-1]
+
+	// Don't leave a link or mention open: cut before its "<"
+	if open := lastIndexRune(cut, '<'); open >= 0 && open > lastIndexRune(cut, '>') {
+		cut = cut[:open]
+	}
+
+	// Don't leave an &amp;, &lt; or &gt; entity half-cut
+	if amp := lastIndexRune(cut, '&'); amp >= 0 && amp > lastIndexRune(cut, ';') && len(cut)-amp < len("&amp;") {
+		cut = cut[:amp]
+	}
+
+	// Prefer a word boundary if one is reasonably close to the end
+	for i := len(cut) - 1; i >= len(cut)*4/5 && i > 0; i-- {
+		if unicode.IsSpace(cut[i]) {
+			cut = cut[:i]
+			break
+		}
+	}
+
+	return strings.TrimRightFunc(string(cut), unicode.IsSpace) + "…"
+}
+
+func lastIndexRune(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,119 @@
+package slack
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"short text unchanged", "hello", 10, "hello"},
+		{"cuts at a space", "the quick brown fox jumps", 18, "the quick brown…"},
+		{"keeps multi-byte characters whole", "ééééééééé", 5, "éééé…"},
+		{"doesn't split a link", "see <https://example.com/a/long/path|the article>", 30, "see…"},
+		{"doesn't split an entity", "Tom &amp; Jerry", 8, "Tom…"},
+		{"keeps a closed link", "<https://x.io|x> and more words here", 24, "<https://x.io|x> and…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateText(tt.text, tt.max)
+			if got != tt.want {
+				t.Errorf("truncateText(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n > tt.max {
+				t.Errorf("truncateText() returned %d characters, want at most %d", n, tt.max)
+			}
+		})
+	}
+}
+
+func TestFitTruncatesLongTexts(t *testing.T) {
+	message := SlackMessage{Blocks: []Block{
+		{Type: "header", Text: &TextObject{Type: "plain_text", Text: strings.Repeat("h", 200)}},
+		{Type: "section", Text: &TextObject{Type: "mrkdwn", Text: strings.Repeat("word ", 1000)}},
+	}}
+	original := message.Blocks[1].Text.Text
+
+	parts := Fit(message)
+	if len(parts) != 1 {
+		t.Fatalf("len(Fit()) = %d, want 1", len(parts))
+	}
+	blocks := parts[0].Blocks
+	if n := utf8.RuneCountInString(blocks[0].Text.Text); n > MaxHeaderText {
+		t.Errorf("header length = %d, want at most %d", n, MaxHeaderText)
+	}
+	if n := utf8.RuneCountInString(blocks[1].Text.Text); n > MaxSectionText {
+		t.Errorf("section length = %d, want at most %d", n, MaxSectionText)
+	}
+	if message.Blocks[1].Text.Text != original {
+		t.Error("Fit() modified the original message")
+	}
+	if parts[0].Text == "" {
+		t.Error("Fit() didn't set a text fallback")
+	}
+}
+
+func TestFitSplitsManyBlocks(t *testing.T) {
+	var events []llm.SelectedEvent
+	for i := 0; i < 40; i++ {
+		events = append(events, llm.SelectedEvent{Year: "1900", Title: "Event", Description: "Description", Category: "Test"})
+	}
+	renderer, _ := NewBlockKitRenderer(DefaultRenderOptions())
+	message, _ := renderer.Render(NewContent(time.Now(), events, nil))
+
+	parts := Fit(message)
+	if len(parts) < 2 {
+		t.Fatalf("len(Fit()) = %d for %d blocks, want a split", len(parts), len(message.Blocks))
+	}
+
+	sections := 0
+	for i, part := range parts {
+		if len(part.Blocks) > MaxBlocks {
+			t.Errorf("part %d has %d blocks, want at most %d", i, len(part.Blocks), MaxBlocks)
+		}
+		if part.Text == "" {
+			t.Errorf("part %d has no text fallback", i)
+		}
+		first, last := part.Blocks[0], part.Blocks[len(part.Blocks)-1]
+		if first.Type == "divider" || last.Type == "divider" {
+			t.Errorf("part %d starts or ends with a divider", i)
+		}
+		for _, block := range part.Blocks {
+			if block.Type == "section" {
+				sections++
+			}
+		}
+	}
+	if sections != len(events) {
+		t.Errorf("sections across parts = %d, want %d", sections, len(events))
+	}
+	if parts[0].Blocks[0].Type != "header" {
+		t.Error("first part doesn't start with the header")
+	}
+	if last := parts[len(parts)-1].Blocks; last[len(last)-1].Type != "context" {
+		t.Error("last part doesn't end with the footer")
+	}
+}
+
+func TestFitSplitsLongTextMessages(t *testing.T) {
+	paragraph := strings.Repeat("x", 30000)
+	parts := Fit(SlackMessage{Text: paragraph + "\n\n" + paragraph})
+	if len(parts) != 2 {
+		t.Fatalf("len(Fit()) = %d, want 2", len(parts))
+	}
+	for i, part := range parts {
+		if part.Text != paragraph {
+			t.Errorf("part %d has %d characters, want one paragraph", i, len(part.Text))
+		}
+	}
+}
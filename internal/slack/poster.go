@@ -2,20 +2,35 @@ package slack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
 	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
 )
 
 // Poster handles posting messages to Slack
 type Poster struct {
 	webhookURL string
 	client     *http.Client
+
+	mu      sync.Mutex
+	partial *partialPost // Split message whose delivery failed part way
+}
+
+// partialPost records how many parts of a split message were posted before
+// a part failed, so posting the same message again sends only the rest
+type partialPost struct {
+	message []byte // The message as JSON
+	sent    int
 }
 
 // NewPoster creates a new Slack poster
@@ -37,9 +52,17 @@ type SlackMessage struct {
 
 // Block represents a Slack block
 type Block struct {
-	Type     string        `json:"type"`
-	Text     *TextObject   `json:"text,omitempty"`
-	Elements []TextObject  `json:"elements,omitempty"`
+	Type      string        `json:"type"`
+	Text      *TextObject   `json:"text,omitempty"`
+	Elements  []TextObject  `json:"elements,omitempty"`
+	Accessory *ImageElement `json:"accessory,omitempty"`
+}
+
+// ImageElement is an image shown beside a section's text
+type ImageElement struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
 }
 
 // TextObject represents a text object in Slack
@@ -55,24 +78,90 @@ type Attachment struct {
 }
 
 // PostEvents posts selected events to Slack
-func (p *Poster) PostEvents(events []llm.SelectedEvent) error {
-	return p.PostEventsWithHolidays(events, nil)
+func (p *Poster) PostEvents(ctx context.Context, events []llm.SelectedEvent) error {
+	return p.PostEventsWithHolidays(ctx, events, nil)
 }
 
 // PostEventsWithHolidays posts selected events and holidays to Slack
-func (p *Poster) PostEventsWithHolidays(events []llm.SelectedEvent, holidays []string) error {
+func (p *Poster) PostEventsWithHolidays(ctx context.Context, events []llm.SelectedEvent, holidays []llm.SelectedHoliday) error {
 	if len(events) == 0 && len(holidays) == 0 {
 		return fmt.Errorf("no events or holidays to post")
 	}
 
 	message := p.formatMessageWithHolidays(events, holidays)
 
+	return p.postMessage(ctx, message)
+}
+
+// PostMessage posts an already rendered message to Slack
+func (p *Poster) PostMessage(ctx context.Context, message SlackMessage) error {
+	return p.postMessage(ctx, message)
+}
+
+// postMessage fits a message to Slack's limits, sends the resulting part(s)
+// to the webhook in order and records the outcome. It stops at the first
+// failed part; posting the same message again, as a retry does, resumes
+// from that part instead of repeating the ones already posted.
+func (p *Poster) postMessage(ctx context.Context, message SlackMessage) error {
+	parts := Fit(message)
+	logger := logging.Stage(ctx, "slack")
+	if len(parts) > 1 {
+		logger.Info("message exceeds Slack limits, splitting", "parts", len(parts))
+	}
+
+	key, _ := json.Marshal(message)
+	first := p.resumeFrom(key)
+	if first > 0 {
+		logger.Info("resuming split message", "part", first+1, "parts", len(parts))
+	}
+
+	for i := first; i < len(parts); i++ {
+		part := parts[i]
+		start := time.Now()
+		err := p.send(ctx, part)
+		metrics.SlackPosts.Inc(metrics.Result(err))
+
+		if err != nil {
+			logger.Error("failed to post to Slack", "part", i+1, "parts", len(parts), "duration", time.Since(start), "error", err)
+			p.setPartial(key, i)
+			return err
+		}
+		logger.Info("posted to Slack", "part", i+1, "parts", len(parts), "blocks", len(part.Blocks), "duration", time.Since(start))
+	}
+	p.setPartial(nil, 0)
+	return nil
+}
+
+// resumeFrom returns the index of the first part of message still to post
+func (p *Poster) resumeFrom(message []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.partial == nil || !bytes.Equal(p.partial.message, message) {
+		return 0
+	}
+	return p.partial.sent
+}
+
+// setPartial records that the first sent parts of message were posted; a nil
+// message clears the record
+func (p *Poster) setPartial(message []byte, sent int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if message == nil || sent == 0 {
+		p.partial = nil
+		return
+	}
+	p.partial = &partialPost{message: message, sent: sent}
+}
+
+// send performs the webhook request for a single message
+func (p *Poster) send(ctx context.Context, message SlackMessage) error {
 	reqBody, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", p.webhookURL, bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.webhookURL, bytes.NewReader(reqBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -102,134 +191,26 @@ func (p *Poster) formatMessage(events []llm.SelectedEvent) SlackMessage {
 	return p.formatMessageWithHolidays(events, nil)
 }
 
-// formatMessageWithHolidays formats events and holidays into a Slack message with blocks
-func (p *Poster) formatMessageWithHolidays(events []llm.SelectedEvent, holidays []string) SlackMessage {
-	now := time.Now()
-	dateStr := now.Format("Monday, January 2")
-
-	// Create header block
-	blocks := []Block{
-		{
-			Type: "header",
-			Text: &TextObject{
-				Type: "plain_text",
-				Text: fmt.Sprintf("📅 On This Day in History - %s", dateStr),
-			},
-		},
-		{
-			Type: "divider",
-		},
-	}
-
-	// Add holidays section if present
-	if len(holidays) > 0 {
-		blocks = append(blocks, Block{
-			Type: "section",
-			Text: &TextObject{
-				Type: "mrkdwn",
-				Text: "*🎉 Today's Fun Holidays*",
-			},
-		})
-
-		// Add each holiday
-		holidayText := ""
-		for i, holiday := range holidays {
-			if i > 0 {
-				holidayText += "\n"
-			}
-			holidayText += fmt.Sprintf("• %s", holiday)
-		}
-
-		blocks = append(blocks, Block{
-			Type: "section",
-			Text: &TextObject{
-				Type: "mrkdwn",
-				Text: holidayText,
-			},
-		})
-
-		blocks = append(blocks, Block{
-			Type: "divider",
-		})
-	}
-
-	// Add each event as a section
-	for i, event := range events {
-		// Event header with year and category
-		header := fmt.Sprintf("*%s* • %s", event.Year, event.Category)
-
-		// Event title
-		titleText := fmt.Sprintf("*%s*", event.Title)
-
-		// Full event block
-		eventText := fmt.Sprintf("%s\n\n%s\n\n%s", header, titleText, event.Description)
-
-		blocks = append(blocks, Block{
-			Type: "section",
-			Text: &TextObject{
-				Type: "mrkdwn",
-				Text: eventText,
-			},
-		})
-
-		// Add divider between events (but not after the last one)
-		if i < len(events)-1 {
-			blocks = append(blocks, Block{
-				Type: "divider",
-			})
-		}
-	}
-
-	// Add footer
-	blocks = append(blocks, Block{
-		Type: "context",
-		Elements: []TextObject{
-			{
-				Type: "mrkdwn",
-				Text: "_Curated by AI from today's historical events_",
-			},
-		},
-	})
+// RenderMessage formats events and holidays into a Slack message without posting it
+func (p *Poster) RenderMessage(events []llm.SelectedEvent, holidays []llm.SelectedHoliday) SlackMessage {
+	return p.formatMessageWithHolidays(events, holidays)
+}
 
-	return SlackMessage{
-		Blocks: blocks,
-	}
+// formatMessageWithHolidays formats events and holidays into a Slack message
+// with blocks, using the default Block Kit look
+func (p *Poster) formatMessageWithHolidays(events []llm.SelectedEvent, holidays []llm.SelectedHoliday) SlackMessage {
+	renderer, _ := NewBlockKitRenderer(DefaultRenderOptions())
+	message, _ := renderer.Render(NewContent(time.Now(), events, holidays))
+	return message
 }
 
 // PostSimpleMessage posts a simple text message to Slack
-func (p *Poster) PostSimpleMessage(text string) error {
+func (p *Poster) PostSimpleMessage(ctx context.Context, text string) error {
 	message := SlackMessage{
 		Text: text,
 	}
 
-	reqBody, err := json.Marshal(message)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", p.webhookURL, bytes.NewReader(reqBody))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Slack API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil
+	return p.postMessage(ctx, message)
 }
 
 // FormatEventsAsText formats events as plain text (for testing or simple posts)
@@ -244,7 +225,7 @@ func FormatEventsAsText(events []llm.SelectedEvent) string {
 	for i, event := range events {
 		buf.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, event.Year, event.Title))
 		buf.WriteString(fmt.Sprintf("   Category: %s\n", event.Category))
-		buf.WriteString(fmt.Sprintf("   %s\n", event.Description))
+		buf.WriteString(fmt.Sprintf("   %s\n", htmltext.SlackLinks(event.Description)))
 		if i < len(events)-1 {
 			buf.WriteString("\n")
 		}
@@ -0,0 +1,48 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostMessageResumesSplitMessage(t *testing.T) {
+	// The second request, the first attempt at part two, fails
+	var received []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var part SlackMessage
+		json.NewDecoder(r.Body).Decode(&part)
+		received = append(received, part.Text[:1])
+	}))
+	defer server.Close()
+
+	message := SlackMessage{Text: strings.Repeat("a", 30000) + "\n\n" + strings.Repeat("b", 30000)}
+	poster := NewPoster(server.URL)
+
+	if err := poster.PostMessage(context.Background(), message); err == nil {
+		t.Fatal("PostMessage() should fail on the second part")
+	}
+	if err := poster.PostMessage(context.Background(), message); err != nil {
+		t.Fatalf("retry returned error: %v", err)
+	}
+	if strings.Join(received, "") != "ab" {
+		t.Errorf("parts received = %q, want each part once", received)
+	}
+
+	// A fully posted message is posted whole again
+	if err := poster.PostMessage(context.Background(), message); err != nil {
+		t.Fatalf("PostMessage() returned error: %v", err)
+	}
+	if strings.Join(received, "") != "abab" {
+		t.Errorf("parts received = %q, want the message posted again", received)
+	}
+}
@@ -0,0 +1,402 @@
+package slack
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/htmltext"
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// Content is everything a message is rendered from
+type Content struct {
+	Date     time.Time
+	Events   []llm.SelectedEvent // Selected events other than births and deaths
+	Births   []llm.SelectedEvent
+	Deaths   []llm.SelectedEvent
+	Holidays []llm.SelectedHoliday
+
+	// Separated holds events moderation asked to keep apart from the
+	// holidays; they're rendered last, under a plain heading
+	Separated []llm.SelectedEvent
+}
+
+// NewContent builds message content, splitting births, deaths and events
+// marked for separation out of the selected events. An event carried by
+// several sources links to them all at the end of its description.
+func NewContent(date time.Time, selected []llm.SelectedEvent, holidays []llm.SelectedHoliday) Content {
+	content := Content{Date: date, Holidays: holidays}
+	for _, event := range selected {
+		event.Description = withSources(event.Description, event.Links)
+		switch {
+		case event.Separate:
+			content.Separated = append(content.Separated, event)
+		case event.Kind == rss.KindBirth:
+			content.Births = append(content.Births, event)
+		case event.Kind == rss.KindDeath:
+			content.Deaths = append(content.Deaths, event)
+		default:
+			content.Events = append(content.Events, event)
+		}
+	}
+	return content
+}
+
+// withSources appends a "Sources:" line to description linking each of
+// links by its host, when there are several, as for an event merged from
+// several feeds
+func withSources(description string, links []string) string {
+	if len(links) < 2 {
+		return description
+	}
+	var sources []string
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		if source := htmltext.MarkdownLink(strings.TrimPrefix(u.Hostname(), "www."), link); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return description
+	}
+	line := "Sources: " + strings.Join(sources, ", ")
+	if description == "" {
+		return line
+	}
+	return description + "\n" + line
+}
+
+// Escaped returns a copy of the content with every feed- and LLM-supplied
+// text field made safe for mrkdwn; see Escape. Markdown links in
+// descriptions become Slack links.
+func (c Content) Escaped() Content {
+	escapeEvents := func(events []llm.SelectedEvent) []llm.SelectedEvent {
+		if events == nil {
+			return nil
+		}
+		escaped := make([]llm.SelectedEvent, len(events))
+		for i, event := range events {
+			event.Year = Escape(event.Year)
+			event.Title = Escape(event.Title)
+			event.Description = Escape(htmltext.SlackLinks(event.Description))
+			event.Category = Escape(event.Category)
+			escaped[i] = event
+		}
+		return escaped
+	}
+
+	escaped := c
+	escaped.Events = escapeEvents(c.Events)
+	escaped.Births = escapeEvents(c.Births)
+	escaped.Deaths = escapeEvents(c.Deaths)
+	escaped.Separated = escapeEvents(c.Separated)
+	if c.Holidays != nil {
+		escaped.Holidays = make([]llm.SelectedHoliday, len(c.Holidays))
+		for i, holiday := range c.Holidays {
+			holiday.Title = Escape(holiday.Title)
+			holiday.Quip = Escape(holiday.Quip)
+			escaped.Holidays[i] = holiday
+		}
+	}
+	return escaped
+}
+
+// Empty reports whether there is nothing to post
+func (c Content) Empty() bool {
+	return len(c.Events) == 0 && len(c.Births) == 0 && len(c.Deaths) == 0 && len(c.Holidays) == 0 && len(c.Separated) == 0
+}
+
+// Renderer turns content into a Slack message
+type Renderer interface {
+	Render(content Content) (SlackMessage, error)
+}
+
+// Layout styles for the Block Kit renderer
+const (
+	StyleVerbose = "verbose" // Header, one section per event with description and image, dividers
+	StyleCompact = "compact" // Header and one line per item, no descriptions or images
+)
+
+// DefaultFooter is the footer shown unless another is configured
+const DefaultFooter = "_Curated by AI from today's historical events_"
+
+// RenderOptions controls the look of the Block Kit renderer
+type RenderOptions struct {
+	Style  string // StyleVerbose or StyleCompact
+	Emoji  bool   // Prefix the header and section titles with emoji
+	Header string // Header text; the date is appended
+	Footer string // Footer mrkdwn; empty leaves the footer out
+}
+
+// DefaultRenderOptions returns the bot's standard look
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		Style:  StyleVerbose,
+		Emoji:  true,
+		Header: "On This Day in History",
+		Footer: DefaultFooter,
+	}
+}
+
+// BlockKitRenderer renders content as Block Kit blocks
+type BlockKitRenderer struct {
+	opts RenderOptions
+}
+
+// NewBlockKitRenderer creates a Block Kit renderer, rejecting unknown styles
+func NewBlockKitRenderer(opts RenderOptions) (*BlockKitRenderer, error) {
+	switch opts.Style {
+	case "":
+		opts.Style = StyleVerbose
+	case StyleVerbose, StyleCompact:
+	default:
+		return nil, fmt.Errorf("unknown render style %q (want %s or %s)", opts.Style, StyleVerbose, StyleCompact)
+	}
+	return &BlockKitRenderer{opts: opts}, nil
+}
+
+func (r *BlockKitRenderer) Render(content Content) (SlackMessage, error) {
+	blocks := []Block{
+		{
+			Type: "header",
+			Text: &TextObject{
+				Type: "plain_text",
+				Text: fmt.Sprintf("%s%s - %s", r.emoji("📅"), r.opts.Header, content.Date.Format("Monday, January 2")),
+			},
+		},
+	}
+
+	if r.opts.Style == StyleCompact {
+		blocks = append(blocks, r.compactSection(content.Escaped()))
+	} else {
+		blocks = append(blocks, Block{Type: "divider"})
+		blocks = append(blocks, r.verboseSections(content)...)
+	}
+
+	if r.opts.Footer != "" {
+		blocks = append(blocks, Block{
+			Type: "context",
+			Elements: []TextObject{
+				{
+					Type: "mrkdwn",
+					Text: r.opts.Footer,
+				},
+			},
+		})
+	}
+
+	return SlackMessage{Blocks: blocks}, nil
+}
+
+// verboseSections renders holidays, one section per event, then births,
+// deaths and separated events. Image alt text is plain text, so it's taken from the unescaped content.
+func (r *BlockKitRenderer) verboseSections(raw Content) []Block {
+	var blocks []Block
+	content := raw.Escaped()
+
+	// Add holidays section if present
+	if len(content.Holidays) > 0 {
+		blocks = append(blocks,
+			mrkdwnSection(fmt.Sprintf("*%sToday's Fun Holidays*", r.emoji("🎉"))),
+			mrkdwnSection(holidayLines(content.Holidays)),
+			Block{Type: "divider"},
+		)
+	}
+
+	// Add each event as a section
+	for i, event := range content.Events {
+		eventText := fmt.Sprintf("*%s* • %s\n\n*%s*\n\n%s", event.Year, event.Category, event.Title, event.Description)
+
+		block := mrkdwnSection(eventText)
+		if event.ImageURL != "" {
+			block.Accessory = &ImageElement{
+				Type:     "image",
+				ImageURL: event.ImageURL,
+				AltText:  raw.Events[i].Title,
+			}
+		}
+		blocks = append(blocks, block)
+
+		// Add divider between events (but not after the last one)
+		if i < len(content.Events)-1 {
+			blocks = append(blocks, Block{Type: "divider"})
+		}
+	}
+
+	// Births, deaths and separated events get their own sections after the
+	// main events
+	divider := len(content.Events) > 0
+	for _, people := range []struct {
+		title  string
+		events []llm.SelectedEvent
+	}{
+		{fmt.Sprintf("*%sBorn on this day*", r.emoji("🎂")), content.Births},
+		{fmt.Sprintf("*%sDied on this day*", r.emoji("🕯️")), content.Deaths},
+		{"*Also on this day*", content.Separated},
+	} {
+		if len(people.events) == 0 {
+			continue
+		}
+		if divider {
+			blocks = append(blocks, Block{Type: "divider"})
+		}
+		blocks = append(blocks, mrkdwnSection(people.title), mrkdwnSection(peopleLines(people.events)))
+		divider = true
+	}
+
+	return blocks
+}
+
+// compactSection renders everything as one section with a line per item
+func (r *BlockKitRenderer) compactSection(content Content) Block {
+	var parts []string
+
+	if len(content.Holidays) > 0 {
+		parts = append(parts, fmt.Sprintf("*%sToday's Fun Holidays*\n%s", r.emoji("🎉"), holidayLines(content.Holidays)))
+	}
+
+	if len(content.Events) > 0 {
+		var lines []string
+		for _, event := range content.Events {
+			lines = append(lines, fmt.Sprintf("• *%s* — %s", event.Year, event.Title))
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+
+	for _, people := range []struct {
+		title  string
+		events []llm.SelectedEvent
+	}{
+		{fmt.Sprintf("*%sBorn on this day:*", r.emoji("🎂")), content.Births},
+		{fmt.Sprintf("*%sDied on this day:*", r.emoji("🕯️")), content.Deaths},
+		{"*Also on this day:*", content.Separated},
+	} {
+		if len(people.events) == 0 {
+			continue
+		}
+		var names []string
+		for _, person := range people.events {
+			names = append(names, fmt.Sprintf("%s (%s)", person.Title, person.Year))
+		}
+		parts = append(parts, people.title+" "+strings.Join(names, ", "))
+	}
+
+	return mrkdwnSection(strings.Join(parts, "\n\n"))
+}
+
+// emoji returns the emoji followed by a space, or nothing for emoji-free output
+func (r *BlockKitRenderer) emoji(e string) string {
+	if !r.opts.Emoji {
+		return ""
+	}
+	return e + " "
+}
+
+// mrkdwnSection creates a section block with mrkdwn text
+func mrkdwnSection(text string) Block {
+	return Block{
+		Type: "section",
+		Text: &TextObject{
+			Type: "mrkdwn",
+			Text: text,
+		},
+	}
+}
+
+// holidayLines renders one bullet per holiday, with its quip if there is one
+func holidayLines(holidays []llm.SelectedHoliday) string {
+	lines := make([]string, 0, len(holidays))
+	for _, holiday := range holidays {
+		line := fmt.Sprintf("• *%s*", holiday.Title)
+		if holiday.Quip != "" {
+			line += fmt.Sprintf(" — _%s_", holiday.Quip)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// peopleLines renders one bullet per birth or death
+func peopleLines(people []llm.SelectedEvent) string {
+	lines := make([]string, 0, len(people))
+	for _, person := range people {
+		line := fmt.Sprintf("• *%s*", person.Title)
+		if person.Year != "" {
+			line += fmt.Sprintf(" (%s)", person.Year)
+		}
+		if person.Description != "" {
+			line += fmt.Sprintf(" — %s", person.Description)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TemplateRenderer renders content with a user-defined text/template. The
+// template's output is posted as the message text, which Slack formats as
+// mrkdwn. The template sees an escaped Content value: .Date, .Events,
+// .Births, .Deaths, .Holidays and .Separated.
+type TemplateRenderer struct {
+	tmpl *template.Template
+}
+
+// LoadTemplate reads and parses a template file, then executes it against
+// sample content so mistakes like misspelled fields are caught at startup
+// rather than at posting time
+func LoadTemplate(path string) (*TemplateRenderer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	return ParseTemplate(path, string(data))
+}
+
+// ParseTemplate parses and checks a template; see LoadTemplate
+func ParseTemplate(name, text string) (*TemplateRenderer, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	r := &TemplateRenderer{tmpl: tmpl}
+	if _, err := r.Render(sampleContent()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TemplateRenderer) Render(content Content) (SlackMessage, error) {
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, content.Escaped()); err != nil {
+		return SlackMessage{}, fmt.Errorf("failed to render template: %w", err)
+	}
+
+	text := strings.TrimSpace(buf.String())
+	if text == "" {
+		return SlackMessage{}, fmt.Errorf("template rendered an empty message")
+	}
+	return SlackMessage{Text: text}, nil
+}
+
+// sampleContent exercises every field a template can use
+func sampleContent() Content {
+	return NewContent(time.Date(1969, time.July, 20, 9, 0, 0, 0, time.UTC),
+		[]llm.SelectedEvent{
+			{Kind: rss.KindEvent, Year: "1969", Title: "Apollo 11 lands on the Moon", Description: "Neil Armstrong and Buzz Aldrin walk on the Moon.", Category: "Science", ImageURL: "https://example.com/apollo.jpg"},
+			{Kind: rss.KindBirth, Year: "1938", Title: "Natalie Wood", Description: "American actress.", Category: "Arts"},
+			{Kind: rss.KindDeath, Year: "1973", Title: "Bruce Lee", Description: "Martial artist and actor.", Category: "Arts"},
+			{Kind: rss.KindEvent, Year: "1944", Title: "Plot against Hitler fails", Description: "An attempt on Hitler's life fails.", Category: "Politics", Flags: []string{"violence", "politics"}, Separate: true},
+		},
+		[]llm.SelectedHoliday{
+			{Title: "International Chess Day", Quip: "Checkmate, Monday.", Link: "https://example.com/chess"},
+		})
+}
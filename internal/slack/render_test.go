@@ -0,0 +1,189 @@
+package slack
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/llm"
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+func testContent() Content {
+	return NewContent(time.Date(2024, time.November, 6, 9, 0, 0, 0, time.UTC),
+		[]llm.SelectedEvent{
+			{Year: "1860", Title: "Lincoln elected", Description: "Abraham Lincoln wins the presidency.", Category: "Politics", ImageURL: "https://example.com/lincoln.jpg"},
+			{Kind: rss.KindBirth, Year: "1854", Title: "John Philip Sousa", Description: "Composer."},
+		},
+		[]llm.SelectedHoliday{{Title: "Nachos Day", Quip: "Cheese counts."}})
+}
+
+// allText joins every text in a message, for substring checks
+func allText(message SlackMessage) string {
+	var parts []string
+	parts = append(parts, message.Text)
+	for _, block := range message.Blocks {
+		if block.Text != nil {
+			parts = append(parts, block.Text.Text)
+		}
+		for _, element := range block.Elements {
+			parts = append(parts, element.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func TestNewContent(t *testing.T) {
+	content := testContent()
+	if len(content.Events) != 1 || len(content.Births) != 1 || len(content.Deaths) != 0 {
+		t.Errorf("content = %+v, want 1 event and 1 birth", content)
+	}
+	if content.Empty() {
+		t.Error("Empty() = true, want false")
+	}
+	if !NewContent(time.Now(), nil, nil).Empty() {
+		t.Error("Empty() = false for no content, want true")
+	}
+}
+
+func TestSeparatedEventsRenderLast(t *testing.T) {
+	content := NewContent(time.Now(),
+		[]llm.SelectedEvent{
+			{Year: "1815", Title: "Battle of Waterloo", Separate: true},
+			{Year: "1969", Title: "Moon landing"},
+		},
+		[]llm.SelectedHoliday{{Title: "Donut Day"}})
+	if len(content.Events) != 1 || len(content.Separated) != 1 {
+		t.Fatalf("content = %+v, want 1 event and 1 separated", content)
+	}
+
+	for _, style := range []string{StyleVerbose, StyleCompact} {
+		renderer, _ := NewBlockKitRenderer(RenderOptions{Style: style})
+		message, err := renderer.Render(content)
+		if err != nil {
+			t.Fatalf("Render() returned error: %v", err)
+		}
+
+		text := allText(message)
+		holiday := strings.Index(text, "Donut Day")
+		heading := strings.Index(text, "Also on this day")
+		battle := strings.Index(text, "Battle of Waterloo")
+		if holiday < 0 || heading < holiday || battle < heading || battle < strings.Index(text, "Moon landing") {
+			t.Errorf("%s: want separated events last, under their own heading:\n%s", style, text)
+		}
+	}
+}
+
+func TestBlockKitRendererVerbose(t *testing.T) {
+	renderer, err := NewBlockKitRenderer(DefaultRenderOptions())
+	if err != nil {
+		t.Fatalf("NewBlockKitRenderer() returned error: %v", err)
+	}
+
+	message, err := renderer.Render(testContent())
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	text := allText(message)
+	for _, want := range []string{
+		"📅 On This Day in History - Wednesday, November 6",
+		"🎉 Today's Fun Holidays",
+		"• *Nachos Day* — _Cheese counts._",
+		"*Lincoln elected*",
+		"🎂 Born on this day",
+		"• *John Philip Sousa* (1854) — Composer.",
+		DefaultFooter,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("rendered message missing %q:\n%s", want, text)
+		}
+	}
+
+	var accessory *ImageElement
+	for _, block := range message.Blocks {
+		if block.Accessory != nil {
+			accessory = block.Accessory
+		}
+	}
+	if accessory == nil || accessory.ImageURL != "https://example.com/lincoln.jpg" || accessory.AltText != "Lincoln elected" {
+		t.Errorf("image accessory = %+v, want the event's image with its title as alt text", accessory)
+	}
+}
+
+func TestBlockKitRendererCompactWithoutEmoji(t *testing.T) {
+	renderer, err := NewBlockKitRenderer(RenderOptions{
+		Style:  StyleCompact,
+		Header: "Today",
+		Footer: "",
+	})
+	if err != nil {
+		t.Fatalf("NewBlockKitRenderer() returned error: %v", err)
+	}
+
+	message, err := renderer.Render(testContent())
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if len(message.Blocks) != 2 {
+		t.Errorf("len(Blocks) = %d, want header and one section", len(message.Blocks))
+	}
+	text := allText(message)
+	for _, want := range []string{"Today - Wednesday, November 6", "• *1860* — Lincoln elected", "*Born on this day:* John Philip Sousa (1854)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("rendered message missing %q:\n%s", want, text)
+		}
+	}
+	for _, unwanted := range []string{"📅", "🎉", "🎂", "Abraham Lincoln wins", DefaultFooter} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("compact emoji-free message contains %q:\n%s", unwanted, text)
+		}
+	}
+}
+
+func TestNewBlockKitRendererUnknownStyle(t *testing.T) {
+	if _, err := NewBlockKitRenderer(RenderOptions{Style: "fancy"}); err == nil {
+		t.Error("NewBlockKitRenderer() should reject unknown styles")
+	}
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	renderer, err := ParseTemplate("test", `*{{.Date.Format "January 2"}}*
+{{range .Events}}• {{.Year}}: {{.Title}}
+{{end}}{{range .Births}}🎂 {{.Title}}
+{{end}}{{range .Holidays}}🎉 {{.Title}}
+{{end}}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate() returned error: %v", err)
+	}
+
+	message, err := renderer.Render(testContent())
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	want := "*November 6*\n• 1860: Lincoln elected\n🎂 John Philip Sousa\n🎉 Nachos Day"
+	if message.Text != want {
+		t.Errorf("Text = %q, want %q", message.Text, want)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"syntax error", "{{range .Events}}"},
+		{"unknown field", "{{range .Events}}{{.Headline}}{{end}}"},
+		{"empty output", "{{/* nothing */}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTemplate("test", tt.template); err == nil {
+				t.Error("ParseTemplate() should return error")
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+	"github.com/dpeterka/history-slackbot/internal/metrics"
+)
+
+// ErrBudgetExceeded is returned by Tracker.Check once a budget is spent
+var ErrBudgetExceeded = errors.New("Claude budget exceeded")
+
+// ErrLimitReached is returned by Tracker.Check once the month's hard limit is
+// spent. It wraps ErrBudgetExceeded, but unlike a budget it can't be carried
+// on past with a cheaper model.
+var ErrLimitReached = fmt.Errorf("%w: monthly limit reached", ErrBudgetExceeded)
+
+// Tokens counts the tokens of one or more Claude API calls
+type Tokens struct {
+	Input      int `json:"input"`
+	Output     int `json:"output"`
+	CacheWrite int `json:"cache_write,omitempty"`
+	CacheRead  int `json:"cache_read,omitempty"`
+}
+
+// Add returns the sum of t and o
+func (t Tokens) Add(o Tokens) Tokens {
+	return Tokens{
+		Input:      t.Input + o.Input,
+		Output:     t.Output + o.Output,
+		CacheWrite: t.CacheWrite + o.CacheWrite,
+		CacheRead:  t.CacheRead + o.CacheRead,
+	}
+}
+
+// Price is what a model costs, in US dollars per million tokens
+type Price struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64
+	CacheRead  float64
+}
+
+// Cost returns the cost of tokens in US dollars
+func (p Price) Cost(t Tokens) float64 {
+	return (float64(t.Input)*p.Input +
+		float64(t.Output)*p.Output +
+		float64(t.CacheWrite)*p.CacheWrite +
+		float64(t.CacheRead)*p.CacheRead) / 1e6
+}
+
+// PriceTable maps model names to prices. A model matches its own entry or,
+// failing that, the longest entry it starts with, so dated model versions
+// such as claude-sonnet-4-5-20250929 use the claude-sonnet-4-5 price.
+type PriceTable map[string]Price
+
+// listPrice derives the prompt caching prices from the input price: cache
+// writes cost 1.25x and cache reads 0.1x
+func listPrice(input, output float64) Price {
+	return Price{Input: input, Output: output, CacheWrite: input * 1.25, CacheRead: input * 0.1}
+}
+
+// DefaultPrices are Anthropic's list prices; override them with ParsePrices
+var DefaultPrices = PriceTable{
+	"claude-opus-4-5":   listPrice(5, 25),
+	"claude-opus-4-1":   listPrice(15, 75),
+	"claude-opus-4":     listPrice(15, 75),
+	"claude-sonnet-4-5": listPrice(3, 15),
+	"claude-sonnet-4":   listPrice(3, 15),
+	"claude-haiku-4-5":  listPrice(1, 5),
+	"claude-3-5-haiku":  listPrice(0.8, 4),
+	"claude-3-haiku":    listPrice(0.25, 1.25),
+}
+
+// ParsePrices parses a price table such as
+// "claude-sonnet-4-5=3/15,my-model=1/5/1.25/0.1": each model's input and
+// output prices, optionally followed by its cache write and read prices,
+// in US dollars per million tokens. Without cache prices they're derived from
+// the input price.
+func ParsePrices(s string) (PriceTable, error) {
+	table := make(PriceTable)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(part, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			return nil, fmt.Errorf("invalid price entry %q (want model=input/output)", part)
+		}
+
+		var values []float64
+		for _, field := range strings.Split(prices, "/") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid price %q for %s", field, model)
+			}
+			values = append(values, v)
+		}
+
+		switch len(values) {
+		case 2:
+			table[model] = listPrice(values[0], values[1])
+		case 4:
+			table[model] = Price{Input: values[0], Output: values[1], CacheWrite: values[2], CacheRead: values[3]}
+		default:
+			return nil, fmt.Errorf("invalid prices %q for %s (want input/output or input/output/cache_write/cache_read)", prices, model)
+		}
+	}
+	return table, nil
+}
+
+// Merge returns a copy of t with the entries of override added or replaced
+func (t PriceTable) Merge(override PriceTable) PriceTable {
+	merged := make(PriceTable, len(t)+len(override))
+	for model, price := range t {
+		merged[model] = price
+	}
+	for model, price := range override {
+		merged[model] = price
+	}
+	return merged
+}
+
+// Lookup returns the price of model
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		if strings.HasPrefix(model, name) {
+			return t[name], true
+		}
+	}
+	return Price{}, false
+}
+
+// Totals sums the usage of several calls
+type Totals struct {
+	Calls  int
+	Tokens Tokens
+	Cost   float64 // US dollars
+}
+
+func (t *Totals) add(tokens Tokens, cost float64) {
+	t.Calls++
+	t.Tokens = t.Tokens.Add(tokens)
+	t.Cost += cost
+}
+
+// Budget caps spending in US dollars; zero means no cap
+type Budget struct {
+	Run   float64 // Per scheduled run, across all of its destinations and retries
+	Month float64 // Per calendar month
+	Limit float64 // Per calendar month, for every model including the budget model
+}
+
+// record is the data of a ledger.TypeUsage record
+type record struct {
+	Model  string  `json:"model"`
+	Tokens Tokens  `json:"tokens"`
+	Cost   float64 `json:"cost_usd"`
+}
+
+// Tracker accounts for Claude usage per run and per month and checks it
+// against a budget. Runs are told apart by the scheduled run ID in the
+// context, so a run's retries share its totals. With a
+// ledger every call is recorded there, and the month's spending so far is
+// read back at startup. It's safe for concurrent use.
+type Tracker struct {
+	prices PriceTable
+	budget Budget
+	ledger *ledger.Ledger
+	now    func() time.Time
+
+	mu    sync.Mutex
+	month string // Month being totalled, as 2006-01
+	total Totals
+	runs  map[string]*Totals
+}
+
+// NewTracker creates a tracker. l may be nil.
+func NewTracker(prices PriceTable, budget Budget, l *ledger.Ledger) (*Tracker, error) {
+	return newTracker(prices, budget, l, time.Now)
+}
+
+func newTracker(prices PriceTable, budget Budget, l *ledger.Ledger, now func() time.Time) (*Tracker, error) {
+	t := &Tracker{
+		prices: prices,
+		budget: budget,
+		ledger: l,
+		now:    now,
+		month:  now().Format("2006-01"),
+		runs:   make(map[string]*Totals),
+	}
+
+	if l != nil {
+		records, err := l.Records(ledger.TypeUsage)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			var data record
+			if json.Unmarshal(r.Data, &data) != nil || r.Time.In(now().Location()).Format("2006-01") != t.month {
+				continue
+			}
+			t.total.add(data.Tokens, data.Cost)
+		}
+	}
+	metrics.ClaudeMonthCost.Set(t.total.Cost)
+
+	return t, nil
+}
+
+// rollover starts a new month's totals when the month has changed. The
+// caller must hold t.mu.
+func (t *Tracker) rollover() {
+	if month := t.now().Format("2006-01"); month != t.month {
+		t.month = month
+		t.total = Totals{}
+		t.runs = make(map[string]*Totals)
+	}
+}
+
+// Record adds a call's usage to the totals of the scheduled run in ctx and
+// the month, and writes it to the ledger
+func (t *Tracker) Record(ctx context.Context, model string, tokens Tokens) {
+	logger := logging.Stage(ctx, "usage")
+
+	price, ok := t.prices.Lookup(model)
+	if !ok {
+		logger.Warn("no price for model, cost not counted", "model", model)
+	}
+	cost := price.Cost(tokens)
+	scheduledRun := logging.ScheduledRun(ctx)
+
+	t.mu.Lock()
+	t.rollover()
+	run := t.runs[scheduledRun]
+	if run == nil {
+		run = &Totals{}
+		t.runs[scheduledRun] = run
+	}
+	run.add(tokens, cost)
+	t.total.add(tokens, cost)
+	runTotals, monthTotals := *run, t.total
+	t.mu.Unlock()
+
+	metrics.ClaudeCost.Add(cost, model)
+	metrics.ClaudeMonthCost.Set(monthTotals.Cost)
+	logger.Info("recorded Claude usage", "model", model, "cost_usd", cost,
+		"run_calls", runTotals.Calls, "run_cost_usd", runTotals.Cost, "month_cost_usd", monthTotals.Cost)
+
+	if t.ledger != nil {
+		if err := t.ledger.Append(logging.RunID(ctx), "", ledger.TypeUsage, record{Model: model, Tokens: tokens, Cost: cost}); err != nil {
+			logger.Warn("failed to record usage in ledger", "error", err)
+		}
+	}
+}
+
+// Check returns an error wrapping ErrBudgetExceeded if the scheduled run in
+// ctx or the month has spent its budget, or ErrLimitReached if the month has
+// spent its hard limit
+func (t *Tracker) Check(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+
+	var run Totals
+	if r := t.runs[logging.ScheduledRun(ctx)]; r != nil {
+		run = *r
+	}
+
+	switch {
+	case t.budget.Limit > 0 && t.total.Cost >= t.budget.Limit:
+		metrics.ClaudeBudgetExceeded.Inc("limit")
+		return fmt.Errorf("%w: %s spent $%.2f of $%.2f", ErrLimitReached, t.month, t.total.Cost, t.budget.Limit)
+	case t.budget.Run > 0 && run.Cost >= t.budget.Run:
+		metrics.ClaudeBudgetExceeded.Inc("run")
+		return fmt.Errorf("%w: run spent $%.4f of $%.2f", ErrBudgetExceeded, run.Cost, t.budget.Run)
+	case t.budget.Month > 0 && t.total.Cost >= t.budget.Month:
+		metrics.ClaudeBudgetExceeded.Inc("month")
+		return fmt.Errorf("%w: %s spent $%.2f of $%.2f", ErrBudgetExceeded, t.month, t.total.Cost, t.budget.Month)
+	}
+	return nil
+}
+
+// Run returns the totals of a scheduled run so far
+func (t *Tracker) Run(scheduledRun string) Totals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	if run := t.runs[scheduledRun]; run != nil {
+		return *run
+	}
+	return Totals{}
+}
+
+// Month returns the totals of the current month so far
+func (t *Tracker) Month() Totals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.total
+}
@@ -0,0 +1,160 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/ledger"
+	"github.com/dpeterka/history-slackbot/internal/logging"
+)
+
+func TestParsePrices(t *testing.T) {
+	table, err := ParsePrices("claude-sonnet-4-5=2/10, my-model = 1/4/2/0.5")
+	if err != nil {
+		t.Fatalf("ParsePrices() returned error: %v", err)
+	}
+	if got, want := table["claude-sonnet-4-5"], (Price{Input: 2, Output: 10, CacheWrite: 2.5, CacheRead: 0.2}); got != want {
+		t.Errorf("claude-sonnet-4-5 = %+v, want %+v", got, want)
+	}
+	if got, want := table["my-model"], (Price{Input: 1, Output: 4, CacheWrite: 2, CacheRead: 0.5}); got != want {
+		t.Errorf("my-model = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"claude", "=1/2", "m=1", "m=1/2/3", "m=a/b", "m=-1/2"} {
+		if _, err := ParsePrices(bad); err == nil {
+			t.Errorf("ParsePrices(%q) expected an error", bad)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := DefaultPrices.Merge(PriceTable{"claude-sonnet-4": listPrice(9, 9)})
+
+	tests := []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{"claude-sonnet-4-5", 3, true},
+		{"claude-sonnet-4-5-20250929", 3, true},
+		{"claude-sonnet-4-20250514", 9, true},
+		{"claude-haiku-4-5", 1, true},
+		{"gpt-4", 0, false},
+	}
+	for _, tt := range tests {
+		price, ok := table.Lookup(tt.model)
+		if ok != tt.ok || price.Input != tt.input {
+			t.Errorf("Lookup(%q) = %v, %v; want input %v, %v", tt.model, price, ok, tt.input, tt.ok)
+		}
+	}
+}
+
+func TestPriceCost(t *testing.T) {
+	cost := listPrice(3, 15).Cost(Tokens{Input: 1000, Output: 500, CacheWrite: 2000, CacheRead: 10000})
+	// 1000*3 + 500*15 + 2000*3.75 + 10000*0.3 = 21000 per million
+	if math.Abs(cost-0.021) > 1e-9 {
+		t.Errorf("Cost() = %v, want 0.021", cost)
+	}
+}
+
+func TestTrackerBudgets(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("ledger.Open() returned error: %v", err)
+	}
+	// The ledger stamps records with the real time, which decides their month
+	now := time.Now()
+	clock := func() time.Time { return now }
+	prices := PriceTable{"claude-test": {Input: 1, Output: 1}}
+
+	tracker, err := newTracker(prices, Budget{Run: 1, Month: 2.5}, l, clock)
+	if err != nil {
+		t.Fatalf("newTracker() returned error: %v", err)
+	}
+
+	run1 := logging.WithRunID(context.Background(), "run1")
+	run2 := logging.WithRunID(context.Background(), "run2")
+
+	tracker.Record(run1, "claude-test", Tokens{Input: 600_000})
+	if err := tracker.Check(run1); err != nil {
+		t.Fatalf("Check() = %v, want nil under budget", err)
+	}
+	tracker.Record(run1, "claude-test", Tokens{Output: 400_000})
+	if err := tracker.Check(run1); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check() = %v, want the run budget exceeded", err)
+	}
+	if got := tracker.Run("run1"); got.Calls != 2 || got.Tokens.Input != 600_000 || got.Tokens.Output != 400_000 {
+		t.Errorf("Run() = %+v", got)
+	}
+
+	// Another run has its own budget, until the month's is spent
+	if err := tracker.Check(run2); err != nil {
+		t.Fatalf("Check() = %v, want nil for a new run", err)
+	}
+	tracker.Record(run2, "claude-test", Tokens{Input: 900_000})
+
+	// The month's spending is read back from the ledger after a restart
+	restarted, err := newTracker(prices, Budget{Run: 1, Month: 2.5}, l, clock)
+	if err != nil {
+		t.Fatalf("newTracker() returned error: %v", err)
+	}
+	if got := restarted.Month(); got.Calls != 3 || math.Abs(got.Cost-1.9) > 1e-9 {
+		t.Errorf("Month() after restart = %+v, want 3 calls costing $1.90", got)
+	}
+	restarted.Record(run2, "claude-test", Tokens{Input: 600_000})
+	run3 := logging.WithRunID(context.Background(), "run3")
+	if err := restarted.Check(run3); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check() = %v, want the month budget exceeded", err)
+	}
+
+	// A new month starts from zero
+	now = now.AddDate(0, 1, 0)
+	if err := restarted.Check(run3); err != nil {
+		t.Errorf("Check() in a new month = %v, want nil", err)
+	}
+	if got := restarted.Month(); got.Calls != 0 {
+		t.Errorf("Month() in a new month = %+v, want zero", got)
+	}
+}
+
+func TestTrackerRunCoversRetries(t *testing.T) {
+	prices := PriceTable{"claude-test": {Input: 1, Output: 1}}
+	tracker, err := newTracker(prices, Budget{Run: 1}, nil, time.Now)
+	if err != nil {
+		t.Fatalf("newTracker() returned error: %v", err)
+	}
+
+	scheduled := logging.WithScheduledRun(context.Background(), "scheduled1")
+	attempt1 := logging.WithRunID(scheduled, "attempt1")
+	attempt2 := logging.WithRunID(scheduled, "attempt2")
+
+	tracker.Record(attempt1, "claude-test", Tokens{Input: 1_000_000})
+	if err := tracker.Check(attempt2); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check() = %v, want a retry to share its run's budget", err)
+	}
+	if got := tracker.Run("scheduled1"); got.Calls != 1 {
+		t.Errorf("Run() = %+v, want the first attempt's call", got)
+	}
+}
+
+func TestTrackerLimit(t *testing.T) {
+	prices := PriceTable{"claude-test": {Input: 1, Output: 1}}
+	tracker, err := newTracker(prices, Budget{Month: 1, Limit: 2}, nil, time.Now)
+	if err != nil {
+		t.Fatalf("newTracker() returned error: %v", err)
+	}
+	ctx := logging.WithRunID(context.Background(), "run1")
+
+	tracker.Record(ctx, "claude-test", Tokens{Input: 1_000_000})
+	if err := tracker.Check(ctx); !errors.Is(err, ErrBudgetExceeded) || errors.Is(err, ErrLimitReached) {
+		t.Fatalf("Check() = %v, want the month budget exceeded but not the limit", err)
+	}
+	tracker.Record(ctx, "claude-test", Tokens{Input: 1_000_000})
+	if err := tracker.Check(ctx); !errors.Is(err, ErrLimitReached) || !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check() = %v, want the limit reached", err)
+	}
+}
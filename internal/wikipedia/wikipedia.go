@@ -0,0 +1,220 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+// DefaultBaseURL is the Wikimedia API host serving the "onthisday" feed
+const DefaultBaseURL = "https://api.wikimedia.org"
+
+// userAgent identifies the bot, as required by the Wikimedia API policy
+const userAgent = "history-slackbot/1.0 (https://github.com/dpeterka/history-slackbot)"
+
+// Entry types served by the "onthisday" feed
+const (
+	TypeSelected = "selected"
+	TypeEvents   = "events"
+	TypeBirths   = "births"
+	TypeDeaths   = "deaths"
+	TypeHolidays = "holidays"
+)
+
+// AllTypes lists every entry type, in the order they're mapped to events
+var AllTypes = []string{TypeSelected, TypeEvents, TypeBirths, TypeDeaths, TypeHolidays}
+
+// Feed is the response of the "onthisday" endpoint
+type Feed struct {
+	Selected []Entry `json:"selected"`
+	Events   []Entry `json:"events"`
+	Births   []Entry `json:"births"`
+	Deaths   []Entry `json:"deaths"`
+	Holidays []Entry `json:"holidays"`
+}
+
+// Entry is a single "onthisday" item. Holidays have no year.
+type Entry struct {
+	Text  string `json:"text"`
+	Year  *int   `json:"year,omitempty"`
+	Pages []Page `json:"pages"`
+}
+
+// Page is a Wikipedia article linked from an entry
+type Page struct {
+	Title       string      `json:"title"`
+	Titles      Titles      `json:"titles"`
+	Extract     string      `json:"extract"`
+	Thumbnail   *Image      `json:"thumbnail,omitempty"`
+	ContentURLs ContentURLs `json:"content_urls"`
+}
+
+// Titles holds the display forms of a page title
+type Titles struct {
+	Normalized string `json:"normalized"`
+}
+
+// Image is a page thumbnail
+type Image struct {
+	Source string `json:"source"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ContentURLs holds links to a page
+type ContentURLs struct {
+	Desktop struct {
+		Page string `json:"page"`
+	} `json:"desktop"`
+}
+
+// Client fetches the Wikimedia "onthisday" feed
+type Client struct {
+	baseURL  string
+	language string
+	client   *http.Client
+}
+
+// NewClient creates a client for the given Wikipedia language edition (e.g. "en")
+func NewClient(baseURL, language string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if language == "" {
+		language = "en"
+	}
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		language: language,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// FetchFeed fetches all entry types for the given date's month and day
+func (c *Client) FetchFeed(ctx context.Context, date time.Time) (*Feed, error) {
+	url := fmt.Sprintf("%s/feed/v1/wikipedia/%s/onthisday/all/%02d/%02d",
+		c.baseURL, c.language, int(date.Month()), date.Day())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch onthisday feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return ParseFeed(body)
+}
+
+// ParseFeed parses an "onthisday" JSON response
+func ParseFeed(data []byte) (*Feed, error) {
+	var feed Feed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("failed to parse onthisday feed: %w", err)
+	}
+	return &feed, nil
+}
+
+// ToEvents maps the requested entry types to historical events. Holidays are
+// left out; use ToHolidays for those.
+func (f *Feed) ToEvents(types []string) []rss.HistoricalEvent {
+	var events []rss.HistoricalEvent
+	seen := make(map[string]bool)
+
+	for _, entryType := range types {
+		var entries []Entry
+		kind := rss.KindEvent
+		switch entryType {
+		case TypeSelected:
+			entries = f.Selected
+		case TypeEvents:
+			entries = f.Events
+		case TypeBirths:
+			entries, kind = f.Births, rss.KindBirth
+		case TypeDeaths:
+			entries, kind = f.Deaths, rss.KindDeath
+		default:
+			continue
+		}
+
+		for _, entry := range entries {
+			event := entry.toEvent(kind)
+			// "selected" entries usually repeat ones from "events"
+			key := event.Year + "|" + event.Title
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// ToHolidays maps holiday and observance entries to holidays
+func (f *Feed) ToHolidays() []rss.Holiday {
+	holidays := make([]rss.Holiday, 0, len(f.Holidays))
+	for _, entry := range f.Holidays {
+		holiday := rss.Holiday{Title: strings.TrimSpace(entry.Text)}
+		if len(entry.Pages) > 0 {
+			holiday.Description = entry.Pages[0].Extract
+			holiday.Link = entry.Pages[0].ContentURLs.Desktop.Page
+		}
+		holidays = append(holidays, holiday)
+	}
+	return holidays
+}
+
+// toEvent maps an entry to a historical event. The first linked page supplies
+// the description, link and image; every page link is kept.
+func (e Entry) toEvent(kind rss.EventKind) rss.HistoricalEvent {
+	event := rss.HistoricalEvent{
+		Kind:  kind,
+		Title: strings.TrimSpace(e.Text),
+	}
+	if e.Year != nil {
+		// Wikipedia numbers BC years as negative, as SortYear does
+		event.Year = rss.FormatYear(*e.Year)
+		event.SortYear = *e.Year
+	}
+
+	for _, page := range e.Pages {
+		link := page.ContentURLs.Desktop.Page
+		if link == "" {
+			continue
+		}
+		event.Links = append(event.Links, link)
+		if event.Link == "" {
+			event.Link = link
+			event.Description = page.Extract
+		}
+		if event.ImageURL == "" && page.Thumbnail != nil {
+			event.ImageURL = page.Thumbnail.Source
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,124 @@
+package wikipedia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeterka/history-slackbot/internal/rss"
+)
+
+func loadFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/onthisday_07_20.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+func TestFetchFeed(t *testing.T) {
+	fixture := loadFixture(t)
+
+	var gotPath, gotUserAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUserAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fixture)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "en")
+	feed, err := client.FetchFeed(context.Background(), time.Date(2024, 7, 20, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("FetchFeed() returned error: %v", err)
+	}
+
+	if gotPath != "/feed/v1/wikipedia/en/onthisday/all/07/20" {
+		t.Errorf("request path = %q, want /feed/v1/wikipedia/en/onthisday/all/07/20", gotPath)
+	}
+	if !strings.HasPrefix(gotUserAgent, "history-slackbot/") {
+		t.Errorf("User-Agent = %q, want the bot's own user agent", gotUserAgent)
+	}
+	if len(feed.Events) != 3 || len(feed.Births) != 1 || len(feed.Holidays) != 1 {
+		t.Errorf("feed has %d events, %d births, %d holidays; want 3, 1, 1", len(feed.Events), len(feed.Births), len(feed.Holidays))
+	}
+}
+
+func TestFetchFeedErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "en").FetchFeed(context.Background(), time.Now())
+	if err == nil {
+		t.Error("FetchFeed() should return error for non-200 status")
+	}
+}
+
+func TestToEvents(t *testing.T) {
+	feed, err := ParseFeed(loadFixture(t))
+	if err != nil {
+		t.Fatalf("ParseFeed() returned error: %v", err)
+	}
+
+	events := feed.ToEvents([]string{TypeSelected, TypeEvents, TypeBirths})
+
+	// The "selected" Apollo entry duplicates the "events" one
+	if len(events) != 4 {
+		t.Fatalf("len(events) = %d, want 4: %+v", len(events), events)
+	}
+
+	apollo := events[0]
+	if apollo.Kind != rss.KindEvent || apollo.Year != "1969" {
+		t.Errorf("Kind, Year = %q, %q, want event, 1969", apollo.Kind, apollo.Year)
+	}
+	if apollo.Link != "https://en.wikipedia.org/wiki/Apollo_11" {
+		t.Errorf("Link = %q, want the first page's link", apollo.Link)
+	}
+	if len(apollo.Links) != 2 {
+		t.Errorf("Links = %v, want both page links", apollo.Links)
+	}
+	if !strings.Contains(apollo.ImageURL, "Apollo_11_insignia") {
+		t.Errorf("ImageURL = %q, want the first page's thumbnail", apollo.ImageURL)
+	}
+	if apollo.Description != "Apollo 11 was the American spaceflight that first landed humans on the Moon." {
+		t.Errorf("Description = %q, want the first page's extract", apollo.Description)
+	}
+
+	if events[2].Year != "356 BC" || events[2].SortYear != -356 || events[2].Link != "" {
+		t.Errorf("entry without pages = %+v, want year 356 BC and no link", events[2])
+	}
+
+	birth := events[3]
+	if birth.Kind != rss.KindBirth || birth.Year != "1938" {
+		t.Errorf("birth = %+v, want kind birth and year 1938", birth)
+	}
+}
+
+func TestToHolidays(t *testing.T) {
+	feed, err := ParseFeed(loadFixture(t))
+	if err != nil {
+		t.Fatalf("ParseFeed() returned error: %v", err)
+	}
+
+	holidays := feed.ToHolidays()
+	if len(holidays) != 1 {
+		t.Fatalf("len(holidays) = %d, want 1", len(holidays))
+	}
+	if holidays[0].Title != "International Chess Day" || holidays[0].Link != "https://en.wikipedia.org/wiki/International_Chess_Day" {
+		t.Errorf("holiday = %+v", holidays[0])
+	}
+}
+
+func TestParseFeedInvalid(t *testing.T) {
+	if _, err := ParseFeed([]byte("<html>")); err == nil {
+		t.Error("ParseFeed() should return error for invalid JSON")
+	}
+}